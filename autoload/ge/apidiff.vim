@@ -0,0 +1,26 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" diff compares the exported API of two versions of the package in the
+" directory of the current buffer and writes the changes to the quickfix list.
+" A version is a directory or a git revision. The new version defaults to the
+" directory of the current buffer.
+"
+" The caller must execute the return value to report errors.
+function! ge#apidiff#diff(old, ...) abort
+    let new = a:0 >= 1 ? a:1 : '.'
+    try
+        let out = ge#tool#runl('', '-cwd', expand('%:p:h'), 'apidiff', a:old, new)
+        call filter(out, 'v:val !=# ""')
+        if len(out) == 0
+            echo 'No API changes'
+        endif
+        cexpr out
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" vim:ts=4:sw=4:et
@@ -0,0 +1,73 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" s:pages maps godoc-calls:// URLs to tool output computed before the page
+" was opened.
+let s:pages = {}
+
+" open opens the tree of callers or callees for the function under the
+" cursor. The mode argument is 'callers' or 'callees'. In a doc page, the
+" function is the declaration on the cursor line.
+"
+" The caller must execute the return value to report errors.
+function! ge#calls#open(mode) abort
+    try
+        if &filetype ==# 'gedoc'
+            let name = ge#doc#cursor_anchor()
+            let m = matchlist(expand('%'), '\C\v^godoc://([^?#]*)')
+            if name ==# '' || len(m) == 0
+                return 'echoerr "go-explorer: no declaration on cursor line"'
+            endif
+            let url = 'godoc-calls://' . a:mode . '/' . m[1] . '#' . name
+        else
+            let buf = join(getline(1, '$'), "\n")
+            let offset = line2byte(line('.')) + col('.') - 2
+            let out = ge#tool#runl(buf, '-cwd', expand('%:p:h'), a:mode, '-offset=' . offset, expand('%:p'))
+            let url = ''
+            for line in out
+                let m = matchlist(line, '\C\v^N (.*)$')
+                if len(m)
+                    let url = m[1]
+                    break
+                endif
+            endfor
+            if url ==# ''
+                return 'echoerr ' . string('go-explorer: ' . join(out[index(out, 'E') + 1:], ' '))
+            endif
+            let s:pages[url] = out
+        endif
+        call ge#doc#push_tag(expand('<cword>'))
+        return 'edit ' . fnameescape(url)
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+endfunction
+
+" read loads a buffer with a call tree. This function is intended to be called
+" from a BufReadCmd event.
+"
+" The caller must execute the return value to report errors.
+function! ge#calls#read() abort
+    try
+        let url = expand('%')
+        if has_key(s:pages, url)
+            let out = remove(s:pages, url)
+        else
+            let m = matchlist(url, '\C\v^godoc-calls://(callers|callees)/(.*)#(.*)$')
+            if len(m) == 0
+                return 'echoerr ' . string('go-explorer: bad URL ' . url)
+            endif
+            let out = ge#tool#runl('', m[1], m[2], m[3])
+        endif
+        call ge#doc#load(out)
+        setlocal foldmethod=indent shiftwidth=4 foldlevel=99 foldcolumn=0
+        setfiletype gedoc
+        silent 0
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" vim:ts=4:sw=4:et
@@ -0,0 +1,32 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" check runs the static analysis suite on the package of the current buffer.
+" The unsaved contents of the buffer are used in place of the file on disk.
+" Findings are written to the quickfix window if error_list is equal to 'c' or
+" the location list if error_list is equal to 'l'. Uses of deprecated
+" identifiers are reported if g:ge_check_deprecated is set.
+"
+" The caller must execute the return value to report errors.
+function! ge#check#check(error_list) abort
+    try
+        let buf = join(getline(1, '$'), "\n")
+        let args = ['-cwd', expand('%:p:h'), 'check']
+        if get(g:, 'ge_check_deprecated', 0)
+            call add(args, '-deprecated')
+        endif
+        let out = call('ge#tool#runl', [buf] + args + [expand('%:p')])
+        call filter(out, 'v:val !=# ""')
+        if a:error_list ==# 'l'
+            lexpr out
+        else
+            cexpr out
+        endif
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" vim:ts=4:sw=4:et
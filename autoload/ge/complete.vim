@@ -14,9 +14,16 @@ function! s:import_text() abort
     return join(getline(1, n), "\n") . ' '
 endfunction
 
+function! s:file_args() abort
+    if &filetype !=# 'go' || expand('%:p') ==# ''
+        return []
+    endif
+    return ['-file', expand('%:p')]
+endfunction
+
 function! ge#complete#complete_package_id(arg, line, pos) abort
     try
-        return ge#tool#runl(s:import_text(), '-cwd', expand('%:p:h'), 'complete-package-id', a:arg, a:line, a:pos)
+        return call('ge#tool#runl', [s:import_text(), '-cwd', expand('%:p:h'), 'complete-package-id'] + s:file_args() + [a:arg, a:line, a:pos])
     catch /^go-explorer:/
         echom v:errmsg
         return a:arg
@@ -24,7 +31,7 @@ function! ge#complete#complete_package_id(arg, line, pos) abort
 endfunction
 
 function! ge#complete#resolve_package(arg) abort
-    return ge#tool#run(s:import_text(), '-cwd', expand('%:p:h'), 'resolve-package', a:arg)
+    return call('ge#tool#run', [s:import_text(), '-cwd', expand('%:p:h'), 'resolve-package'] + s:file_args() + [a:arg])
 endfunction
 
 " vim:ts=4:sw=4:et
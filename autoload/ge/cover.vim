@@ -0,0 +1,83 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" s:ranges maps file names to lists of [group, start, end] where start and end
+" are positions encoded as line*10000+column.
+let s:ranges = {}
+
+highlight default link GeCovered DiffAdd
+highlight default link GeUncovered DiffDelete
+
+" cover runs the tests of the package in the directory of the current buffer
+" or the package given by ... and highlights the covered and uncovered
+" statements in the windows showing files of the package.
+"
+" The caller must execute the return value to report errors.
+function! ge#cover#cover(...) abort
+    let args = a:0 >= 1 ? [a:1] : []
+    try
+        let out = call('ge#tool#runl', ['', '-cwd', expand('%:p:h'), 'cover'] + args)
+        if out[0] ==# 'ERR'
+            cexpr out[1:]
+            return ''
+        endif
+        let s:ranges = {}
+        let ranges = []
+        for line in out
+            let m = matchlist(line, '\C\v^F (.*)$')
+            if len(m)
+                let ranges = []
+                let s:ranges[m[1]] = ranges
+                continue
+            endif
+            let m = matchlist(line, '\C\v^([CU]) ([0-9]+) ([0-9]+)$')
+            if len(m)
+                call add(ranges, [m[1] ==# 'C' ? 'GeCovered' : 'GeUncovered', str2nr(m[2]), str2nr(m[3])])
+                continue
+            endif
+            let m = matchlist(line, '\C\v^S (.*)$')
+            if len(m)
+                echo m[1]
+            endif
+        endfor
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    call s:update_windows()
+    augroup ge_cover
+        autocmd!
+        autocmd BufWinEnter *.go call ge#cover#update()
+    augroup END
+    return ''
+endfunction
+
+" clear removes the coverage highlights.
+function! ge#cover#clear() abort
+    let s:ranges = {}
+    call s:update_windows()
+    augroup ge_cover
+        autocmd!
+    augroup END
+endfunction
+
+function! s:update_windows() abort
+    let win = winnr()
+    windo call ge#cover#update()
+    execute win . 'wincmd w'
+endfunction
+
+" update sets the coverage highlights in the current window.
+function! ge#cover#update() abort
+    for id in get(w:, 'ge_cover_matches', [])
+        silent! call matchdelete(id)
+    endfor
+    let w:ge_cover_matches = []
+    for [group, start, end] in get(s:ranges, expand('%:p'), [])
+        let [l1, c1, l2, c2] = [start / 10000, start % 10000, end / 10000, end % 10000]
+        let pattern = printf('\%%(\%%>%dl\|\%%%dl\%%>%dc\)\%%(\%%<%dl\|\%%%dl\%%<%dc\).', l1, l1, c1 - 1, l2, l2, c2)
+        call add(w:ge_cover_matches, matchadd(group, pattern, 5))
+    endfor
+endfunction
+
+" vim:ts=4:sw=4:et
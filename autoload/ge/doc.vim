@@ -8,88 +8,117 @@
 " The caller must execute the return value to report errors.
 function! ge#doc#read() abort
     try
-        setlocal noreadonly modifiable
-        let b:strings = []
-        let b:links = []
-        let b:anchors = {}
         if !exists("b:gedoc_showall")
             let b:gedoc_showall = 0
         endif
+        let args = ['doc']
         if b:gedoc_showall
-            let out = ge#tool#runl('', 'doc', '--all', expand('%'))
-        else
-            let out = ge#tool#runl('', 'doc', expand('%'))
+            call add(args, '--all')
         endif
-        let index = 0
-        while index < len(out)
-            let line = out[index]
-            let index = index + 1
-            let m = matchlist(line, '\C\v^S (.*)')
-            if len(m)
-                " String
-                let b:strings = add(b:strings, m[1])
-                continue
-            endif
-            let m = matchlist(line, '\C\v^L ([0-9]+) ([0-9]+) ([0-9]+) ([-0-9]+)$')
-            if len(m)
-                " Link: start, end, file, position
-                call add(b:links, [str2nr(m[1]), str2nr(m[2]), str2nr(m[3]), str2nr(m[4])])
-                continue
-            endif
-            let m = matchlist(line, '\C\v^A ([0-9]+) (\S+)$')
-            if len(m)
-                " Anchor: position, name
-                let b:anchors[m[2]] = str2nr(m[1])
-                continue
-            endif
-             if line ==# 'D'
-                " Document
-                call append(0, out[index : -1])
-                break
-            endif
-            if line ==# 'E'
-                " Error
-                call append(0, out[index : -1])
-                setlocal buftype=nofile bufhidden=delete nobuflisted noswapfile nomodifiable
-                break
-            end
-        endwhile
+        if get(g:, 'ge_doc_maxgo', '') !=# ''
+            call add(args, '--maxgo=' . g:ge_doc_maxgo)
+        endif
+        let out = call('ge#tool#runl', [''] + args + [expand('%')])
+        call ge#doc#load(out)
         setlocal foldlevel=1 foldtext=ge#doc#foldtext() foldcolumn=0 foldmethod=syntax
-        setlocal buftype=nofile bufhidden=hide noswapfile nomodifiable readonly
-        setlocal nonumber tabstop=4
         setfiletype gedoc
+        call s:close_folds()
         silent 0
-        nnoremap <buffer> <silent> <c-]> :execute <SID>jump()<CR>
-        nnoremap <buffer> <silent> <c-t> :execute <SID>pop()<CR>
         nnoremap <buffer> <silent> <c-a> :execute <SID>toggle_all()<CR>
+        nnoremap <buffer> <silent> <c-x> :execute <SID>toggle_context()<CR>
+        nnoremap <buffer> <silent> I :execute ge#import#import_doc()<CR>
         nnoremap <buffer> <silent> ]] :execute <SID>next_section('')<CR>
         nnoremap <buffer> <silent> [[ :execute <SID>next_section('b')<CR>
-        noremap <buffer> <silent> <2-LeftMouse> :execute <SID>jump()<CR>
-        autocmd! * <buffer>
-        autocmd BufWinLeave <buffer> execute s:clear_highlight()
-        autocmd CursorMoved <buffer> execute s:update_highlight()
     catch /^go-explorer:/
         return 'echoerr v:errmsg'
     endtry
     return ''
 endfunction
 
+" load fills the current buffer with the output of a getool command using the
+" doc protocol and sets the buffer options and mappings for following links.
+function! ge#doc#load(out) abort
+    setlocal noreadonly modifiable
+    let b:strings = []
+    let b:links = []
+    let b:anchors = {}
+    let out = a:out
+    let index = 0
+    while index < len(out)
+        let line = out[index]
+        let index = index + 1
+        let m = matchlist(line, '\C\v^S (.*)')
+        if len(m)
+            " String
+            let b:strings = add(b:strings, m[1])
+            continue
+        endif
+        let m = matchlist(line, '\C\v^L ([0-9]+) ([0-9]+) ([0-9]+) ([-0-9]+)$')
+        if len(m)
+            " Link: start, end, file, position
+            call add(b:links, [str2nr(m[1]), str2nr(m[2]), str2nr(m[3]), str2nr(m[4])])
+            continue
+        endif
+        let m = matchlist(line, '\C\v^A ([0-9]+) (\S+)$')
+        if len(m)
+            " Anchor: position, name
+            let b:anchors[m[2]] = str2nr(m[1])
+            continue
+        endif
+        if line ==# 'D'
+            " Document
+            call append(0, out[index : -1])
+            break
+        endif
+        if line ==# 'E'
+            " Error
+            call append(0, out[index : -1])
+            setlocal buftype=nofile bufhidden=delete nobuflisted noswapfile nomodifiable
+            break
+        end
+    endwhile
+    setlocal buftype=nofile bufhidden=hide noswapfile nomodifiable readonly
+    setlocal nonumber tabstop=4
+    nnoremap <buffer> <silent> <c-]> :execute <SID>jump()<CR>
+    nnoremap <buffer> <silent> <c-t> :execute <SID>pop()<CR>
+    noremap <buffer> <silent> <2-LeftMouse> :execute <SID>jump()<CR>
+    autocmd! * <buffer>
+    autocmd BufWinLeave <buffer> execute s:clear_highlight()
+    autocmd CursorMoved <buffer> execute s:update_highlight()
+endfunction
+
+" close_folds closes the folds containing deprecated declarations and the
+" TESTS section.
+function! s:close_folds() abort
+    for lnum in range(1, line('$'))
+        if getline(lnum) =~# '\C\v^(Deprecated (constants|variables|functions|types)|TESTS)$'
+            silent! execute lnum . 'foldclose'
+        endif
+    endfor
+endfunction
+
 " open implements the GeDoc command.
 function! ge#doc#open(...) abort
     if a:0 < 1 || a:0 > 2
        return 'echoerr "one or two arguments required"'
     endif
     let pos = 0
-    if a:0 >= 2
-        let pos = a:2
+    try
+        let [p; anchor] = split(ge#complete#resolve_package(a:1), "\n", 1)
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    if a:0 >= 2 || len(anchor) > 0
+        let pos = a:0 >= 2 ? a:2 : anchor[0]
+        let pos = substitute(pos, '\V(deprecated)', '', 'g')
         if len(pos) > 0 && pos[-1:] ==# '.'
             let pos = pos[:-2]
         endif
         let pos = "'" . escape(pos, '\') . "'"
     endif
     try
-        let p = ge#complete#resolve_package(a:1)
         if &filetype !=# 'gedoc'
+            let source = bufnr('%')
             let thiswin = winnr()
             exe "norm! \<C-W>b"
             if winnr() > 1
@@ -107,6 +136,7 @@ function! ge#doc#open(...) abort
             if &filetype !=# 'gedoc'
                 new
             endif
+            let w:gedoc_source = source
         endif
         return 'edit godoc://' . p  .  ' | call ge#doc#go_to_pos(' . pos . ')'
     catch /^go-explorer:/
@@ -180,6 +210,22 @@ function ge#doc#go_to_pos(pos) abort
     exec 'normal! 0' . (pos % 10000 - 1) . 'l'
 endfunction
 
+" cursor_anchor returns the name of the anchor on the cursor line of a doc
+" page. The last anchor before the cursor is preferred.
+function! ge#doc#cursor_anchor() abort
+    let start = 10000 * line('.')
+    let cursor = start + col('.')
+    let name = ''
+    let pos = 0
+    for [id, p] in items(get(b:, 'anchors', {}))
+        if p >= start && p < start + 10000 && (name ==# '' || (p <= cursor && p > pos))
+            let name = id
+            let pos = p
+        endif
+    endfor
+    return name
+endfunction
+
 let s:stack = []
 
 function <SID>jump() abort
@@ -196,20 +242,32 @@ function <SID>jump() abort
 
     let file = b:strings[link[2]]
 
-    if file ==# '' || match(file, '^godoc://') == 0
+    if file ==# '' || match(file, '\v^godoc(-calls)?://') == 0
         call add(s:stack, [bufnr('%'), line('.'), col('.')])
+    else
+        call ge#doc#push_tag(expand('<cword>'))
     endif
 
     let cmd = 'call ge#doc#go_to_pos(' . pos . ')'
     if file !=# ''
-        let cmd = 'edit ' . file . ' | ' . cmd
+        let cmd = 'edit ' . fnameescape(file) . ' | ' . cmd
     endif
     return cmd
 endfunction
 
+" push_tag pushes the cursor position on the tag stack so that CTRL-T in the
+" file opened by a link returns to the page.
+function ge#doc#push_tag(name) abort
+    if exists('*settagstack')
+        let item = {'bufnr': bufnr('%'), 'from': [bufnr('%'), line('.'), col('.'), 0], 'tagname': a:name}
+        call settagstack(winnr(), {'items': [item]}, 't')
+    endif
+endfunction
+
 function <SID>pop() abort
     if len(s:stack) == 0
-        return ''
+        " The page was opened from a file.
+        return 'silent! pop'
     endif
     let p = s:stack[-1]
     let s:stack = s:stack[:-2]
@@ -224,6 +282,23 @@ function! <SID>toggle_all() abort
     edit
 endfunction
 
+" toggle_context shows the documentation for the next build context in
+" g:ge_doc_contexts. The build context is the query in the buffer name.
+function! <SID>toggle_context() abort
+    let contexts = get(g:, 'ge_doc_contexts', ['', 'goos=windows', 'goos=darwin'])
+    let m = matchlist(expand('%'), '\C\v^([^?#]*)\??([^#]*)(#.*)?$')
+    let i = index(contexts, m[2]) + 1
+    if i >= len(contexts)
+        let i = 0
+    endif
+    let name = m[1]
+    if contexts[i] !=# ''
+        let name = name . '?' . contexts[i]
+    endif
+    let name = name . m[3]
+    return 'edit ' . fnameescape(name) . ' | call cursor(' . line('.') . ', ' . col('.') . ')'
+endfunction
+
 function <SID>next_section(dir) abort
     call search('\C\v^[^ \t)}]', 'W' . a:dir)
     return ''
@@ -0,0 +1,90 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" replace replaces lines start through end of buffer buf with lines.
+function! ge#edit#replace(buf, start, end, lines) abort
+    if a:start <= a:end
+        silent call deletebufline(a:buf, a:start, a:end)
+    endif
+    call appendbufline(a:buf, a:start - 1, a:lines)
+endfunction
+
+" replace_output applies getool output in the fmt command protocol to buffer
+" buf. The caller must execute the return value to report errors.
+function! ge#edit#replace_output(buf, out) abort
+    if a:out[0] ==# 'ERR'
+        return 'echoerr ' . string(join(a:out[1:], ' '))
+    endif
+    if a:out[0] ==# 'OK'
+        return ''
+    endif
+    let m = matchlist(a:out[0], '\C\v^REPL ([0-9]+) ([0-9]+)$')
+    if len(m)
+        call ge#edit#replace(a:buf, m[1] + 0, m[2] + 0, a:out[1:])
+        return ''
+    endif
+    return 'echoerr ' . string(a:out[0])
+endfunction
+
+" parse parses output in the edit set protocol. The result is a list of
+" [file, hunks] where each hunk is [start, end, lines].
+function! ge#edit#parse(out) abort
+    let edits = []
+    let index = 0
+    while index < len(a:out)
+        let line = a:out[index]
+        let index = index + 1
+        let m = matchlist(line, '\C\v^FILE (.*)$')
+        if len(m)
+            call add(edits, [m[1], []])
+            continue
+        endif
+        let m = matchlist(line, '\C\v^REPL ([0-9]+) ([0-9]+) ([0-9]+)$')
+        if len(m) && len(edits)
+            let n = str2nr(m[3])
+            call add(edits[-1][1], [str2nr(m[1]), str2nr(m[2]), a:out[index : index + n - 1]])
+            let index = index + n
+        endif
+    endwhile
+    return edits
+endfunction
+
+" preview returns a description of the edits returned by parse.
+function! ge#edit#preview(edits) abort
+    let lines = []
+    for [file, hunks] in a:edits
+        for [start, end, replacement] in reverse(copy(hunks))
+            let i = 0
+            for l in replacement
+                call add(lines, fnamemodify(file, ':~:.') . ':' . (start + i) . ': ' . l)
+                let i = i + 1
+            endfor
+        endfor
+    endfor
+    return lines
+endfunction
+
+" apply applies the edits returned by parse. Edits to files loaded in a
+" buffer are applied to the buffer. Other files are edited on disk.
+function! ge#edit#apply(edits) abort
+    for [file, hunks] in a:edits
+        let buf = bufnr('^' . fnameescape(file) . '$')
+        if buf > 0 && bufloaded(buf)
+            for [start, end, lines] in hunks
+                call ge#edit#replace(buf, start, end, lines)
+            endfor
+            continue
+        endif
+        let contents = readfile(file, 'b')
+        for [start, end, lines] in hunks
+            if start <= end
+                call remove(contents, start - 1, end - 1)
+            endif
+            call extend(contents, lines, start - 1)
+        endfor
+        call writefile(contents, file, 'b')
+    endfor
+endfunction
+
+" vim:ts=4:sw=4:et
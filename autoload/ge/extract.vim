@@ -0,0 +1,43 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" func extracts the statements on lines first through last of the current
+" buffer to a new function. The optional argument is the function name.
+"
+" The caller must execute the return value to report errors.
+function! ge#extract#func(first, last, ...) abort
+    return s:extract(['-func', '-lines=' . a:first . ',' . a:last], a:000)
+endfunction
+
+" var extracts the expression selected in visual mode to a local variable.
+" The optional argument is the variable name.
+"
+" The caller must execute the return value to report errors.
+function! ge#extract#var(...) abort
+    let [l1, c1] = getpos("'<")[1:2]
+    let [l2, c2] = getpos("'>")[1:2]
+    let c2 = min([c2, len(getline(l2))])
+    let start = line2byte(l1) + c1 - 2
+    let end = line2byte(l2) + c2 - 1
+    return s:extract(['-var', '-offset=' . start . ',' . end], a:000)
+endfunction
+
+function! s:extract(args, name) abort
+    try
+        let buf = join(getline(1, '$'), "\n")
+        let args = ['-cwd', expand('%:p:h'), 'extract'] + a:args
+        if len(a:name)
+            call add(args, '-name=' . a:name[0])
+        endif
+        let out = call('ge#tool#runl', [buf] + args + [expand('%:p')])
+        let v = winsaveview()
+        let cmd = ge#edit#replace_output(bufnr('%'), out)
+        call winrestview(v)
+        return cmd
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+endfunction
+
+" vim:ts=4:sw=4:et
@@ -0,0 +1,23 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" fill adds the missing fields with zero values to the struct literal under
+" the cursor.
+"
+" The caller must execute the return value to report errors.
+function! ge#fillstruct#fill() abort
+    try
+        let buf = join(getline(1, '$'), "\n")
+        let offset = line2byte(line('.')) + col('.') - 2
+        let out = ge#tool#runl(buf, '-cwd', expand('%:p:h'), 'fillstruct', '-offset=' . offset, expand('%:p'))
+        let v = winsaveview()
+        let cmd = ge#edit#replace_output(bufnr('%'), out)
+        call winrestview(v)
+        return cmd
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+endfunction
+
+" vim:ts=4:sw=4:et
@@ -7,11 +7,28 @@
 " Errors are written to the quickfix window if error_list is equal to 'c' or
 " the location list of error_list is equal to 'l'.
 "
+" If the optional arguments first and last are given and the range does not
+" cover the whole buffer, then only the statements or declarations covering
+" lines first through last are formatted.
+"
+" If g:ge_fmt_simplify is set, the code is simplified as with gofmt -s. The
+" list g:ge_fmt_rewrite holds gofmt -r style rewrite rules to apply.
+"
 " The caller must execute the return value to report errors.
-function! ge#fmt#format(error_list, with_goimports) abort
+function! ge#fmt#format(error_list, with_goimports, ...) abort
     try
         let buf = join(getline(1, '$'), "\n")
-        let out = ge#tool#runl(buf, 'fmt', '-goimport=' . a:with_goimports, expand('%:p'))
+        let args = ['fmt', '-goimport=' . a:with_goimports]
+        if get(g:, 'ge_fmt_simplify', 0)
+            call add(args, '-simplify')
+        endif
+        for rule in get(g:, 'ge_fmt_rewrite', [])
+            call add(args, '-rewrite=' . rule)
+        endfor
+        if a:0 == 2 && (a:1 > 1 || a:2 < line('$'))
+            call add(args, '-lines=' . a:1 . ',' . a:2)
+        endif
+        let out = call('ge#tool#runl', [buf] + args + [expand('%:p')])
         if out[0] ==# 'ERR'
             if a:error_list ==# 'c'
                 cexpr out[1:]
@@ -28,12 +45,7 @@ function! ge#fmt#format(error_list, with_goimports) abort
         let m = matchlist(out[0], '\C\v^REPL ([0-9]+) ([0-9]+)$')
         if len(m)
             let v = winsaveview()
-            let start = m[1] + 0
-            let end = m[2] + 0
-            if start <= end
-                silent execute start . ',' . end . 'd'
-            endif
-            call append(start-1, out[1:])
+            call ge#edit#replace(bufnr('%'), m[1] + 0, m[2] + 0, out[1:])
             call winrestview(v)
             return ''
         endif
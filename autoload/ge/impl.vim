@@ -0,0 +1,46 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" impl inserts stubs for the methods of an interface below the current line.
+" The last argument is the interface. The other arguments are joined to form
+" the method receiver. Imports needed by the stubs are added to the buffer.
+"
+" The caller must execute the return value to report errors.
+function! ge#impl#impl(...) abort
+    if a:0 < 2
+        return 'echoerr "receiver and interface required"'
+    endif
+    try
+        let buf = join(getline(1, '$'), "\n")
+        let recv = join(a:000[:-2])
+        let out = ge#tool#runl(buf, '-cwd', expand('%:p:h'), 'impl', recv, a:000[-1], expand('%:p'))
+        if out[0] ==# 'ERR'
+            return 'echoerr ' . string(join(out[1:], ' '))
+        endif
+        let imports = []
+        while len(out) && out[0] =~# '\C^IMPORT '
+            call add(imports, out[0][7:])
+            let out = out[1:]
+        endwhile
+        if len(out) == 0
+            echo 'All methods are implemented'
+            return ''
+        endif
+        if out[-1] ==# ''
+            let out = out[:-2]
+        endif
+        call append(line('.'), [''] + out)
+        for path in imports
+            let cmd = ge#import#import(bufnr('%'), 'add', path)
+            if cmd !=# ''
+                return cmd
+            endif
+        endfor
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" vim:ts=4:sw=4:et
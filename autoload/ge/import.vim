@@ -0,0 +1,35 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" import edits the import declarations of buffer buf. The cmd argument is
+" 'add' or 'drop'. The optional argument is the name for an added import.
+"
+" The caller must execute the return value to report errors.
+function! ge#import#import(buf, cmd, path, ...) abort
+    try
+        let buf = join(getbufline(a:buf, 1, '$'), "\n")
+        let out = call('ge#tool#runl', [buf, 'import', a:cmd, a:path] + a:000)
+        return ge#edit#replace_output(a:buf, out)
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" import_doc adds an import of the package displayed in the current
+" documentation buffer to the source buffer the viewer was opened from.
+function! ge#import#import_doc() abort
+    let buf = get(w:, 'gedoc_source', -1)
+    if !bufloaded(buf)
+        return 'echoerr "source buffer not found"'
+    endif
+    let path = substitute(expand('%'), '\C\v^godoc://([^#?]*).*', '\1', '')
+    let cmd = ge#import#import(buf, 'add', path)
+    if cmd ==# ''
+        echo 'Added import "' . path . '" to ' . bufname(buf)
+    endif
+    return cmd
+endfunction
+
+" vim:ts=4:sw=4:et
@@ -0,0 +1,70 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" open shows the declarations in the current buffer in a side window. Press
+" <CR> on an entry to jump to the declaration.
+"
+" The caller must execute the return value to report errors.
+function! ge#outline#open() abort
+    let source = bufnr('%')
+    try
+        let buf = join(getline(1, '$'), "\n")
+        let out = ge#tool#runl(buf, '-cwd', expand('%:p:h'), 'outline', expand('%:p'))
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    if len(out) && out[0] ==# 'ERR'
+        return 'echoerr ' . string('go-explorer: ' . join(out[1:], ' '))
+    endif
+
+    let lines = []
+    let entries = []
+    for line in out
+        let f = split(line, "\t", 1)
+        if len(f) < 6
+            continue
+        endif
+        call add(lines, repeat('  ', str2nr(f[0])) . f[5])
+        call add(entries, [str2nr(f[2]), f[3]])
+    endfor
+
+    let win = bufwinnr('__GeOutline__')
+    if win > 0
+        execute win . 'wincmd w'
+    else
+        execute 'botright vertical ' . get(g:, 'ge_outline_width', 40) . 'new __GeOutline__'
+        setlocal buftype=nofile bufhidden=wipe noswapfile nobuflisted
+        setlocal nonumber nowrap winfixwidth syntax=go
+        nnoremap <buffer> <silent> <CR> :call <SID>jump()<CR>
+        noremap <buffer> <silent> <2-LeftMouse> :call <SID>jump()<CR>
+    endif
+    setlocal modifiable
+    silent %delete _
+    call setline(1, lines)
+    setlocal nomodifiable
+    let b:ge_outline_source = source
+    let b:ge_outline_entries = entries
+    return ''
+endfunction
+
+" jump moves the cursor to the declaration of the entry on the cursor line in
+" the window showing the source buffer.
+function! s:jump() abort
+    let [pos, file] = b:ge_outline_entries[line('.') - 1]
+    let win = bufwinnr(b:ge_outline_source)
+    if win < 0
+        wincmd p
+    else
+        execute win . 'wincmd w'
+    endif
+    if file !=# ''
+        execute 'edit ' . fnameescape(file)
+    endif
+    if pos > 0
+        normal! m'
+        call cursor(pos / 10000, pos % 10000)
+    endif
+endfunction
+
+" vim:ts=4:sw=4:et
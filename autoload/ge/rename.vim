@@ -0,0 +1,33 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" rename renames the identifier under the cursor to name. The edits are
+" previewed and applied after confirmation. Conflicts that prevent the rename
+" are written to the quickfix window.
+"
+" The caller must execute the return value to report errors.
+function! ge#rename#rename(name) abort
+    try
+        let buf = join(getline(1, '$'), "\n")
+        let offset = line2byte(line('.')) + col('.') - 2
+        let out = ge#tool#runl(buf, '-cwd', expand('%:p:h'), 'rename', '-offset=' . offset, '-to=' . a:name, expand('%:p'))
+        if out[0] ==# 'ERR'
+            cexpr out[1:]
+            return ''
+        endif
+        let edits = ge#edit#parse(out)
+        if len(edits) == 0
+            return ''
+        endif
+        echo join(ge#edit#preview(edits), "\n")
+        if confirm('Apply rename?', "&Yes\n&No", 2) == 1
+            call ge#edit#apply(edits)
+        endif
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" vim:ts=4:sw=4:et
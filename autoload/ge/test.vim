@@ -0,0 +1,39 @@
+" Copyright 2015 Gary Burd. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+
+" test runs the tests or, if bench is set, the benchmarks for the symbol under
+" the cursor and writes the failures and summary to the quickfix list. In a
+" doc page, the symbol is the declaration on the cursor line.
+"
+" The caller must execute the return value to report errors.
+function! ge#test#test(bench) abort
+    let flags = a:bench ? ['-bench'] : []
+    try
+        if &filetype ==# 'gedoc'
+            let name = ge#doc#cursor_anchor()
+            if name ==# ''
+                return 'echoerr "go-explorer: no declaration on cursor line"'
+            endif
+            let path = substitute(expand('%'), '\v^godoc://([^?#]*).*', '\1', '')
+            let out = call('ge#tool#runl', ['', 'test'] + flags + [path, name])
+        else
+            let buf = join(getline(1, '$'), "\n")
+            let offset = line2byte(line('.')) + col('.') - 2
+            let out = call('ge#tool#runl', [buf, '-cwd', expand('%:p:h'), 'test'] + flags + ['-offset=' . offset, expand('%:p')])
+        endif
+        call filter(out, 'v:val !=# ""')
+        if len(out) && out[0] ==# 'ERR'
+            return 'echoerr ' . string('go-explorer: ' . join(out[1:], ' '))
+        endif
+        cexpr out
+        if a:bench
+            copen
+        endif
+    catch /^go-explorer:/
+        return 'echoerr v:errmsg'
+    endtry
+    return ''
+endfunction
+
+" vim:ts=4:sw=4:et
@@ -37,9 +37,62 @@ function! s:tool_binary() abort
     call s:throw('getool not found, run "go get -u github.com/garyburd/go-explorer/src/getool" to install')
 endfunction
 
+" build_flags returns the flags for the build context selected with the
+" g:ge_goos, g:ge_goarch and g:ge_tags options.
+function! s:build_flags() abort
+    let flags = []
+    for name in ['goos', 'goarch', 'tags']
+        let value = get(g:, 'ge_' . name, '')
+        if value !=# ''
+            call add(flags, '-' . name . '=' . value)
+        endif
+    endfor
+    return flags
+endfunction
+
+" protocol is the version of the protocol between the plugin and getool.
+let s:protocol = 2
+
+let s:update_tool = 'run "go get -u github.com/garyburd/go-explorer/src/getool" to update'
+
+" check runs the getool version command once per session and throws an error
+" if getool does not support the protocol version of the plugin.
+function! s:check(cmd) abort
+    if exists('s:info')
+        return
+    endif
+    let info = {'protocol': 0, 'compatible': 0, 'doc': [], 'commands': {}}
+    let out = system(a:cmd . ' version')
+    if !v:shell_error
+        for line in split(out, "\n")
+            let f = split(line)
+            if len(f) < 2
+                continue
+            elseif f[0] ==# 'protocol' || f[0] ==# 'compatible'
+                let info[f[0]] = str2nr(f[1])
+            elseif f[0] ==# 'doc'
+                let info.doc = f[1:]
+            elseif f[0] ==# 'command'
+                let info.commands[f[1]] = f[2:]
+            endif
+        endfor
+    endif
+    if info.protocol == 0
+        call s:throw('getool is older than the plugin, ' . s:update_tool)
+    elseif info.protocol < s:protocol
+        call s:throw('getool is older than the plugin (protocol ' . info.protocol . ', plugin protocol ' . s:protocol . '), ' . s:update_tool)
+    endif
+    if info.compatible > s:protocol
+        call s:throw('getool is newer than the plugin (protocol ' . info.protocol . ', plugin protocol ' . s:protocol . '), update the plugin')
+    endif
+    let s:info = info
+endfunction
+
 function! s:run(input, args) abort
     let cmd = s:tool_binary()
-    for arg in a:args
+    call s:check(cmd)
+    let cmd = cmd . ' ' . shellescape('-protocol=' . s:protocol)
+    for arg in s:build_flags() + a:args
         let cmd = cmd . ' ' . shellescape(arg)
     endfor
     if a:input ==# ''
@@ -48,11 +101,29 @@ function! s:run(input, args) abort
         let result = system(cmd, a:input)
     endif
     if v:shell_error
+        if result =~# '^getool: unknown command'
+            call s:throw('getool does not support the command, ' . s:update_tool)
+        endif
         call s:throw(result)
     endif
     return result
 endfunction
 
+" has returns true if getool supports the command name with the given flags.
+function ge#tool#has(name, ...) abort
+    call s:check(s:tool_binary())
+    let flags = get(s:info.commands, a:name, v:null)
+    if flags is v:null
+        return 0
+    endif
+    for flag in a:000
+        if index(flags, flag) < 0
+            return 0
+        endif
+    endfor
+    return 1
+endfunction
+
 " run returns output of running tool_binary with arguments ... and stdin set to
 " input.
 function ge#tool#run(input, ...) abort
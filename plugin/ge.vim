@@ -6,8 +6,26 @@ let g:loaded_ge = 1
 augroup ge_doc
     autocmd!
     autocmd BufReadCmd  godoc://** execute ge#doc#read()
+    autocmd BufReadCmd  godoc-calls://** execute ge#calls#read()
 augroup END
 
 command! -nargs=* -complete=customlist,ge#complete#complete_package_id GeDoc :execute ge#doc#open(<f-args>)
+command! GeCallers :execute ge#calls#open('callers')
+command! GeCallees :execute ge#calls#open('callees')
+command! GeCheck :execute ge#check#check('c')
+command! -nargs=? -complete=customlist,ge#complete#complete_package_id GeCover :execute ge#cover#cover(<f-args>)
+command! GeCoverClear :call ge#cover#clear()
+command! GeTest :execute ge#test#test(0)
+command! GeBench :execute ge#test#test(1)
+command! -nargs=+ -complete=dir GeAPIDiff :execute ge#apidiff#diff(<f-args>)
+command! GeOutline :execute ge#outline#open()
+command! -nargs=1 GeRename :execute ge#rename#rename(<f-args>)
+command! -nargs=+ -complete=customlist,ge#complete#complete_package_id GeImport :execute ge#import#import(bufnr('%'), 'add', <f-args>)
+command! -nargs=1 GeDrop :execute ge#import#import(bufnr('%'), 'drop', <f-args>)
+command! -range -nargs=? GeExtractFunc :execute ge#extract#func(<line1>, <line2>, <f-args>)
+command! -range -nargs=? GeExtractVar :execute ge#extract#var(<f-args>)
+command! GeFillStruct :execute ge#fillstruct#fill()
+command! -nargs=+ GeImpl :execute ge#impl#impl(<f-args>)
+command! -range=% -bang GeFmt :execute ge#fmt#format('c', <bang>0, <line1>, <line2>)
 
 " vim:ts=4:sw=4:et
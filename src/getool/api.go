@@ -0,0 +1,254 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// apiVersions records the Go 1 minor version that added a standard library
+// package and the identifiers in the package. The versions are read from the
+// api/go1.*.txt files in GOROOT.
+type apiVersions struct {
+	pkg int            // -1 if the package is not listed
+	ids map[string]int // keys are Name or Type.Name for fields and methods
+}
+
+var apiFileRx = regexp.MustCompile(`^go1(?:\.(\d+))?\.txt$`)
+
+// apiLine is a line in the API files for a package.
+type apiLine struct {
+	Minor    int
+	Platform string `json:",omitempty"` // goos-goarch[-cgo] or "" for all platforms
+	ID       string `json:",omitempty"`
+}
+
+// loadAPIVersions returns the versions for the package with the given import
+// path. Platform specific lines are ignored unless the goos-goarch[-cgo]
+// platform matches bctx. The parsed API files are kept in cacheDir.
+func loadAPIVersions(bctx *build.Context, importPath string, cacheDir string) *apiVersions {
+	v := &apiVersions{pkg: -1, ids: make(map[string]int)}
+	platform := bctx.GOOS + "-" + bctx.GOARCH
+	if bctx.CgoEnabled {
+		platform += "-cgo"
+	}
+	for _, l := range apiLines(bctx.GOROOT, importPath, cacheDir) {
+		if l.Platform != "" && l.Platform != platform {
+			continue
+		}
+		v.add("", l.Minor)
+		if l.ID != "" {
+			v.add(l.ID, l.Minor)
+		}
+	}
+	return v
+}
+
+// apiLines returns the lines in the API files in goroot for the package with
+// the given import path. The files are parsed once for each GOROOT and
+// version of the files. The lines of each package are kept in a file in
+// cacheDir.
+func apiLines(goroot string, importPath string, cacheDir string) []apiLine {
+	dir := filepath.Join(goroot, "api")
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	h := sha256.New()
+	for _, fi := range fis {
+		if apiFileRx.MatchString(fi.Name()) {
+			names = append(names, fi.Name())
+			fmt.Fprintf(h, "%s %d %d\n", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+
+	var versionDir string
+	if cacheDir != "" {
+		gorootSum := sha256.Sum256([]byte(goroot))
+		versionDir = filepath.Join(cacheDir, "api", hex.EncodeToString(gorootSum[:8]), hex.EncodeToString(h.Sum(nil)[:8]))
+		p, err := ioutil.ReadFile(filepath.Join(versionDir, filepath.FromSlash(importPath)+".json"))
+		if err == nil {
+			var lines []apiLine
+			if json.Unmarshal(p, &lines) == nil {
+				return lines
+			}
+		} else if _, err := os.Stat(versionDir); err == nil {
+			// The files are parsed and the package is not listed.
+			return nil
+		}
+	}
+
+	lines := parseAPIFiles(dir, names)
+	if versionDir != "" {
+		writeAPICache(versionDir, lines)
+	}
+	return lines[importPath]
+}
+
+// parseAPIFiles returns the lines of the API files by package import path.
+func parseAPIFiles(dir string, names []string) map[string][]apiLine {
+	result := make(map[string][]apiLine)
+	for _, name := range names {
+		m := apiFileRx.FindStringSubmatch(name)
+		minor := 0
+		if m[1] != "" {
+			minor, _ = strconv.Atoi(m[1])
+		}
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := s.Text()
+			if !strings.HasPrefix(line, "pkg ") {
+				continue
+			}
+			line = line[len("pkg "):]
+			i := strings.IndexAny(line, " ,")
+			if i < 0 {
+				continue
+			}
+			importPath, line := line[:i], line[i:]
+			l := apiLine{Minor: minor}
+			if strings.HasPrefix(line, " (") {
+				// pkg syscall (windows-386), ...
+				i := strings.Index(line, "), ")
+				if i < 0 {
+					continue
+				}
+				l.Platform = line[2:i]
+				line = line[i+1:]
+			}
+			if !strings.HasPrefix(line, ", ") {
+				continue
+			}
+			l.ID = apiID(line[2:])
+			result[importPath] = append(result[importPath], l)
+		}
+		f.Close()
+	}
+	return result
+}
+
+// writeAPICache writes the lines of each package to a file in directory dir.
+// The directory is written in place of the directories for other versions of
+// the API files in the same GOROOT.
+func writeAPICache(dir string, lines map[string][]apiLine) {
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0777); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempDir(parent, "tmp")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	for importPath, l := range lines {
+		p, err := json.Marshal(l)
+		if err != nil {
+			return
+		}
+		fname := filepath.Join(tmp, filepath.FromSlash(importPath)+".json")
+		if err := os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+			return
+		}
+		if err := ioutil.WriteFile(fname, p, 0666); err != nil {
+			return
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return
+	}
+	fis, _ := ioutil.ReadDir(parent)
+	for _, fi := range fis {
+		if name := filepath.Join(parent, fi.Name()); name != dir && !strings.HasPrefix(fi.Name(), "tmp") {
+			os.RemoveAll(name)
+		}
+	}
+}
+
+func (v *apiVersions) add(id string, minor int) {
+	if id == "" {
+		if v.pkg < 0 || minor < v.pkg {
+			v.pkg = minor
+		}
+		return
+	}
+	if n, ok := v.ids[id]; !ok || minor < n {
+		v.ids[id] = minor
+	}
+}
+
+// apiID returns the identifier declared by the text following the package in
+// an API file line.
+func apiID(s string) string {
+	kind, s, _ := strings.Cut(s, " ")
+	switch kind {
+	case "const", "var", "func":
+		return apiName(s)
+	case "method":
+		// method (*T[$0]) Name(...)
+		if !strings.HasPrefix(s, "(") {
+			return ""
+		}
+		recv, s, ok := strings.Cut(s[1:], ") ")
+		if !ok {
+			return ""
+		}
+		recv = strings.TrimPrefix(recv, "*")
+		return apiName(recv) + "." + apiName(s)
+	case "type":
+		name := apiName(s)
+		s = s[len(name):]
+		for _, kind := range []string{" struct, ", " interface, "} {
+			if strings.HasPrefix(s, kind) {
+				field := s[len(kind):]
+				if strings.HasPrefix(field, "embedded ") || strings.HasPrefix(field, "unexported ") {
+					return ""
+				}
+				return name + "." + apiName(field)
+			}
+		}
+		return name
+	}
+	return ""
+}
+
+// apiName returns the identifier at the start of s.
+func apiName(s string) string {
+	for i, r := range s {
+		if r == ' ' || r == '(' || r == '[' || r == ',' {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parseGoVersion returns the minor version in a Go 1 version such as 1.18 or
+// go1.18.
+func parseGoVersion(s string) (int, error) {
+	s = strings.TrimPrefix(s, "go")
+	if !strings.HasPrefix(s, "1.") {
+		return 0, fmt.Errorf("bad Go version %q", s)
+	}
+	minor, err := strconv.Atoi(strings.SplitN(s[2:], ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("bad Go version %q", s)
+	}
+	return minor, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var apiTestFiles = map[string]string{
+	"go1.txt": `pkg p, func F(int) int
+pkg p, type T struct
+pkg p, type T struct, A int
+pkg p, method (*T) M() error
+pkg p, const C = 1
+pkg p, const C ideal-int
+pkg q, func F()
+`,
+	"go1.2.txt": `pkg p, type T struct, B string
+pkg p, type T struct, embedded io.Reader
+pkg p, type I interface { M }
+pkg p, type I interface, M()
+pkg p (windows-386), func W()
+pkg p (linux-386-cgo), func L()
+pkg p (linux-386), func N()
+pkg p (linux-amd64-cgo), func A()
+`,
+	"go1.10.txt": `pkg p, func G[$0 interface{}]($0)
+pkg p, method (*S[$0]) N() $0
+pkg p, var V int
+pkg p, func F(int) int
+pkg pp, func X()
+`,
+	"except.txt": "pkg p, func E()\n",
+}
+
+func TestAPIVersions(t *testing.T) {
+	files := make(map[string]string)
+	for name, content := range apiTestFiles {
+		files["api/"+name] = content
+	}
+	dir := writeTestFiles(t, files)
+
+	bctx := &build.Context{GOROOT: dir, GOOS: "linux", GOARCH: "386", CgoEnabled: true}
+	nocgo := *bctx
+	nocgo.CgoEnabled = false
+
+	// The versions are parsed without a cache directory, parsed and saved
+	// to the cache directory, and read from the cache directory.
+	cacheDir := t.TempDir()
+	for _, cacheDir := range []string{"", cacheDir, cacheDir} {
+		v := loadAPIVersions(bctx, "p", cacheDir)
+		want := map[string]int{
+			"F": 0, "T": 0, "T.A": 0, "T.M": 0, "C": 0,
+			"T.B": 2, "I": 2, "I.M": 2, "L": 2,
+			"G": 10, "S.N": 10, "V": 10,
+		}
+		if v.pkg != 0 || !reflect.DeepEqual(v.ids, want) {
+			t.Errorf("loadAPIVersions(p, %q) = %d %v, want 0 %v", cacheDir, v.pkg, v.ids, want)
+		}
+		// Without cgo, the lines for the platform without the cgo suffix
+		// match.
+		delete(want, "L")
+		want["N"] = 2
+		if v := loadAPIVersions(&nocgo, "p", cacheDir); !reflect.DeepEqual(v.ids, want) {
+			t.Errorf("loadAPIVersions(p, %q) without cgo = %v, want %v", cacheDir, v.ids, want)
+		}
+		if v := loadAPIVersions(bctx, "pp", cacheDir); v.pkg != 10 {
+			t.Errorf("loadAPIVersions(pp, %q).pkg = %d, want 10", cacheDir, v.pkg)
+		}
+		if v := loadAPIVersions(bctx, "r", cacheDir); v.pkg != -1 {
+			t.Errorf("loadAPIVersions(r, %q).pkg = %d, want -1", cacheDir, v.pkg)
+		}
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(cacheDir, "api", "*", "*", "p.json")); len(matches) != 1 {
+		t.Errorf("cached versions of p = %v, want one file", matches)
+	}
+
+	// A change to the API files replaces the cached versions.
+	fname := filepath.Join(dir, "api", "go1.10.txt")
+	if err := ioutil.WriteFile(fname, []byte(apiTestFiles["go1.10.txt"]+"pkg r, func R()\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fname, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if v := loadAPIVersions(bctx, "r", cacheDir); v.pkg != 10 {
+		t.Errorf("loadAPIVersions(r) after change = %d, want 10", v.pkg)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(cacheDir, "api", "*", "*")); len(matches) != 1 {
+		t.Errorf("cached versions = %v, want one directory", matches)
+	}
+}
+
+func TestDocAPIVersions(t *testing.T) {
+	if _, err := os.Stat(filepath.Join(build.Default.GOROOT, "api", "go1.16.txt")); err != nil {
+		t.Skip("API files not found")
+	}
+	for _, maxGo := range []string{"", "1.15"} {
+		var buf bytes.Buffer
+		doDoc(&Context{out: &buf, args: []string{"io"}}, false, maxGo)
+		out := buf.String()
+		discard := regexp.MustCompile(`\nvar Discard Writer = discard\{\} +since Go 1\.16\n`)
+		if maxGo == "" && !discard.MatchString(out) {
+			t.Errorf("doc io does not have note on Discard")
+		}
+		hasNopCloser := strings.Contains(out, "\nfunc NopCloser(")
+		if hasNopCloser != (maxGo == "") {
+			t.Errorf("doc -maxgo=%s io shows NopCloser = %v", maxGo, hasNopCloser)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	var fs flag.FlagSet
+	commands["apidiff"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doAPIDiff(ctx) },
+	}
+}
+
+// doAPIDiff implements the command
+//
+//	apidiff old new
+//
+// The command compares the exported API of the package in directory old with
+// the package in directory new. If old or new is not a directory, then the
+// argument is taken as a git revision of the package in the current
+// directory.
+//
+// The output is a list of changes in the format file:line:col: [kind] name:
+// message, where kind is incompatible or compatible. If the declaration is in
+// both versions, then the change is followed by a line with the position of
+// the old declaration.
+func doAPIDiff(ctx *Context) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 2 {
+		fmt.Fprint(w, "apidiff: old and new arguments required\n")
+		return 1
+	}
+
+	var versions [2]*apiPackage
+	for i, spec := range ctx.args {
+		dir, err := apiDiffSource(ctx, spec)
+		if err != nil {
+			fmt.Fprintf(w, "apidiff: %v\n", err)
+			return 1
+		}
+		versions[i], err = loadAPIPackage(ctx, dir)
+		if err != nil {
+			fmt.Fprintf(w, "apidiff: %v\n", err)
+			return 1
+		}
+	}
+
+	changes := diffAPI(versions[0], versions[1])
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].compatible != changes[j].compatible {
+			return !changes[i].compatible
+		}
+		return changes[i].name < changes[j].name
+	})
+	for _, c := range changes {
+		kind := "incompatible"
+		if c.compatible {
+			kind = "compatible"
+		}
+		pos := c.new
+		if !pos.IsValid() {
+			pos = c.old
+		}
+		fmt.Fprintf(w, "%s: [%s] %s: %s\n", pos, kind, c.name, c.message)
+		if c.new.IsValid() && c.old.IsValid() {
+			fmt.Fprintf(w, "%s: [old] %s\n", c.old, c.name)
+		}
+	}
+	return 0
+}
+
+// apiDiffSource returns the directory containing the version of the package
+// specified by spec. Files from a git revision are written to the cache
+// directory so that the report can link to the old declarations.
+func apiDiffSource(ctx *Context, spec string) (string, error) {
+	dir := spec
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(ctx.cwd, dir)
+	}
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return dir, nil
+	}
+
+	git := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = ctx.cwd
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, errors.New(msg)
+			}
+			return nil, err
+		}
+		return out, nil
+	}
+	out, err := git("rev-parse", "--verify", spec+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a directory or git revision", spec)
+	}
+	rev := strings.TrimSpace(string(out))
+	out, err = git("rev-parse", "--show-prefix")
+	if err != nil {
+		return "", err
+	}
+	prefix := strings.TrimSpace(string(out))
+
+	if ctx.cacheDir == "" {
+		return "", fmt.Errorf("no cache directory for the files of revision %s", spec)
+	}
+	root := filepath.Join(ctx.cacheDir, "apidiff")
+	dir = filepath.Join(root, rev, filepath.FromSlash(prefix))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	os.Chtimes(filepath.Join(root, rev), now, now)
+	pruneCheckouts(root)
+	out, err = git("ls-tree", "--name-only", rev, "./")
+	if err != nil {
+		return "", err
+	}
+	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		p, err := git("show", rev+":"+prefix+name)
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), p, 0666); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// maxCheckouts is the number of git revision checkouts kept in the cache.
+const maxCheckouts = 8
+
+// pruneCheckouts removes all but the most recently used checkouts in root.
+func pruneCheckouts(root string) {
+	fis, err := ioutil.ReadDir(root)
+	if err != nil || len(fis) <= maxCheckouts {
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].ModTime().After(fis[j].ModTime()) })
+	for _, fi := range fis[maxCheckouts:] {
+		os.RemoveAll(filepath.Join(root, fi.Name()))
+	}
+}
+
+// apiPackage is a version of a package loaded for comparison.
+type apiPackage struct {
+	fset *token.FileSet
+	tpkg *types.Package
+}
+
+// loadAPIPackage type checks the package in directory dir. Imports are
+// resolved relative to the current directory so that a package copied from a
+// git revision uses the dependencies of the working tree.
+func loadAPIPackage(ctx *Context, dir string) (*apiPackage, error) {
+	bctx := ctx.buildContext()
+	bpkg, err := bctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range append(bpkg.GoFiles, bpkg.CgoFiles...) {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	srcDir := dir
+	if filepath.IsAbs(ctx.cwd) {
+		srcDir = ctx.cwd
+	}
+	conf := types.Config{
+		Importer:    newSourceImporter(fset, bctx, srcDir),
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+	tpkg, _ := conf.Check(bpkg.Name, fset, files, nil)
+	if tpkg == nil {
+		return nil, fmt.Errorf("could not type check package in %s", dir)
+	}
+	return &apiPackage{fset: fset, tpkg: tpkg}, nil
+}
+
+// apiChange is a change to an exported declaration.
+type apiChange struct {
+	name       string
+	message    string
+	compatible bool
+	old, new   token.Position // invalid if the declaration is not in the version
+}
+
+// apiDiffer compares two versions of a package.
+type apiDiffer struct {
+	old, new *apiPackage
+	changes  []*apiChange
+}
+
+func (d *apiDiffer) report(name string, compatible bool, oldObj, newObj types.Object, format string, args ...interface{}) {
+	c := &apiChange{name: name, message: fmt.Sprintf(format, args...), compatible: compatible}
+	if oldObj != nil {
+		c.old = d.old.fset.Position(oldObj.Pos())
+	}
+	if newObj != nil {
+		c.new = d.new.fset.Position(newObj.Pos())
+	}
+	d.changes = append(d.changes, c)
+}
+
+// typeString returns the string representation of t. Types declared in the
+// compared package are not qualified so that the types in the two versions
+// compare equal.
+func (d *apiDiffer) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == d.old.tpkg || p == d.new.tpkg {
+			return ""
+		}
+		return p.Path()
+	})
+}
+
+// typeKey returns a string that identifies t for comparison. Parameter and
+// result names are omitted so that renaming a parameter is not a change.
+func (d *apiDiffer) typeKey(t types.Type) string {
+	s := d.typeString(unnamedParams(t))
+	if sig, ok := t.(*types.Signature); ok && sig.TypeParams().Len() > 0 {
+		s = "[" + d.typeParams(sig.TypeParams()) + "]" + s
+	}
+	return s
+}
+
+// typeParams returns the type parameters in list as a string.
+func (d *apiDiffer) typeParams(list *types.TypeParamList) string {
+	var s []string
+	for i := 0; i < list.Len(); i++ {
+		p := list.At(i)
+		s = append(s, p.Obj().Name()+" "+d.typeString(p.Constraint()))
+	}
+	return strings.Join(s, ", ")
+}
+
+// unnamedParams returns t with the names removed from the parameters and
+// results of the function types in t. Named types are not modified.
+func unnamedParams(t types.Type) types.Type {
+	switch t := t.(type) {
+	case *types.Signature:
+		tuple := func(tup *types.Tuple) *types.Tuple {
+			vars := make([]*types.Var, tup.Len())
+			for i := range vars {
+				v := tup.At(i)
+				vars[i] = types.NewParam(v.Pos(), v.Pkg(), "", unnamedParams(v.Type()))
+			}
+			return types.NewTuple(vars...)
+		}
+		return types.NewSignatureType(nil, nil, nil, tuple(t.Params()), tuple(t.Results()), t.Variadic())
+	case *types.Pointer:
+		return types.NewPointer(unnamedParams(t.Elem()))
+	case *types.Slice:
+		return types.NewSlice(unnamedParams(t.Elem()))
+	case *types.Array:
+		return types.NewArray(unnamedParams(t.Elem()), t.Len())
+	case *types.Map:
+		return types.NewMap(unnamedParams(t.Key()), unnamedParams(t.Elem()))
+	case *types.Chan:
+		return types.NewChan(t.Dir(), unnamedParams(t.Elem()))
+	case *types.Struct:
+		fields := make([]*types.Var, t.NumFields())
+		tags := make([]string, t.NumFields())
+		for i := range fields {
+			f := t.Field(i)
+			fields[i] = types.NewField(f.Pos(), f.Pkg(), f.Name(), unnamedParams(f.Type()), f.Embedded())
+			tags[i] = t.Tag(i)
+		}
+		return types.NewStruct(fields, tags)
+	case *types.Interface:
+		methods := make([]*types.Func, t.NumExplicitMethods())
+		for i := range methods {
+			m := t.ExplicitMethod(i)
+			methods[i] = types.NewFunc(m.Pos(), m.Pkg(), m.Name(), unnamedParams(m.Type()).(*types.Signature))
+		}
+		embeddeds := make([]types.Type, t.NumEmbeddeds())
+		for i := range embeddeds {
+			embeddeds[i] = unnamedParams(t.EmbeddedType(i))
+		}
+		return types.NewInterfaceType(methods, embeddeds).Complete()
+	}
+	return t
+}
+
+// diffAPI returns the changes to the exported API from version old to new.
+func diffAPI(old, new *apiPackage) []*apiChange {
+	d := &apiDiffer{old: old, new: new}
+	oldScope, newScope := old.tpkg.Scope(), new.tpkg.Scope()
+	for _, name := range oldScope.Names() {
+		o := oldScope.Lookup(name)
+		if !o.Exported() {
+			continue
+		}
+		n := newScope.Lookup(name)
+		if n == nil {
+			d.report(name, false, o, nil, "removed")
+			continue
+		}
+		d.object(name, o, n)
+	}
+	for _, name := range newScope.Names() {
+		n := newScope.Lookup(name)
+		if n.Exported() && oldScope.Lookup(name) == nil {
+			d.report(name, true, nil, n, "added")
+		}
+	}
+	return d.changes
+}
+
+func objectKind(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Const:
+		return "const"
+	case *types.Var:
+		return "var"
+	case *types.Func:
+		return "func"
+	case *types.TypeName:
+		if obj.IsAlias() {
+			return "type alias"
+		}
+		return "type"
+	}
+	return "object"
+}
+
+func (d *apiDiffer) object(name string, o, n types.Object) {
+	if objectKind(o) != objectKind(n) {
+		d.report(name, false, o, n, "changed from %s to %s", objectKind(o), objectKind(n))
+		return
+	}
+	switch o := o.(type) {
+	case *types.Const:
+		d.sameType(name, o, n, o.Type(), n.Type())
+		if nv := n.(*types.Const).Val(); o.Val().ExactString() != nv.ExactString() {
+			d.report(name, false, o, n, "value changed from %s to %s", o.Val(), nv)
+		}
+	case *types.Var, *types.Func:
+		d.sameType(name, o, n, o.Type(), n.Type())
+	case *types.TypeName:
+		if o.IsAlias() {
+			d.sameType(name, o, n, o.Type(), n.Type())
+			return
+		}
+		d.namedType(name, o, n.(*types.TypeName))
+	}
+}
+
+// sameType reports an incompatible change if the types are not identical.
+func (d *apiDiffer) sameType(name string, o, n types.Object, ot, nt types.Type) bool {
+	if d.typeKey(ot) == d.typeKey(nt) {
+		return true
+	}
+	d.report(name, false, o, n, "changed from %s to %s", d.typeString(ot), d.typeString(nt))
+	return false
+}
+
+func (d *apiDiffer) namedType(name string, o, n *types.TypeName) {
+	ot, nt := o.Type().(*types.Named), n.Type().(*types.Named)
+	if otp, ntp := d.typeParams(ot.TypeParams()), d.typeParams(nt.TypeParams()); otp != ntp {
+		d.report(name, false, o, n, "type parameters changed from [%s] to [%s]", otp, ntp)
+		return
+	}
+
+	switch ou := ot.Underlying().(type) {
+	case *types.Struct:
+		nu, ok := nt.Underlying().(*types.Struct)
+		if !ok {
+			d.report(name, false, o, n, "changed from struct to %s", d.typeString(nt.Underlying()))
+			return
+		}
+		d.structFields(name, ou, nu)
+	case *types.Interface:
+		nu, ok := nt.Underlying().(*types.Interface)
+		if !ok {
+			d.report(name, false, o, n, "changed from interface to %s", d.typeString(nt.Underlying()))
+			return
+		}
+		d.interfaceMethods(name, ou, nu)
+		return
+	default:
+		if !d.sameType(name, o, n, ou, nt.Underlying()) {
+			return
+		}
+	}
+	d.methods(name, ot, nt)
+}
+
+func exportedFields(s *types.Struct) map[string]*types.Var {
+	m := make(map[string]*types.Var)
+	for i := 0; i < s.NumFields(); i++ {
+		if f := s.Field(i); f.Exported() {
+			m[f.Name()] = f
+		}
+	}
+	return m
+}
+
+func (d *apiDiffer) structFields(name string, o, n *types.Struct) {
+	oldFields, newFields := exportedFields(o), exportedFields(n)
+	for i := 0; i < o.NumFields(); i++ {
+		of := o.Field(i)
+		if !of.Exported() {
+			continue
+		}
+		fname := name + "." + of.Name()
+		nf := newFields[of.Name()]
+		if nf == nil {
+			d.report(fname, false, of, nil, "field removed")
+			continue
+		}
+		d.sameType(fname, of, nf, of.Type(), nf.Type())
+	}
+	for i := 0; i < n.NumFields(); i++ {
+		nf := n.Field(i)
+		if nf.Exported() && oldFields[nf.Name()] == nil {
+			d.report(name+"."+nf.Name(), true, nil, nf, "field added")
+		}
+	}
+}
+
+func (d *apiDiffer) interfaceMethods(name string, o, n *types.Interface) {
+	// Packages outside of the declaring package cannot implement an
+	// interface with unexported methods.
+	sealed := false
+	for i := 0; i < o.NumMethods(); i++ {
+		if !o.Method(i).Exported() {
+			sealed = true
+		}
+	}
+	for i := 0; i < o.NumMethods(); i++ {
+		om := o.Method(i)
+		if !om.Exported() {
+			continue
+		}
+		mname := name + "." + om.Name()
+		obj, _, _ := types.LookupFieldOrMethod(n, false, d.new.tpkg, om.Name())
+		nm, ok := obj.(*types.Func)
+		if !ok {
+			d.report(mname, false, om, nil, "method removed from interface")
+			continue
+		}
+		d.sameType(mname, om, nm, om.Type(), nm.Type())
+	}
+	for i := 0; i < n.NumMethods(); i++ {
+		nm := n.Method(i)
+		if obj, _, _ := types.LookupFieldOrMethod(o, false, d.old.tpkg, nm.Name()); obj != nil {
+			continue
+		}
+		d.report(name+"."+nm.Name(), sealed, nil, nm, "method added to interface")
+	}
+}
+
+// methods compares the exported methods in the method sets of *o and *n.
+func (d *apiDiffer) methods(name string, o, n *types.Named) {
+	oms, nms := types.NewMethodSet(types.NewPointer(o)), types.NewMethodSet(types.NewPointer(n))
+	for i := 0; i < oms.Len(); i++ {
+		om := oms.At(i).Obj()
+		if !om.Exported() {
+			continue
+		}
+		mname := name + "." + om.Name()
+		sel := nms.Lookup(nil, om.Name())
+		if sel == nil {
+			d.report(mname, false, om, nil, "method removed")
+			continue
+		}
+		d.sameType(mname, om, sel.Obj(), om.Type(), sel.Obj().Type())
+	}
+	for i := 0; i < nms.Len(); i++ {
+		nm := nms.At(i).Obj()
+		if nm.Exported() && oms.Lookup(nil, nm.Name()) == nil {
+			d.report(name+"."+nm.Name(), true, nil, nm, "method added")
+		}
+	}
+}
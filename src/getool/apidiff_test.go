@@ -0,0 +1,191 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const apiDiffOld = `package p
+
+import "io"
+
+const C = 1
+
+var V int
+
+func F(s string, f func(x int)) error { return nil }
+
+func Removed() {}
+
+type S struct {
+	A int
+	B string
+	c bool
+}
+
+func (s *S) M(a int) {}
+
+func (s S) N(x int) {}
+
+type I interface {
+	Read() error
+}
+
+type Sealed interface {
+	Read() error
+	sealed()
+}
+
+type W io.Writer
+
+type K int
+`
+
+const apiDiffNew = `package p
+
+import "io"
+
+const C = 2
+
+var V int64
+
+func F(name string, f func(y int)) (err error) { return nil }
+
+func Added() {}
+
+type S struct {
+	A int
+	B []byte
+	D float64
+}
+
+func (s *S) M(b int) {}
+
+func (s *S) O() {}
+
+type I interface {
+	Read() error
+	Close() error
+}
+
+type Sealed interface {
+	Read() error
+	Close() error
+	sealed()
+}
+
+type W io.Writer
+
+type K struct{}
+`
+
+var apiDiffWant = []string{
+	"new/p.go:11:6: [compatible] Added: added",
+	"new/p.go:15:2: [incompatible] S.B: changed from string to []byte",
+	"new/p.go:16:2: [compatible] S.D: field added",
+	"new/p.go:21:13: [compatible] S.O: method added",
+	"new/p.go:25:2: [incompatible] I.Close: method added to interface",
+	"new/p.go:30:2: [compatible] Sealed.Close: method added to interface",
+	"new/p.go:36:6: [incompatible] K: changed from int to struct{}",
+	"new/p.go:5:7: [incompatible] C: value changed from 1 to 2",
+	"new/p.go:7:5: [incompatible] V: changed from int to int64",
+	"old/p.go:11:6: [incompatible] Removed: removed",
+	"old/p.go:15:2: [old] S.B",
+	"old/p.go:21:12: [incompatible] S.N: method removed",
+	"old/p.go:34:6: [old] K",
+	"old/p.go:5:7: [old] C",
+	"old/p.go:7:5: [old] V",
+}
+
+func TestAPIDiff(t *testing.T) {
+	files := map[string]string{
+		"go.mod":   "module example.com/ws\n",
+		"old/p.go": apiDiffOld,
+		"new/p.go": apiDiffNew,
+	}
+	dir := writeTestFiles(t, files)
+
+	var buf bytes.Buffer
+	doAPIDiff(&Context{out: &buf, cwd: dir, args: []string{"old", "new"}})
+	out := strings.Replace(buf.String(), dir+string(filepath.Separator), "", -1)
+	got := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, apiDiffWant) {
+		t.Errorf("apidiff =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(apiDiffWant, "\n"))
+	}
+
+	// Compare with a git revision.
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+	pdir := filepath.Join(dir, "new")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "empty"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(pdir, "p.go"), []byte(apiDiffOld), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"add", "."},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", "old"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(pdir, "p.go"), []byte(apiDiffNew), 0666); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	doAPIDiff(&Context{out: &buf, cwd: pdir, args: []string{"HEAD", "."}, cacheDir: t.TempDir()})
+	out = buf.String()
+	if !strings.Contains(out, "[incompatible] Removed: removed") || !strings.Contains(out, "[compatible] Added: added") {
+		t.Errorf("apidiff HEAD . =\n%s", out)
+	}
+}
+
+func TestPruneCheckouts(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for i := 0; i < maxCheckouts+2; i++ {
+		dir := filepath.Join(root, strconv.Itoa(i))
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pruneCheckouts(root)
+	for _, name := range []string{"0", "1"} {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("checkout %s not removed", name)
+		}
+	}
+	if fis, _ := ioutil.ReadDir(root); len(fis) != maxCheckouts {
+		t.Errorf("%d checkouts kept, want %d", len(fis), maxCheckouts)
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+func init() {
+	for _, mode := range []string{"callers", "callees"} {
+		mode := mode
+		var fs flag.FlagSet
+		offset := fs.Int("offset", -1, "byte `offset` of the function in the file")
+		depth := fs.Int("depth", 3, "maximum `depth` of the call tree")
+		commands[mode] = &Command{
+			fs: &fs,
+			do: func(ctx *Context) int { return doCalls(ctx, mode, *offset, *depth) },
+		}
+	}
+}
+
+// doCalls implements the commands
+//
+//	callers|callees [-depth n] -offset offset file
+//	callers|callees [-depth n] importpath symbol
+//
+// The commands print a tree of the callers or callees of the function or
+// method at offset in file or of the symbol in the package with the given
+// import path. The symbol is a function name or a method name in the form
+// Type.Method. The contents of file are read from stdin.
+//
+// The call graph is built from the packages in the module containing the
+// current directory and the function's package. Outside of a module, the
+// graph is built from the GOPATH packages that import the function's package
+// for callers and from the packages the function's package imports for
+// callees. Calls of interface methods are resolved to the methods of the
+// loaded types that implement the interface.
+//
+// The output uses the doc command protocol. Protocol version 2 adds the record
+// "N url" giving the godoc-calls:// URL of the page.
+func doCalls(ctx *Context, mode string, offset int, depth int) int {
+	var (
+		pkg    *Package
+		symbol string
+		err    error
+	)
+	switch len(ctx.args) {
+	case 1:
+		fname := ctx.args[0]
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(ctx.cwd, fname)
+		}
+		var in []byte
+		in, err = ioutil.ReadAll(ctx.in)
+		if err != nil {
+			break
+		}
+		ctx.overlay = map[string][]byte{fname: in}
+		pkg, err = ctx.loadPackageDir(filepath.Dir(fname), 0)
+		if err == nil {
+			symbol, err = symbolAtOffset(fname, in, offset)
+		}
+	case 2:
+		pkg, err = ctx.loadPackage(ctx.args[0], 0)
+		symbol = ctx.args[1]
+	default:
+		fmt.Fprintf(ctx.out, "%s: file or import path and symbol arguments required\n", mode)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(ctx.out, "E\n%s", err)
+		return 0
+	}
+
+	g := newCallGraph()
+	cwd, _ := ctx.buildContext().ImportDir(ctx.cwd, build.FindOnly)
+	root := workspaceRoot(cwd)
+	ctx.shareImporter(root)
+	dirs := callGraphDirs(ctx.buildContext(), root, pkg.bpkg, mode == "callers")
+	if !contains(dirs, pkg.bpkg.Dir) {
+		// The function's package is outside of the workspace, for example
+		// in the standard library or a dependency. The package is loaded
+		// without its tests.
+		if p, err := ctx.loadPackageDir(pkg.bpkg.Dir, loadTypes); err == nil && p.tpkg != nil {
+			g.addPackage(p)
+		}
+	}
+	for _, dir := range dirs {
+		p, err := ctx.loadPackageDir(dir, loadTypes|loadTests)
+		if err != nil || p.tpkg == nil {
+			continue
+		}
+		g.addPackage(p)
+		if xpkg := ctx.loadXTestPackage(p.bpkg); xpkg != nil && xpkg.tpkg != nil {
+			g.addPackage(xpkg)
+		}
+	}
+	g.resolveInterfaceCalls()
+
+	key := pkg.bpkg.ImportPath + "." + symbol
+	node := g.nodes[key]
+	if node == nil {
+		fmt.Fprintf(ctx.out, "E\nfunction %s not found in workspace %s", symbol, root)
+		return 0
+	}
+
+	p := callsPrinter{
+		docPrinter: docPrinter{
+			lineNum:    1,
+			lineOffset: -1,
+			index:      make(map[string]int),
+		},
+		graph:    g,
+		root:     root,
+		callers:  mode == "callers",
+		maxDepth: depth,
+	}
+	if ctx.supports(2) {
+		fmt.Fprintf(&p.metaBuf, "N godoc-calls://%s/%s#%s\n", mode, pkg.bpkg.ImportPath, symbol)
+	}
+	p.buf.WriteString(strings.ToUpper(mode) + "\n\n")
+	p.printTree(node, nil, 0, map[*callNode]bool{})
+	p.execute(ctx.out)
+	return 0
+}
+
+// callGraphDirs returns the directories of the packages loaded to build the
+// call graph of a function in bpkg. In a module, the packages in the module
+// rooted at root are loaded. Outside of a module, root is a GOPATH source
+// directory and the packages in root that transitively import bpkg (callers)
+// or that bpkg transitively imports (callees) are loaded. The directory of
+// bpkg is not returned if bpkg is not in root.
+func callGraphDirs(bctx *build.Context, root string, bpkg *build.Package, callers bool) []string {
+	if !contains(bctx.SrcDirs(), root) {
+		return workspaceDirs(root)
+	}
+
+	pkgs := make(map[string]*build.Package)
+	importers := make(map[string][]string)
+	for _, dir := range workspaceDirs(root) {
+		p, err := bctx.ImportDir(dir, 0)
+		if err != nil {
+			continue
+		}
+		pkgs[p.ImportPath] = p
+		for _, imports := range [][]string{p.Imports, p.TestImports, p.XTestImports} {
+			for _, path := range imports {
+				importers[path] = append(importers[path], p.ImportPath)
+			}
+		}
+	}
+
+	var dirs []string
+	seen := make(map[string]bool)
+	paths := []string{bpkg.ImportPath}
+	for len(paths) > 0 {
+		path := paths[0]
+		paths = paths[1:]
+		p := pkgs[path]
+		if seen[path] || p == nil && path != bpkg.ImportPath {
+			continue
+		}
+		seen[path] = true
+		if p != nil {
+			dirs = append(dirs, p.Dir)
+		}
+		switch {
+		case callers:
+			paths = append(paths, importers[path]...)
+		case p != nil:
+			paths = append(paths, p.Imports...)
+			paths = append(paths, p.TestImports...)
+		}
+	}
+	return dirs
+}
+
+// callNode is a function or method in the call graph.
+type callNode struct {
+	key  string // import path and qualified name
+	name string // package name and qualified name
+	pos  token.Position
+	kind string // "interface" for interface methods
+
+	out []*callEdge
+	in  []*callEdge
+}
+
+// callEdge is a call from caller to callee. The sites are the positions of
+// the calls in caller. Dynamic calls go through the named interface method
+// via.
+type callEdge struct {
+	caller, callee *callNode
+	sites          []token.Position
+	via            *callNode
+}
+
+type callGraph struct {
+	nodes map[string]*callNode
+	pkgs  []*Package
+
+	// Calls of interface methods by interface method key.
+	ifaceCalls map[string][]*callEdge
+	ifaces     map[string]ifaceMethod
+}
+
+// ifaceMethod identifies an interface method called in the workspace.
+type ifaceMethod struct {
+	pkgPath, typeName, method string
+}
+
+func newCallGraph() *callGraph {
+	return &callGraph{
+		nodes:      make(map[string]*callNode),
+		ifaceCalls: make(map[string][]*callEdge),
+		ifaces:     make(map[string]ifaceMethod),
+	}
+}
+
+// node returns the node for fn, creating the node if needed. The function
+// position returns the absolute position of a declaration.
+func (g *callGraph) node(fn *types.Func, position func(token.Pos) token.Position) *callNode {
+	fn = fn.Origin()
+	key := fn.Pkg().Path() + "." + qualifiedName(fn)
+	n := g.nodes[key]
+	if n == nil {
+		n = &callNode{
+			key:  key,
+			name: fn.Pkg().Name() + "." + qualifiedName(fn),
+			pos:  position(fn.Pos()),
+		}
+		g.nodes[key] = n
+	}
+	return n
+}
+
+// edge returns the edge from caller to callee, creating the edge if needed.
+func (g *callGraph) edge(caller, callee, via *callNode) *callEdge {
+	for _, e := range caller.out {
+		if e.callee == callee && e.via == via {
+			return e
+		}
+	}
+	e := &callEdge{caller: caller, callee: callee, via: via}
+	caller.out = append(caller.out, e)
+	callee.in = append(callee.in, e)
+	return e
+}
+
+// addPackage adds the functions and calls in pkg to the graph. Calls in
+// function literals are attributed to the enclosing function declaration.
+func (g *callGraph) addPackage(pkg *Package) {
+	g.pkgs = append(g.pkgs, pkg)
+	position := packagePosition(pkg)
+	for _, file := range pkg.files {
+		for _, decl := range file.Decls {
+			d, ok := decl.(*ast.FuncDecl)
+			if !ok || d.Body == nil {
+				continue
+			}
+			fn, ok := pkg.info.Defs[d.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+			caller := g.node(fn, position)
+			ast.Inspect(d.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if callee := typeutil.StaticCallee(pkg.info, call); callee != nil && callee.Pkg() != nil {
+					e := g.edge(caller, g.node(callee, position), nil)
+					e.sites = append(e.sites, position(call.Lparen))
+					return true
+				}
+				sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				s := pkg.info.Selections[sel]
+				if s == nil || s.Kind() != types.MethodVal || !types.IsInterface(s.Recv()) {
+					return true
+				}
+				named, ok := types.Unalias(s.Recv()).(*types.Named)
+				if !ok || named.Obj().Pkg() == nil {
+					return true
+				}
+				m := ifaceMethod{named.Obj().Pkg().Path(), named.Obj().Name(), sel.Sel.Name}
+				key := m.pkgPath + "." + m.typeName + "." + m.method
+				callee := g.nodes[key]
+				if callee == nil {
+					callee = &callNode{
+						key:  key,
+						name: named.Obj().Pkg().Name() + "." + m.typeName + "." + m.method,
+						pos:  position(s.Obj().Pos()),
+						kind: "interface",
+					}
+					g.nodes[key] = callee
+				}
+				g.ifaces[key] = m
+				e := g.edge(caller, callee, nil)
+				if len(e.sites) == 0 {
+					g.ifaceCalls[key] = append(g.ifaceCalls[key], e)
+				}
+				e.sites = append(e.sites, position(call.Lparen))
+				return true
+			})
+		}
+	}
+}
+
+// resolveInterfaceCalls adds edges from the callers of interface methods to
+// the methods of the workspace types that implement the interfaces.
+func (g *callGraph) resolveInterfaceCalls() {
+	for _, pkg := range g.pkgs {
+		scope := pkg.tpkg.Scope()
+		for key, m := range g.ifaces {
+			ipkg := findImport(pkg.tpkg, m.pkgPath)
+			if ipkg == nil {
+				continue
+			}
+			tn, ok := ipkg.Scope().Lookup(m.typeName).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			iface, ok := tn.Type().Underlying().(*types.Interface)
+			if !ok {
+				continue
+			}
+			for _, name := range scope.Names() {
+				obj, ok := scope.Lookup(name).(*types.TypeName)
+				if !ok || obj.IsAlias() || types.IsInterface(obj.Type()) {
+					continue
+				}
+				if named, ok := obj.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+					continue
+				}
+				for _, t := range []types.Type{obj.Type(), types.NewPointer(obj.Type())} {
+					if !types.Implements(t, iface) {
+						continue
+					}
+					fn, ok := lookupMethod(t, pkg.tpkg, m.method)
+					if !ok {
+						break
+					}
+					callee := g.node(fn, packagePosition(pkg))
+					for _, e := range g.ifaceCalls[key] {
+						ce := g.edge(e.caller, callee, e.callee)
+						ce.sites = append(ce.sites, e.sites...)
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+// packagePosition returns a function that converts positions in pkg to
+// positions with absolute file names.
+func packagePosition(pkg *Package) func(token.Pos) token.Position {
+	return func(pos token.Pos) token.Position {
+		p := pkg.fset.Position(pos)
+		if p.Filename != "" && !filepath.IsAbs(p.Filename) {
+			p.Filename = filepath.Join(pkg.bpkg.Dir, p.Filename)
+		}
+		return p
+	}
+}
+
+func lookupMethod(t types.Type, pkg *types.Package, name string) (*types.Func, bool) {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, pkg, name)
+	fn, ok := obj.(*types.Func)
+	return fn, ok
+}
+
+// findImport returns the package with the given path in the import graph of
+// pkg.
+func findImport(pkg *types.Package, path string) *types.Package {
+	seen := make(map[*types.Package]bool)
+	var find func(p *types.Package) *types.Package
+	find = func(p *types.Package) *types.Package {
+		if p.Path() == path {
+			return p
+		}
+		if seen[p] {
+			return nil
+		}
+		seen[p] = true
+		for _, imp := range p.Imports() {
+			if p := find(imp); p != nil {
+				return p
+			}
+		}
+		return nil
+	}
+	return find(pkg)
+}
+
+// callsPrinter prints a call tree using the doc command protocol.
+type callsPrinter struct {
+	docPrinter
+	graph    *callGraph
+	root     string
+	callers  bool
+	maxDepth int
+}
+
+// printTree prints node and the callers or callees of node to maxDepth. The
+// edge is the edge to the parent of node in the tree or nil for the root.
+func (p *callsPrinter) printTree(node *callNode, edge *callEdge, depth int, seen map[*callNode]bool) {
+	p.buf.WriteString(strings.Repeat(textIndent, depth+1))
+	p.printLink(node.name, node.pos.Filename, -p.lineColumnAddress(node.pos.Line, node.pos.Column))
+	if edge != nil {
+		for _, site := range edge.sites {
+			p.buf.WriteString("  ")
+			p.printLink(p.relativePosition(site), site.Filename, -p.lineColumnAddress(site.Line, site.Column))
+		}
+		if edge.via != nil {
+			p.buf.WriteString("  (via ")
+			p.printLink(edge.via.name, edge.via.pos.Filename, -p.lineColumnAddress(edge.via.pos.Line, edge.via.pos.Column))
+			p.buf.WriteString(")")
+		}
+	}
+	if node.kind != "" {
+		p.buf.WriteString("  (" + node.kind + ")")
+	}
+	edges := node.out
+	if p.callers {
+		edges = node.in
+	}
+	if seen[node] && len(edges) > 0 {
+		p.buf.WriteString("  (recursive)\n")
+		return
+	}
+	p.buf.WriteString("\n")
+	if depth >= p.maxDepth {
+		return
+	}
+	seen[node] = true
+	edges = append([]*callEdge(nil), edges...)
+	other := func(e *callEdge) *callNode {
+		if p.callers {
+			return e.caller
+		}
+		return e.callee
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		return other(edges[i]).name < other(edges[j]).name
+	})
+	for _, e := range edges {
+		p.printTree(other(e), e, depth+1, seen)
+	}
+	delete(seen, node)
+}
+
+// relativePosition returns the position as file:line with the file name
+// relative to the workspace root.
+func (p *callsPrinter) relativePosition(pos token.Position) string {
+	fname := pos.Filename
+	if rel, err := filepath.Rel(p.root, fname); err == nil && !strings.HasPrefix(rel, "..") {
+		fname = filepath.ToSlash(rel)
+	}
+	return fmt.Sprintf("%s:%d", fname, pos.Line)
+}
+
+func (p *callsPrinter) execute(out io.Writer) {
+	p.metaBuf.WriteString("D\n")
+	p.metaBuf.WriteTo(out)
+	p.buf.WriteTo(out)
+}
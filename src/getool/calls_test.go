@@ -0,0 +1,181 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var callsTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+type Writer interface {
+	Write()
+}
+
+func Run(w Writer) {
+	w.Write()
+	w.Write()
+}
+`,
+	"b/b.go": `package b
+
+import "example.com/ws/a"
+
+type file struct{}
+
+func (f *file) Write() { f.flush() }
+
+func (f *file) flush() {}
+
+func Main() {
+	a.Run(&file{})
+	helper()
+}
+
+func helper() {
+	func() { Main() }()
+}
+`,
+}
+
+var callsTests = []struct {
+	mode, symbol string
+	doc          string
+}{
+	{
+		"callers", "file.Write",
+		"CALLERS\n\n" +
+			"    b.file.Write\n" +
+			"        a.Run  a/a.go:8  a/a.go:9  (via a.Writer.Write)\n" +
+			"            b.Main  b/b.go:12\n" +
+			"                b.helper  b/b.go:17\n",
+	},
+	{
+		"callees", "Main",
+		"CALLEES\n\n" +
+			"    b.Main\n" +
+			"        a.Run  b/b.go:12\n" +
+			"            a.Writer.Write  a/a.go:8  a/a.go:9  (interface)\n" +
+			"            b.file.Write  a/a.go:8  a/a.go:9  (via a.Writer.Write)\n" +
+			"                b.file.flush  b/b.go:7\n" +
+			"        b.helper  b/b.go:13\n" +
+			"            b.Main  b/b.go:17  (recursive)\n",
+	},
+}
+
+func TestCalls(t *testing.T) {
+	dir := writeTestFiles(t, callsTestFiles)
+
+	for _, tt := range callsTests {
+		var out bytes.Buffer
+		doCalls(&Context{out: &out, cwd: dir, args: []string{"example.com/ws/b", tt.symbol}}, tt.mode, -1, 3)
+		i := strings.Index(out.String(), "\nD\n")
+		if i < 0 {
+			t.Errorf("%s %s returned %s", tt.mode, tt.symbol, out.String())
+			continue
+		}
+		if doc := out.String()[i+3:]; doc != tt.doc {
+			t.Errorf("%s %s returned\n%s\nwant\n%s", tt.mode, tt.symbol, doc, tt.doc)
+		}
+		url := "N godoc-calls://" + tt.mode + "/example.com/ws/b#" + tt.symbol + "\n"
+		if !strings.Contains(out.String(), url) {
+			t.Errorf("%s %s output does not contain %q", tt.mode, tt.symbol, url)
+		}
+	}
+
+	// Protocol version 1 does not have the N record.
+	var out bytes.Buffer
+	doCalls(&Context{out: &out, cwd: dir, protocol: 1, args: []string{"example.com/ws/b", "Main"}}, "callees", -1, 3)
+	if strings.Contains(out.String(), "\nN ") || strings.HasPrefix(out.String(), "N ") {
+		t.Errorf("callees with protocol 1 returned N record:\n%s", out.String())
+	}
+
+	// Function at offset.
+	src := callsTestFiles["b/b.go"]
+	out.Reset()
+	doCalls(&Context{out: &out, in: strings.NewReader(src), cwd: dir, args: []string{filepath.Join(dir, "b", "b.go")}},
+		"callees", strings.Index(src, "f.flush()"), 3)
+	if want := "    b.file.Write\n        b.file.flush  b/b.go:7\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("callees at offset returned\n%s\nwant suffix\n%s", out.String(), want)
+	}
+}
+
+var callsStdTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+import "strings"
+
+func Pad(n int) string { return strings.Repeat(" ", n) }
+`,
+}
+
+// TestCallsOutsideWorkspace tests the callers of a standard library function
+// in the module containing the current directory.
+func TestCallsOutsideWorkspace(t *testing.T) {
+	dir := writeTestFiles(t, callsStdTestFiles)
+
+	var out bytes.Buffer
+	doCalls(&Context{out: &out, cwd: dir, args: []string{"strings", "Repeat"}}, "callers", -1, 3)
+	if want := "    strings.Repeat\n        a.Pad  a/a.go:5\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("callers strings.Repeat returned\n%s\nwant suffix\n%s", out.String(), want)
+	}
+}
+
+var callGraphDirsTestFiles = map[string]string{
+	"src/ex.com/a/a.go":      "package a\n",
+	"src/ex.com/b/b.go":      "package b\n\nimport \"ex.com/a\"\n",
+	"src/ex.com/c/c_test.go": "package c\n\nimport \"ex.com/b\"\n",
+	"src/ex.com/d/d.go":      "package d\n\nimport \"fmt\"\n",
+}
+
+func TestCallGraphDirs(t *testing.T) {
+	dir := writeTestFiles(t, callGraphDirsTestFiles)
+
+	bctx := build.Default
+	bctx.GOPATH = dir
+	root := filepath.Join(dir, "src")
+	for _, tt := range []struct {
+		path    string
+		callers bool
+		want    []string
+	}{
+		{"ex.com/a", true, []string{"a", "b", "c"}},
+		{"ex.com/c", false, []string{"c", "b", "a"}},
+		{"ex.com/d", false, []string{"d"}},
+	} {
+		bpkg, err := bctx.ImportDir(filepath.Join(root, filepath.FromSlash(tt.path)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range callGraphDirs(&bctx, root, bpkg, tt.callers) {
+			got = append(got, filepath.Base(d))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("callGraphDirs(%s, %v) = %v, want %v", tt.path, tt.callers, got, tt.want)
+		}
+	}
+
+	// The directory of a package outside of root is not returned.
+	bpkg, err := bctx.Import("fmt", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range callGraphDirs(&bctx, root, bpkg, true) {
+		got = append(got, filepath.Base(d))
+	}
+	if want := []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("callGraphDirs(fmt, true) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/copylock"
+	"golang.org/x/tools/go/analysis/passes/lostcancel"
+	"golang.org/x/tools/go/analysis/passes/printf"
+	"golang.org/x/tools/go/analysis/passes/shadow"
+	"golang.org/x/tools/go/analysis/passes/unusedresult"
+)
+
+// checkAnalyzers is the suite of analyzers run by the check command. Team
+// analyzers are registered by appending to this slice from an init function.
+var checkAnalyzers = []*analysis.Analyzer{
+	copylock.Analyzer,
+	lostcancel.Analyzer,
+	printf.Analyzer,
+	shadow.Analyzer,
+	unusedresult.Analyzer,
+}
+
+func init() {
+	var fs flag.FlagSet
+	deprecated := fs.Bool("deprecated", false, "report uses of deprecated identifiers")
+	commands["check"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doCheck(ctx, *deprecated) },
+	}
+}
+
+// diagnostic is a finding reported by the check command.
+type diagnostic struct {
+	pos      token.Position
+	analyzer string
+	message  string
+}
+
+// doCheck runs the analyzers on the package in the current directory. If a
+// file name is given, then the contents of the file are read from stdin. If
+// deprecated is true, then uses of deprecated identifiers in the file or
+// package are also reported.
+func doCheck(ctx *Context, deprecated bool) int {
+	if len(ctx.args) > 1 {
+		fmt.Fprint(ctx.out, "check: zero or one argument expected\n")
+		return 1
+	}
+	fname := ""
+	if len(ctx.args) == 1 {
+		in, err := ioutil.ReadAll(ctx.in)
+		if err != nil {
+			fmt.Fprintf(ctx.out, "check: %v\n", err)
+			return 1
+		}
+		fname = ctx.args[0]
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(ctx.cwd, fname)
+		}
+		ctx.overlay = map[string][]byte{fname: in}
+	}
+
+	pkg, err := ctx.loadPackage(".", loadTypes)
+	if err != nil {
+		fmt.Fprintf(ctx.out, "check: %v\n", err)
+		return 1
+	}
+
+	var diags []*diagnostic
+	var typeErrors []types.Error
+	for _, err := range pkg.errors {
+		switch err := err.(type) {
+		case types.Error:
+			typeErrors = append(typeErrors, err)
+			diags = append(diags, &diagnostic{pkg.fset.Position(err.Pos), "compile", err.Msg})
+		case scanner.ErrorList:
+			for _, e := range err {
+				diags = append(diags, &diagnostic{e.Pos, "compile", e.Msg})
+			}
+		default:
+			diags = append(diags, &diagnostic{analyzer: "compile", message: err.Error()})
+		}
+	}
+
+	if pkg.tpkg != nil {
+		r := &analysisRunner{
+			pkg:        pkg,
+			typeErrors: typeErrors,
+			results:    make(map[*analysis.Analyzer]*analysisResult),
+			facts:      make(map[analysisFactKey]analysis.Fact),
+		}
+		for _, a := range checkAnalyzers {
+			res := r.run(a)
+			if res.err != nil {
+				diags = append(diags, &diagnostic{analyzer: a.Name, message: res.err.Error()})
+			}
+			for _, d := range res.diagnostics {
+				diags = append(diags, &diagnostic{pkg.fset.Position(d.Pos), a.Name, d.Message})
+			}
+		}
+		if deprecated {
+			diags = append(diags, deprecatedUses(pkg, fname, ctx.cacheDir)...)
+		}
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i].pos, diags[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+	for _, d := range diags {
+		if d.pos.Filename == "" {
+			fmt.Fprintf(w, "%s: [%s] %s\n", pkg.bpkg.Dir, d.analyzer, d.message)
+			continue
+		}
+		fname := d.pos.Filename
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(pkg.bpkg.Dir, fname)
+		}
+		fmt.Fprintf(w, "%s:%d:%d: [%s] %s\n", fname, d.pos.Line, d.pos.Column, d.analyzer, d.message)
+	}
+	return 0
+}
+
+type analysisResult struct {
+	result      interface{}
+	err         error
+	diagnostics []analysis.Diagnostic
+}
+
+type analysisFactKey struct {
+	obj types.Object // nil for package facts
+	pkg *types.Package
+	typ reflect.Type
+}
+
+// analysisRunner runs analyzers and their requirements on a single package.
+// Facts are not computed for dependencies of the package, so, for example, calls
+// to printf wrappers declared in other packages are not checked.
+type analysisRunner struct {
+	pkg        *Package
+	typeErrors []types.Error
+	results    map[*analysis.Analyzer]*analysisResult
+	facts      map[analysisFactKey]analysis.Fact
+}
+
+func (r *analysisRunner) run(a *analysis.Analyzer) *analysisResult {
+	if res, ok := r.results[a]; ok {
+		return res
+	}
+	res := &analysisResult{}
+	r.results[a] = res
+
+	resultOf := make(map[*analysis.Analyzer]interface{})
+	for _, req := range a.Requires {
+		reqRes := r.run(req)
+		if reqRes.err != nil {
+			res.err = fmt.Errorf("%s: %v", req.Name, reqRes.err)
+			return res
+		}
+		resultOf[req] = reqRes.result
+	}
+
+	if len(r.typeErrors) > 0 && !a.RunDespiteErrors {
+		return res
+	}
+
+	pass := &analysis.Pass{
+		Analyzer:     a,
+		Fset:         r.pkg.fset,
+		Files:        r.pkg.files,
+		OtherFiles:   r.pkg.bpkg.SFiles,
+		IgnoredFiles: r.pkg.bpkg.IgnoredGoFiles,
+		Pkg:          r.pkg.tpkg,
+		TypesInfo:    r.pkg.info,
+		TypesSizes:   types.SizesFor("gc", r.pkg.bctx.GOARCH),
+		TypeErrors:   r.typeErrors,
+		ResultOf:     resultOf,
+		Report:       func(d analysis.Diagnostic) { res.diagnostics = append(res.diagnostics, d) },
+		ReadFile: func(fname string) ([]byte, error) {
+			if p, ok := r.pkg.overlay[fname]; ok {
+				return p, nil
+			}
+			return ioutil.ReadFile(fname)
+		},
+		ImportObjectFact: func(obj types.Object, fact analysis.Fact) bool {
+			return r.importFact(analysisFactKey{obj: obj, typ: reflect.TypeOf(fact)}, fact)
+		},
+		ExportObjectFact: func(obj types.Object, fact analysis.Fact) {
+			r.facts[analysisFactKey{obj: obj, typ: reflect.TypeOf(fact)}] = fact
+		},
+		ImportPackageFact: func(pkg *types.Package, fact analysis.Fact) bool {
+			return r.importFact(analysisFactKey{pkg: pkg, typ: reflect.TypeOf(fact)}, fact)
+		},
+		ExportPackageFact: func(fact analysis.Fact) {
+			r.facts[analysisFactKey{pkg: r.pkg.tpkg, typ: reflect.TypeOf(fact)}] = fact
+		},
+		AllObjectFacts: func() []analysis.ObjectFact {
+			var facts []analysis.ObjectFact
+			for k, f := range r.facts {
+				if k.obj != nil {
+					facts = append(facts, analysis.ObjectFact{Object: k.obj, Fact: f})
+				}
+			}
+			return facts
+		},
+		AllPackageFacts: func() []analysis.PackageFact {
+			var facts []analysis.PackageFact
+			for k, f := range r.facts {
+				if k.pkg != nil {
+					facts = append(facts, analysis.PackageFact{Package: k.pkg, Fact: f})
+				}
+			}
+			return facts
+		},
+	}
+
+	res.result, res.err = a.Run(pass)
+	return res
+}
+
+func (r *analysisRunner) importFact(key analysisFactKey, fact analysis.Fact) bool {
+	f, ok := r.facts[key]
+	if !ok {
+		return false
+	}
+	reflect.ValueOf(fact).Elem().Set(reflect.ValueOf(f).Elem())
+	return true
+}
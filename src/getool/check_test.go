@@ -0,0 +1,163 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const checkTestFile = `package p
+
+import "fmt"
+
+func f() {
+	fmt.Printf("%d\n", "x")
+}
+`
+
+func TestCheck(t *testing.T) {
+	// The file on disk is replaced by the buffer read from stdin.
+	dir := writeTestFiles(t, map[string]string{"p.go": "package p\n"})
+
+	var buf bytes.Buffer
+	doCheck(&Context{
+		out:  &buf,
+		in:   strings.NewReader(checkTestFile),
+		cwd:  dir,
+		args: []string{"p.go"},
+	}, false)
+	out := buf.String()
+	want := filepath.Join(dir, "p.go") + ":6:14: [printf] fmt.Printf format %d has arg \"x\" of wrong type string\n"
+	if out != want {
+		t.Errorf("check = %q, want %q", out, want)
+	}
+}
+
+func TestCheckNewFile(t *testing.T) {
+	// The buffer is a file that is not saved to disk yet.
+	dir := writeTestFiles(t, map[string]string{"p.go": "package p\n"})
+
+	var buf bytes.Buffer
+	doCheck(&Context{
+		out:  &buf,
+		in:   strings.NewReader(checkTestFile),
+		cwd:  dir,
+		args: []string{"new.go"},
+	}, false)
+	out := buf.String()
+	want := filepath.Join(dir, "new.go") + ":6:14: [printf] fmt.Printf format %d has arg \"x\" of wrong type string\n"
+	if out != want {
+		t.Errorf("check = %q, want %q", out, want)
+	}
+}
+
+var checkFactsTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"q/q.go": `package q
+
+import "fmt"
+
+func Logf(format string, args ...interface{}) { fmt.Printf(format, args...) }
+`,
+	"p/p.go": `package p
+
+import (
+	"fmt"
+
+	"example.com/ws/q"
+)
+
+func logf(format string, args ...interface{}) { fmt.Printf(format, args...) }
+
+func f() {
+	logf("%d", "x")
+	q.Logf("%d", "x")
+}
+`,
+}
+
+// TestCheckFacts documents that facts are not computed for dependencies. The
+// printf wrapper in the package is checked, the wrapper in q is not.
+func TestCheckFacts(t *testing.T) {
+	dir := writeTestFiles(t, checkFactsTestFiles)
+
+	var buf bytes.Buffer
+	doCheck(&Context{out: &buf, cwd: filepath.Join(dir, "p")}, false)
+	out := buf.String()
+	want := filepath.Join(dir, "p", "p.go") + ":12:8: [printf] example.com/ws/p.logf format %d has arg \"x\" of wrong type string\n"
+	if out != want {
+		t.Errorf("check = %q, want %q", out, want)
+	}
+}
+
+var checkDeprecatedTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+import (
+	"example.com/ws/old"
+	"example.com/ws/b"
+)
+
+func f(s *b.Server) {
+	old.F()
+	b.Old()
+	b.New()
+	s.Close()
+	_ = s.Name
+	_ = b.Limit
+}
+`,
+	"old/old.go": "// Package old is old.\n//\n// Deprecated: Use b.\npackage old\n\nfunc F() {}\n",
+	"b/b.go": `package b
+
+// Old does something.
+//
+// Deprecated: Use New
+// instead.
+func Old() {}
+
+func New() {}
+
+type Server struct {
+	// Deprecated: Use Addr.
+	Name string
+}
+
+// Close closes the server.
+//
+// Deprecated: Servers do not need to be closed.
+func (s *Server) Close() {}
+
+const (
+	// Deprecated: No limit.
+	Limit = 10
+)
+`,
+}
+
+func TestCheckDeprecated(t *testing.T) {
+	dir := writeTestFiles(t, checkDeprecatedTestFiles)
+
+	var buf bytes.Buffer
+	doCheck(&Context{
+		out:  &buf,
+		in:   strings.NewReader(checkDeprecatedTestFiles["a/a.go"]),
+		cwd:  filepath.Join(dir, "a"),
+		args: []string{"a.go"},
+	}, true)
+	fname := filepath.Join(dir, "a", "a.go")
+	want := fname + ":4:2: [deprecated] package example.com/ws/old is deprecated: Use b.\n" +
+		fname + ":10:4: [deprecated] b.Old is deprecated: Use New instead.\n" +
+		fname + ":12:4: [deprecated] b.Server.Close is deprecated: Servers do not need to be closed.\n" +
+		fname + ":13:8: [deprecated] b.Name is deprecated: Use Addr.\n" +
+		fname + ":14:8: [deprecated] b.Limit is deprecated: No limit.\n"
+	if out := buf.String(); out != want {
+		t.Errorf("check -deprecated = %q, want %q", out, want)
+	}
+}
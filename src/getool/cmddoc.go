@@ -0,0 +1,513 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandDoc is the documentation for the flags and subcommands of a
+// command found by static analysis of the command's source.
+type commandDoc struct {
+	flags       []*cmdFlag // flags not defined for a subcommand
+	subcommands []*subcommand
+}
+
+// cmdFlag is a flag defined by a call to a function in the flag package or a
+// method of flag.FlagSet.
+type cmdFlag struct {
+	name  string
+	arg   string // name of the flag argument or "" for boolean flags
+	usage string
+	value string // default value or "" for the zero value
+	pos   token.Pos
+}
+
+// subcommand is an entry in a map from subcommand names to commands.
+type subcommand struct {
+	name  string
+	pos   token.Pos
+	flags []*cmdFlag
+}
+
+// flagFuncs maps the flag defining functions to the index of the name,
+// default value and usage arguments and the argument name used by
+// flag.PrintDefaults. An index of -1 is used for a missing argument.
+var flagFuncs = map[string]struct {
+	name, value, usage int
+	arg                string
+}{
+	"Bool":        {0, 1, 2, ""},
+	"BoolVar":     {1, 2, 3, ""},
+	"BoolFunc":    {0, -1, 1, ""},
+	"Duration":    {0, 1, 2, "duration"},
+	"DurationVar": {1, 2, 3, "duration"},
+	"Float64":     {0, 1, 2, "float"},
+	"Float64Var":  {1, 2, 3, "float"},
+	"Func":        {0, -1, 1, "value"},
+	"Int":         {0, 1, 2, "int"},
+	"IntVar":      {1, 2, 3, "int"},
+	"Int64":       {0, 1, 2, "int"},
+	"Int64Var":    {1, 2, 3, "int"},
+	"String":      {0, 1, 2, "string"},
+	"StringVar":   {1, 2, 3, "string"},
+	"TextVar":     {1, 2, 3, "value"},
+	"Uint":        {0, 1, 2, "uint"},
+	"UintVar":     {1, 2, 3, "uint"},
+	"Uint64":      {0, 1, 2, "uint"},
+	"Uint64Var":   {1, 2, 3, "uint"},
+	"Var":         {1, -1, 2, "value"},
+}
+
+// flagSetKey identifies a flag.FlagSet variable. The function is nil for
+// package level variables.
+type flagSetKey struct {
+	fn   *ast.FuncDecl
+	name string
+}
+
+// commandAnalyzer finds the flags and subcommands in the files of a command.
+type commandAnalyzer struct {
+	files []*ast.File
+
+	// Names of the variables declared as flag.FlagSet, *flag.FlagSet or set
+	// to the result of flag.NewFlagSet.
+	flagSets map[string]bool
+
+	// Package level maps from strings to functions or to structs with a
+	// function field. The maps are assumed to be subcommand tables.
+	maps map[string]bool
+
+	// Element types of package level maps with string keys and package
+	// level struct types by name.
+	mapElems map[string]ast.Expr
+	structs  map[string]*ast.StructType
+
+	// Flags by flag set. Flags defined by the functions in the flag package
+	// use the zero key.
+	flags    map[flagSetKey][]*cmdFlag
+	flagKeys []flagSetKey
+
+	subcommands []*subcommand
+
+	// Flag sets used by a subcommand.
+	used map[flagSetKey]bool
+}
+
+// readCommandDoc returns the flags and subcommands of the command in pkg.
+// Nil is returned if none are found.
+func readCommandDoc(pkg *Package) *commandDoc {
+	a := &commandAnalyzer{
+		flagSets: make(map[string]bool),
+		maps:     make(map[string]bool),
+		mapElems: make(map[string]ast.Expr),
+		structs:  make(map[string]*ast.StructType),
+		flags:    make(map[flagSetKey][]*cmdFlag),
+		used:     make(map[flagSetKey]bool),
+	}
+	for _, name := range append(append([]string(nil), pkg.bpkg.GoFiles...), pkg.bpkg.CgoFiles...) {
+		// The files are parsed again because the files in pkg are
+		// modified by go/doc.
+		file, err := pkg.parseFile(name)
+		if err != nil {
+			continue
+		}
+		a.files = append(a.files, file)
+	}
+	for _, file := range a.files {
+		a.findVars(file)
+	}
+	for name, elem := range a.mapElems {
+		a.maps[name] = a.isCommandType(elem)
+	}
+	for _, file := range a.files {
+		a.findFlagsAndCommands(file)
+	}
+
+	d := &commandDoc{subcommands: a.subcommands}
+	for _, key := range a.flagKeys {
+		if !a.used[key] {
+			d.flags = append(d.flags, a.flags[key]...)
+		}
+	}
+	if len(d.flags) == 0 && len(d.subcommands) == 0 {
+		return nil
+	}
+	sort.SliceStable(d.subcommands, func(i, j int) bool { return d.subcommands[i].name < d.subcommands[j].name })
+	return d
+}
+
+// flagName returns the name of the flag package in file or "" if the file
+// does not import the flag package.
+func flagName(file *ast.File) string {
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == "flag" {
+			if spec.Name != nil {
+				return spec.Name.Name
+			}
+			return "flag"
+		}
+	}
+	return ""
+}
+
+// isFlagSel returns true if x is the selector pkg.name where pkg is the flag
+// package.
+func isFlagSel(x ast.Expr, pkg string, name string) bool {
+	if star, ok := x.(*ast.StarExpr); ok {
+		x = star.X
+	}
+	sel, ok := x.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && pkg != "" && id.Name == pkg && id.Obj == nil
+}
+
+// findVars records the flag set variables, the package level maps with
+// string keys and the package level struct types in file.
+func (a *commandAnalyzer) findVars(file *ast.File) {
+	pkg := flagName(file)
+	isNewFlagSet := func(x ast.Expr) bool {
+		call, ok := x.(*ast.CallExpr)
+		return ok && isFlagSel(call.Fun, pkg, "NewFlagSet")
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				if (n.Type != nil && isFlagSel(n.Type, pkg, "FlagSet")) ||
+					(i < len(n.Values) && isNewFlagSet(n.Values[i])) {
+					a.flagSets[name.Name] = true
+				}
+			}
+		case *ast.Field:
+			if isFlagSel(n.Type, pkg, "FlagSet") {
+				for _, name := range n.Names {
+					a.flagSets[name.Name] = true
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && i < len(n.Rhs) && isNewFlagSet(n.Rhs[i]) {
+					a.flagSets[id.Name] = true
+				}
+			}
+		}
+		return true
+	})
+
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range d.Specs {
+			if ts, ok := spec.(*ast.TypeSpec); ok {
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					a.structs[ts.Name.Name] = st
+				}
+				continue
+			}
+			s, ok := spec.(*ast.ValueSpec)
+			if !ok || d.Tok != token.VAR {
+				continue
+			}
+			for i, name := range s.Names {
+				t := s.Type
+				if t == nil && i < len(s.Values) {
+					if lit, ok := s.Values[i].(*ast.CompositeLit); ok {
+						t = lit.Type
+					}
+				}
+				if m, ok := t.(*ast.MapType); ok {
+					if id, ok := m.Key.(*ast.Ident); ok && id.Name == "string" {
+						a.mapElems[name.Name] = m.Value
+					}
+				}
+			}
+		}
+	}
+}
+
+// isCommandType returns true if t is a function type or a struct type with
+// a function field.
+func (a *commandAnalyzer) isCommandType(t ast.Expr) bool {
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch t := t.(type) {
+	case *ast.FuncType:
+		return true
+	case *ast.Ident:
+		if st := a.structs[t.Name]; st != nil {
+			for _, f := range st.Fields.List {
+				if _, ok := f.Type.(*ast.FuncType); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// findFlagsAndCommands records the flags defined and the subcommands added
+// to maps in file.
+func (a *commandAnalyzer) findFlagsAndCommands(file *ast.File) {
+	pkg := flagName(file)
+	for _, decl := range file.Decls {
+		fn, _ := decl.(*ast.FuncDecl)
+		ast.Inspect(decl, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				a.addFlag(fn, pkg, n)
+			case *ast.AssignStmt:
+				for i, lhs := range n.Lhs {
+					index, ok := lhs.(*ast.IndexExpr)
+					if !ok || i >= len(n.Rhs) {
+						continue
+					}
+					if id, ok := index.X.(*ast.Ident); ok && a.maps[id.Name] {
+						a.addSubcommand(fn, index.Index, n.Rhs[i])
+					}
+				}
+			case *ast.ValueSpec:
+				if fn != nil {
+					break
+				}
+				for i, name := range n.Names {
+					if !a.maps[name.Name] || i >= len(n.Values) {
+						continue
+					}
+					if lit, ok := n.Values[i].(*ast.CompositeLit); ok {
+						for _, elt := range lit.Elts {
+							if kv, ok := elt.(*ast.KeyValueExpr); ok {
+								a.addSubcommand(nil, kv.Key, kv.Value)
+							}
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// addFlag records the flag defined by call in function fn.
+func (a *commandAnalyzer) addFlag(fn *ast.FuncDecl, pkg string, call *ast.CallExpr) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	f, ok := flagFuncs[sel.Sel.Name]
+	if !ok || f.name >= len(call.Args) || f.usage >= len(call.Args) {
+		return
+	}
+	var key flagSetKey
+	switch x := sel.X.(type) {
+	case *ast.Ident:
+		switch {
+		case pkg != "" && x.Name == pkg && x.Obj == nil:
+			// Function in the flag package.
+		case a.flagSets[x.Name]:
+			key = a.flagSetKey(fn, x.Name)
+		default:
+			return
+		}
+	default:
+		if !isFlagSel(x, pkg, "CommandLine") {
+			return
+		}
+	}
+
+	flag := &cmdFlag{
+		name:  stringValue(call.Args[f.name]),
+		arg:   f.arg,
+		usage: stringValue(call.Args[f.usage]),
+		pos:   call.Args[f.name].Pos(),
+	}
+	if f.value >= 0 && f.value < len(call.Args) {
+		flag.value = types.ExprString(call.Args[f.value])
+		switch flag.value {
+		case "false", "0", `""`, "nil":
+			flag.value = ""
+		}
+	}
+	// Use a name in back quotes as the argument name as flag.UnquoteUsage
+	// does.
+	if i := strings.IndexByte(flag.usage, '`'); i >= 0 {
+		if j := strings.IndexByte(flag.usage[i+1:], '`'); j >= 0 {
+			flag.arg = flag.usage[i+1 : i+1+j]
+			flag.usage = flag.usage[:i] + flag.arg + flag.usage[i+1+j+1:]
+		}
+	}
+
+	if _, ok := a.flags[key]; !ok {
+		a.flagKeys = append(a.flagKeys, key)
+	}
+	a.flags[key] = append(a.flags[key], flag)
+}
+
+// flagSetKey returns the key for the flag set variable with the given name
+// used in function fn.
+func (a *commandAnalyzer) flagSetKey(fn *ast.FuncDecl, name string) flagSetKey {
+	if fn != nil {
+		for _, field := range fn.Type.Params.List {
+			for _, n := range field.Names {
+				if n.Name == name {
+					return flagSetKey{fn, name}
+				}
+			}
+		}
+		if fn.Body != nil && declares(fn.Body, name) {
+			return flagSetKey{fn, name}
+		}
+	}
+	return flagSetKey{nil, name}
+}
+
+// declares returns true if the variable name is declared in the block.
+func declares(block *ast.BlockStmt, name string) bool {
+	found := false
+	ast.Inspect(block, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for _, id := range n.Names {
+				found = found || id.Name == name
+			}
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && id.Name == name {
+						found = true
+					}
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// addSubcommand records the subcommand with the given key and value in a
+// map of subcommands. The flags of the flag sets referenced in the value are
+// the flags of the subcommand. If the key is the value variable of a range
+// over a slice of string literals in fn, then a subcommand is recorded for
+// each string.
+func (a *commandAnalyzer) addSubcommand(fn *ast.FuncDecl, key ast.Expr, value ast.Expr) {
+	if id, ok := key.(*ast.Ident); ok && fn != nil && fn.Body != nil {
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			r, ok := n.(*ast.RangeStmt)
+			if !ok {
+				return true
+			}
+			if v, ok := r.Value.(*ast.Ident); ok && v.Name == id.Name {
+				if lit, ok := r.X.(*ast.CompositeLit); ok {
+					for _, elt := range lit.Elts {
+						a.addSubcommand(fn, elt, value)
+					}
+				}
+			}
+			return true
+		})
+		return
+	}
+	lit, ok := key.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return
+	}
+	name, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return
+	}
+	c := &subcommand{name: name, pos: lit.Pos()}
+	seen := make(map[flagSetKey]bool)
+	ast.Inspect(value, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && a.flagSets[id.Name] {
+			key := a.flagSetKey(fn, id.Name)
+			if !seen[key] {
+				seen[key] = true
+				a.used[key] = true
+				c.flags = append(c.flags, a.flags[key]...)
+			}
+		}
+		return true
+	})
+	a.subcommands = append(a.subcommands, c)
+}
+
+// stringValue returns the value of a string literal or a sum of string
+// literals. The source of other expressions is returned.
+func stringValue(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		if x.Kind == token.STRING {
+			if s, err := strconv.Unquote(x.Value); err == nil {
+				return s
+			}
+		}
+	case *ast.BinaryExpr:
+		if x.Op == token.ADD {
+			return stringValue(x.X) + stringValue(x.Y)
+		}
+	case *ast.ParenExpr:
+		return stringValue(x.X)
+	}
+	return types.ExprString(x)
+}
+
+// printCommandDoc prints the flags and subcommands of a command.
+func (p *docPrinter) printCommandDoc() {
+	if p.cmd == nil {
+		return
+	}
+	if len(p.cmd.flags) > 0 {
+		p.buf.WriteString("FLAGS\n\n")
+		for _, f := range p.cmd.flags {
+			p.printFlag(f, textIndent)
+		}
+		p.buf.WriteByte('\n')
+	}
+	if len(p.cmd.subcommands) > 0 {
+		p.buf.WriteString("COMMANDS\n\n")
+		for _, c := range p.cmd.subcommands {
+			p.buf.WriteString(textIndent)
+			p.printSourceLink(c.name, c.pos)
+			p.buf.WriteByte('\n')
+			for _, f := range c.flags {
+				p.printFlag(f, textIndent+textIndent)
+			}
+		}
+		p.buf.WriteByte('\n')
+	}
+}
+
+// printFlag prints a flag in the format used by flag.PrintDefaults.
+func (p *docPrinter) printFlag(f *cmdFlag, indent string) {
+	p.buf.WriteString(indent + "-")
+	p.printSourceLink(f.name, f.pos)
+	if f.arg != "" {
+		p.buf.WriteString(" " + f.arg)
+	}
+	p.buf.WriteString("\n" + indent + textIndent + strings.Replace(f.usage, "\n", "\n"+indent+textIndent, -1))
+	if f.value != "" {
+		p.buf.WriteString(" (default " + f.value + ")")
+	}
+	p.buf.WriteByte('\n')
+}
+
+// printSourceLink prints s with a link to the source position pos.
+func (p *docPrinter) printSourceLink(s string, pos token.Pos) {
+	position := p.fset.Position(pos)
+	p.printLink(s, filepath.Join(p.bpkg.Dir, position.Filename),
+		-p.lineColumnAddress(position.Line, position.Column))
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var commandDocTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"cmd/main.go": `// Command cmd does things.
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var verbose = flag.Bool("v", false, "verbose ` + "`output`" + `")
+
+var names = map[string]int{"a": 1}
+
+var commands = map[string]func(args []string){
+	"list": runList,
+}
+
+func main() {
+	var timeout time.Duration
+	flag.DurationVar(&timeout, "timeout", time.Second, "request timeout")
+	flag.Parse()
+}
+
+func runList(args []string) {}
+`,
+	"cmd/build.go": `package main
+
+import "flag"
+
+type command struct {
+	fs  *flag.FlagSet
+	run func()
+}
+
+var table = map[string]*command{}
+
+func init() {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	out := fs.String("o", "a.out", "write output to ` + "`file`" + `")
+	_ = out
+	table["build"] = &command{fs: fs, run: func() {}}
+	for _, name := range []string{"clean", "tidy"} {
+		table[name] = &command{run: func() {}}
+	}
+}
+`,
+}
+
+func TestCommandDoc(t *testing.T) {
+	dir := writeTestFiles(t, commandDocTestFiles)
+
+	var buf bytes.Buffer
+	doDoc(&Context{
+		out:  &buf,
+		cwd:  dir,
+		args: []string{"example.com/ws/cmd"},
+	}, false, "")
+	out := buf.String()
+	want := "Command cmd\n\n" +
+		"    Command cmd does things.\n\n" +
+		"FLAGS\n\n" +
+		"    -v output\n        verbose output\n" +
+		"    -timeout duration\n        request timeout (default time.Second)\n\n" +
+		"COMMANDS\n\n" +
+		"    build\n        -o file\n            write output to file (default \"a.out\")\n" +
+		"    clean\n" +
+		"    list\n" +
+		"    tidy\n\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("doc does not contain\n%s\ngot\n%s", want, out)
+	}
+
+	// The flag and command names link to the source.
+	for _, link := range []string{"S " + filepath.Join(dir, "cmd", "main.go") + "\n", "S " + filepath.Join(dir, "cmd", "build.go") + "\n"} {
+		if !strings.Contains(out, link) {
+			t.Errorf("doc does not contain %q", link)
+		}
+	}
+}
@@ -9,6 +9,17 @@
 //  ./relpath   - Relative path
 //  \name       - Name of imported package
 //
+// The resolve command also accepts a symbol in the package. The symbol
+// follows the package specification after a dot or '#' as in net/http.Client.Do,
+// \http.NewRequest and net/http#Client, or the specification is a
+// pkg.go.dev or godoc.org URL.
+//
+// The imported packages are the imports of the current file followed by the
+// imports of the other files in the package. Dot imports, blank imports and
+// imports shadowed by an earlier import with the same name are completed with
+// the import path in a suffix: \name(dot:path), \name(blank:path) and
+// \name(path).
+//
 // The complete and resolve commands silently ignore errors. It is assumed that
 // downstream uses of the command results will detect and handle errors in some
 // way.
@@ -33,18 +44,20 @@ import (
 
 func init() {
 	var cfs flag.FlagSet
+	cfile := cfs.String("file", "", "`file` read from stdin")
 	commands["complete-package-id"] = &Command{
 		fs: &cfs,
-		do: func(ctx *Context) int { return doCompletePackageID(ctx) },
+		do: func(ctx *Context) int { return doCompletePackageID(ctx, *cfile) },
 	}
 	var rfs flag.FlagSet
+	rfile := rfs.String("file", "", "`file` read from stdin")
 	commands["resolve-package"] = &Command{
 		fs: &rfs,
-		do: func(ctx *Context) int { return doResolvePackage(ctx) },
+		do: func(ctx *Context) int { return doResolvePackage(ctx, *rfile) },
 	}
 }
 
-func doCompletePackageID(ctx *Context) int {
+func doCompletePackageID(ctx *Context, fname string) int {
 	if len(ctx.args) != 3 {
 		fmt.Fprint(ctx.out, "complete: three arguments required\n")
 		return 1
@@ -54,16 +67,16 @@ func doCompletePackageID(ctx *Context) int {
 	f := strings.Fields(cmdLine)
 	var completions []string
 	if len(f) >= 3 || (len(f) == 2 && argLead == "") {
-		completions = completeID(ctx, resolvePackageSpec(ctx, f[1]), argLead)
+		completions = completeID(ctx, resolvePackageSpec(ctx, fname, f[1]), argLead)
 	} else {
-		completions = completePackage(ctx, argLead)
+		completions = completePackage(ctx, fname, argLead)
 	}
 	io.Copy(ioutil.Discard, ctx.in)
 	ctx.out.Write([]byte(strings.Join(completions, "\n")))
 	return 0
 }
 
-func completePackage(ctx *Context, arg string) (completions []string) {
+func completePackage(ctx *Context, fname string, arg string) (completions []string) {
 	switch {
 	case arg == ".":
 		completions = []string{"./", "../"}
@@ -73,7 +86,7 @@ func completePackage(ctx *Context, arg string) (completions []string) {
 
 	case strings.HasPrefix(arg, "."):
 		// Complete using relative directory.
-		bpkg, err := build.Import(".", ctx.cwd, build.FindOnly)
+		bpkg, err := ctx.buildContext().Import(".", ctx.cwd, build.FindOnly)
 		if err != nil {
 			return nil
 		}
@@ -93,10 +106,10 @@ func completePackage(ctx *Context, arg string) (completions []string) {
 		sort.Strings(completions)
 
 	case strings.HasPrefix(arg, "\\"):
-		// Complete with package names imported in current file.
-		for n := range readImports(ctx.in) {
-			if strings.HasPrefix(n, arg[1:]) {
-				completions = append(completions, "\\"+n)
+		// Complete with package names imported in current package.
+		for _, imp := range readImports(ctx, ctx.in, fname) {
+			if spec := imp.spec(); strings.HasPrefix(spec, arg) {
+				completions = append(completions, spec)
 			}
 		}
 		if len(completions) == 0 && len(completePackageByPath(arg)) > 0 {
@@ -135,117 +148,274 @@ func completePackageByPath(arg string) []string {
 
 func completeID(ctx *Context, importPath string, arg string) (completions []string) {
 	pkg, err := ctx.loadPackage(importPath, loadDoc)
-	if err != nil {
+	if err != nil || pkg.dpkg == nil {
 		return []string{arg}
 	}
 
+	prefix := strings.ToLower(strings.Replace(arg, deprecatedMarker, "", -1))
 	typeName := ""
-	name := strings.ToLower(arg)
-	if i := strings.Index(name, "."); i >= 0 {
-		typeName = name[:i]
-		name = name[i+1:]
+	if i := strings.Index(prefix, "."); i >= 0 {
+		typeName = prefix[:i]
 	}
 
 	dpkg := pkg.dpkg
 
+	// Deprecated identifiers are sorted after the other identifiers and
+	// marked with deprecatedMarker.
+	var deprecated []string
+	add := func(n string, text string) {
+		if !strings.HasPrefix(strings.ToLower(n), prefix) {
+			return
+		}
+		if isDeprecated(text) {
+			deprecated = append(deprecated, n+deprecatedMarker)
+		} else {
+			completions = append(completions, n)
+		}
+	}
+
 	if typeName == "" {
 		untangleDoc(dpkg)
-		add := func(n string) {
-			if strings.HasPrefix(strings.ToLower(n), name) {
-				completions = append(completions, n)
-			}
-		}
 		for _, d := range append(pkg.dpkg.Consts, pkg.dpkg.Vars...) {
 			for _, n := range d.Names {
-				add(n)
+				add(n, d.Doc)
 			}
 		}
 		for _, d := range pkg.dpkg.Funcs {
-			add(d.Name)
+			add(d.Name, d.Doc)
 		}
 		for _, d := range pkg.dpkg.Types {
-			add(d.Name + ".")
+			add(d.Name+".", d.Doc)
 		}
 	} else {
 		for _, d := range pkg.dpkg.Types {
 			if strings.ToLower(d.Name) == typeName {
-				add := func(n string) {
-					if strings.HasPrefix(strings.ToLower(n), name) {
-						completions = append(completions, d.Name+"."+n)
-					}
-				}
-				for _, d := range d.Methods {
-					add(d.Name)
+				for _, m := range d.Methods {
+					add(d.Name+"."+m.Name, m.Doc)
 				}
 			}
 		}
 	}
 
 	sort.Strings(completions)
-	return completions
+	sort.Strings(deprecated)
+	return append(completions, deprecated...)
 }
 
-func resolvePackageSpec(ctx *Context, spec string) string {
+func resolvePackageSpec(ctx *Context, fname string, spec string) string {
 	path := strings.TrimRight(spec, "/")
 	switch {
 	case strings.HasPrefix(spec, "."):
-		if bpkg, err := build.Import(spec, ctx.cwd, build.FindOnly); err == nil {
+		if bpkg, err := ctx.buildContext().Import(spec, ctx.cwd, build.FindOnly); err == nil {
 			path = bpkg.ImportPath
 		}
 	case strings.HasPrefix(spec, "\\"):
-		if p, ok := readImports(ctx.in)[spec[1:]]; ok {
-			path = p
-		}
+		path = resolveImport(readImports(ctx, ctx.in, fname), spec)
 	}
 	return path
 }
 
-func doResolvePackage(ctx *Context) int {
+// doResolvePackage implements the command
+//
+//	resolve-package [-file file] spec
+//
+// The command prints the import path of the package. If spec includes a
+// symbol, then the anchor of the symbol is printed on a second line.
+func doResolvePackage(ctx *Context, fname string) int {
 	if len(ctx.args) != 1 {
 		fmt.Fprint(ctx.out, "resolve: one argument required\n")
 		return 1
 	}
-	path := resolvePackageSpec(ctx, ctx.args[0])
+	path, anchor := resolveSymbolSpec(ctx, fname, ctx.args[0])
 	io.Copy(ioutil.Discard, ctx.in)
 	io.WriteString(ctx.out, path)
+	if anchor != "" && ctx.supports(2) {
+		io.WriteString(ctx.out, "\n"+anchor)
+	}
 	return 0
 }
 
-// readImports returns the imports in the Go source file read from r. Errors
-// are silently ignored.
-func readImports(r io.Reader) map[string]string {
+var docSites = []string{"pkg.go.dev/", "godoc.org/", "www.godoc.org/"}
+
+// resolveSymbolSpec returns the import path and the symbol anchor for a
+// package specification with an optional symbol. Because import paths can
+// contain dots, the longest prefix of spec that names an existing package is
+// used as the package.
+func resolveSymbolSpec(ctx *Context, fname string, spec string) (path, anchor string) {
+	url := strings.TrimPrefix(strings.TrimPrefix(spec, "https://"), "http://")
+	for _, site := range docSites {
+		if strings.HasPrefix(url, site) {
+			spec = strings.TrimPrefix(url, site)
+			if i := strings.Index(spec, "#"); i >= 0 {
+				spec, anchor = spec[:i], spec[i+1:]
+			}
+			if i := strings.Index(spec, "?"); i >= 0 {
+				spec = spec[:i]
+			}
+			// Remove the version from pkg.go.dev paths such as
+			// golang.org/x/tools@v0.1.0/go/ast.
+			if i := strings.Index(spec, "@"); i >= 0 {
+				j := strings.Index(spec[i:], "/")
+				if j < 0 {
+					j = len(spec) - i
+				}
+				spec = spec[:i] + spec[i+j:]
+			}
+			if strings.HasPrefix(anchor, "pkg-") || strings.HasPrefix(anchor, "example-") {
+				// Section anchors.
+				anchor = ""
+			}
+			return resolvePackageSpec(ctx, fname, spec), anchor
+		}
+	}
+
+	if i := strings.Index(spec, "#"); i >= 0 {
+		return resolvePackageSpec(ctx, fname, spec[:i]), spec[i+1:]
+	}
+
+	if strings.HasPrefix(spec, "\\") {
+		// The name of an imported package does not contain dots, but the
+		// import path suffix in \name(path) can.
+		start := 0
+		if i := strings.Index(spec, ")"); i >= 0 {
+			start = i
+		}
+		if i := strings.Index(spec[start:], "."); i >= 0 {
+			i += start
+			return resolvePackageSpec(ctx, fname, spec[:i]), spec[i+1:]
+		}
+		return resolvePackageSpec(ctx, fname, spec), ""
+	}
+
+	namer := newPackageNamer(ctx.buildContext(), ctx.cwd, ctx.cacheDir)
+	defer namer.close()
+	exists := func(spec string) bool {
+		if strings.HasPrefix(spec, ".") {
+			_, err := ctx.buildContext().Import(spec, ctx.cwd, build.FindOnly)
+			return err == nil
+		}
+		return namer.dir(spec) != ""
+	}
+	spec = strings.TrimRight(spec, "/")
+	if !exists(spec) {
+		start := strings.LastIndex(spec, "/") + 1
+		for i := len(spec) - 1; i > start; i-- {
+			if spec[i] == '.' && i+1 < len(spec) && exists(spec[:i]) {
+				return resolvePackageSpec(ctx, fname, spec[:i]), spec[i+1:]
+			}
+		}
+	}
+	return resolvePackageSpec(ctx, fname, spec), ""
+}
+
+// importName is an import of a package in the files of the current package.
+type importName struct {
+	name string // package name or explicit name, without "." or "_"
+	path string
+	kind string // "", "dot" or "blank"
+
+	// shadowed is set when an earlier import has the same name and a
+	// different path.
+	shadowed bool
+}
+
+// spec returns the package specification used to complete the import.
+func (imp *importName) spec() string {
+	switch {
+	case imp.kind != "":
+		return "\\" + imp.name + "(" + imp.kind + ":" + imp.path + ")"
+	case imp.shadowed:
+		return "\\" + imp.name + "(" + imp.path + ")"
+	default:
+		return "\\" + imp.name
+	}
+}
+
+// resolveImport returns the import path for the \name specification or spec
+// if the name is not imported. Specifications with an import path suffix
+// resolve to the path in the suffix.
+func resolveImport(imports []*importName, spec string) string {
+	if i := strings.Index(spec, "("); i >= 0 && strings.HasSuffix(spec, ")") {
+		path := spec[i+1 : len(spec)-1]
+		path = strings.TrimPrefix(path, "dot:")
+		path = strings.TrimPrefix(path, "blank:")
+		return path
+	}
+	name := spec[1:]
+	for _, imp := range imports {
+		if imp.name == name && imp.kind == "" {
+			return imp.path
+		}
+	}
+	for _, imp := range imports {
+		if imp.name == name {
+			return imp.path
+		}
+	}
+	return spec
+}
+
+// readImports returns the imports in the Go source file read from r followed
+// by the imports in the other files of the package in the directory of
+// fname. Only the file read from r is used if fname is "". The names of
+// packages imported without a name are found relative to the current
+// directory. Errors are silently ignored.
+func readImports(ctx *Context, r io.Reader, fname string) []*importName {
 	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, "", r, parser.ImportsOnly)
-	if err != nil {
+	file, _ := parser.ParseFile(fset, fname, r, parser.ImportsOnly)
+	if file == nil || file.Name == nil {
 		return nil
 	}
-	paths := map[string]string{}
-	set := map[string]bool{}
-	for _, decl := range file.Decls {
-		d, ok := decl.(*ast.GenDecl)
-		if !ok {
-			continue
-		}
-		for _, dspec := range d.Specs {
-			spec, ok := dspec.(*ast.ImportSpec)
-			if !ok || spec.Path == nil {
+	files := []*ast.File{file}
+	if fname != "" {
+		dir := filepath.Dir(fname)
+		fis, _ := ioutil.ReadDir(dir)
+		for _, fi := range fis {
+			name := filepath.Join(dir, fi.Name())
+			if fi.IsDir() || !strings.HasSuffix(name, ".go") || name == fname {
 				continue
 			}
-			quoted := spec.Path.Value
-			path, err := strconv.Unquote(quoted)
+			f, _ := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+			if f != nil && f.Name != nil && f.Name.Name == file.Name.Name {
+				files = append(files, f)
+			}
+		}
+	}
+
+	namer := newPackageNamer(ctx.buildContext(), ctx.cwd, ctx.cacheDir)
+	defer namer.close()
+	var imports []*importName
+	seen := make(map[importName]bool)
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
 			if err != nil || path == "C" {
 				continue
 			}
-			if spec.Name != nil {
-				paths[spec.Name.Name] = path
-				set[spec.Name.Name] = true
-			} else {
-				name := guessNameFromPath(path)
-				if !set[path] {
-					paths[name] = path
+			imp := importName{path: path}
+			switch {
+			case spec.Name == nil:
+				imp.name = namer.name(path)
+			case spec.Name.Name == ".":
+				imp.name, imp.kind = namer.name(path), "dot"
+			case spec.Name.Name == "_":
+				imp.name, imp.kind = namer.name(path), "blank"
+			default:
+				imp.name = spec.Name.Name
+			}
+			if seen[imp] {
+				continue
+			}
+			seen[imp] = true
+			if imp.kind == "" {
+				for _, prev := range imports {
+					if prev.kind == "" && prev.name == imp.name {
+						imp.shadowed = true
+					}
 				}
 			}
+			imports = append(imports, &imp)
 		}
 	}
-	return paths
+	return imports
 }
@@ -7,6 +7,8 @@ package main
 import (
 	"bytes"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -57,7 +59,7 @@ func TestComplete(t *testing.T) {
 				"x " + tt.in,
 				"",
 			},
-		})
+		}, "")
 
 		out := buf.String()
 		if out != tt.out {
@@ -89,10 +91,165 @@ func TestResolve(t *testing.T) {
 			in:   strings.NewReader(completeTestFile),
 			cwd:  cwd,
 			args: []string{tt.in},
-		})
+		}, "")
 		out := buf.String()
 		if out != tt.out {
 			t.Errorf("resolve(%q) = %q, want %q", tt.in, out, tt.out)
 		}
 	}
 }
+
+var completeDeprecatedTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"b/b.go": `package b
+
+// Deprecated: Use New.
+func Old() {}
+
+func New() {}
+
+func Next() {}
+
+type T struct{}
+
+// Deprecated: Use B.
+func (T) A() {}
+
+func (T) B() {}
+`,
+}
+
+var completeDeprecatedTests = []struct {
+	arg string
+	out []string
+}{
+	{"", []string{"New", "Next", "T.", "Old(deprecated)"}},
+	{"n", []string{"New", "Next"}},
+	{"o", []string{"Old(deprecated)"}},
+	{"Old(deprecated)", []string{"Old(deprecated)"}},
+	{"t.", []string{"T.B", "T.A(deprecated)"}},
+}
+
+func TestCompleteDeprecated(t *testing.T) {
+	dir := writeTestFiles(t, completeDeprecatedTestFiles)
+
+	for _, tt := range completeDeprecatedTests {
+		out := completeID(&Context{cwd: dir}, "example.com/ws/b", tt.arg)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("completeID(%q) = %q, want %q", tt.arg, out, tt.out)
+		}
+	}
+}
+
+func TestResolveImportName(t *testing.T) {
+	files := map[string]string{
+		"go.mod":        "module example.com/ws\n",
+		"go-foo/foo.go": "package bar\n",
+	}
+	dir := writeTestFiles(t, files)
+
+	src := "package main\n\nimport (\n\t\"example.com/ws/go-foo\"\n\t\"github.com/user/repo/v2\"\n)\n"
+	for _, tt := range []struct{ in, out string }{
+		{"\\bar", "example.com/ws/go-foo"},
+		{"\\repo", "github.com/user/repo/v2"},
+	} {
+		var buf bytes.Buffer
+		doResolvePackage(&Context{out: &buf, in: strings.NewReader(src), cwd: dir, args: []string{tt.in}}, "")
+		if buf.String() != tt.out {
+			t.Errorf("resolve(%q) = %q, want %q", tt.in, buf.String(), tt.out)
+		}
+	}
+}
+
+func TestPackageImports(t *testing.T) {
+	files := map[string]string{
+		"go.mod":           "module example.com/ws\n",
+		"p/a.go":           "package p\n\nimport (\n\t\"fmt\"\n\t\"log\"\n)\n",
+		"p/b.go":           "package p\n\nimport (\n\t\"fmt\"\n\tlog \"example.com/ws/xlog\"\n\t. \"example.com/ws/util\"\n\t_ \"example.com/ws/driver\"\n\t\"strings\"\n)\n",
+		"p/p_test.go":      "package p_test\n\nimport \"testing\"\n",
+		"util/util.go":     "package util\n",
+		"driver/driver.go": "package driver\n",
+	}
+	dir := writeTestFiles(t, files)
+	pdir := filepath.Join(dir, "p")
+	fname := filepath.Join(pdir, "a.go")
+
+	// The buffer imports net/http and no longer imports log.
+	src := "package p\n\nimport (\n\t\"fmt\"\n\t\"net/http\"\n)\n"
+
+	var buf bytes.Buffer
+	doCompletePackageID(&Context{out: &buf, in: strings.NewReader(src), cwd: pdir, args: []string{"\\", "x \\", ""}}, fname)
+	want := strings.Join([]string{
+		"\\driver(blank:example.com/ws/driver)",
+		"\\fmt",
+		"\\http",
+		"\\log",
+		"\\strings",
+		"\\util(dot:example.com/ws/util)",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("complete = %q, want %q", buf.String(), want)
+	}
+
+	for _, tt := range []struct{ in, out string }{
+		{"\\http", "net/http"},
+		{"\\log", "example.com/ws/xlog"},
+		{"\\strings", "strings"},
+		{"\\util", "example.com/ws/util"},
+		{"\\util(dot:example.com/ws/util)", "example.com/ws/util"},
+		{"\\driver(blank:example.com/ws/driver)", "example.com/ws/driver"},
+		{"\\testing", "\\testing"},
+	} {
+		buf.Reset()
+		doResolvePackage(&Context{out: &buf, in: strings.NewReader(src), cwd: pdir, args: []string{tt.in}}, fname)
+		if buf.String() != tt.out {
+			t.Errorf("resolve(%q) = %q, want %q", tt.in, buf.String(), tt.out)
+		}
+	}
+
+	// An import in the buffer is preferred over an import with the same
+	// name in another file.
+	src = "package p\n\nimport \"log\"\n"
+	buf.Reset()
+	doCompletePackageID(&Context{out: &buf, in: strings.NewReader(src), cwd: pdir, args: []string{"\\l", "x \\l", ""}}, fname)
+	if want := "\\log\n\\log(example.com/ws/xlog)"; buf.String() != want {
+		t.Errorf("complete = %q, want %q", buf.String(), want)
+	}
+	buf.Reset()
+	doResolvePackage(&Context{out: &buf, in: strings.NewReader(src), cwd: pdir, args: []string{"\\log"}}, fname)
+	if buf.String() != "log" {
+		t.Errorf("resolve(\\log) = %q, want %q", buf.String(), "log")
+	}
+}
+
+func TestResolveSymbol(t *testing.T) {
+	files := map[string]string{
+		"go.mod":       "module example.com/ws\n",
+		"yaml.v2/y.go": "package yaml\n",
+		"p/p.go":       "package p\n",
+	}
+	dir := writeTestFiles(t, files)
+
+	src := "package p\n\nimport \"net/http\"\n"
+	for _, tt := range []struct{ in, out string }{
+		{"net/http", "net/http"},
+		{"net/http.Client", "net/http\nClient"},
+		{"net/http.Client.Do", "net/http\nClient.Do"},
+		{"fmt.Println", "fmt\nPrintln"},
+		{"\\http.NewRequest", "net/http\nNewRequest"},
+		{"\\http(dot:net/http).Get", "net/http\nGet"},
+		{"net/http#Client", "net/http\nClient"},
+		{"example.com/ws/yaml.v2", "example.com/ws/yaml.v2"},
+		{"example.com/ws/yaml.v2.Marshal", "example.com/ws/yaml.v2\nMarshal"},
+		{"https://pkg.go.dev/net/http#Client.Do", "net/http\nClient.Do"},
+		{"pkg.go.dev/net/http?tab=doc#pkg-constants", "net/http"},
+		{"https://pkg.go.dev/example.com/ws@v1.2.0/yaml.v2#Unmarshal", "example.com/ws/yaml.v2\nUnmarshal"},
+		{"https://godoc.org/net/http#Get", "net/http\nGet"},
+	} {
+		var buf bytes.Buffer
+		doResolvePackage(&Context{out: &buf, in: strings.NewReader(src), cwd: filepath.Join(dir, "p"), args: []string{tt.in}}, "")
+		if buf.String() != tt.out {
+			t.Errorf("resolve(%q) = %q, want %q", tt.in, buf.String(), tt.out)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFileName is the name of the per project configuration file. The file
+// is found by walking up the directory tree from the directory of the file
+// being processed. Each line of the file has the form
+//
+//	name = value
+//
+// Blank lines and lines starting with # are ignored.
+const configFileName = ".getool"
+
+// readConfig returns the settings in the configuration file for dir. Errors
+// are silently ignored.
+func readConfig(dir string) map[string]string {
+	for {
+		f, err := os.Open(filepath.Join(dir, configFileName))
+		if err == nil {
+			defer f.Close()
+			config := map[string]string{}
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				line := strings.TrimSpace(s.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				i := strings.Index(line, "=")
+				if i < 0 {
+					continue
+				}
+				config[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+			return config
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
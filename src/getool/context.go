@@ -5,17 +5,24 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"go/ast"
 	"go/build"
 	"go/doc"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io"
 	"io/ioutil"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type Context struct {
@@ -23,25 +30,165 @@ type Context struct {
 	args []string
 	in   io.Reader
 	out  io.Writer
+
+	// overlay maps absolute file names to contents that replace the
+	// contents of the file on disk.
+	overlay map[string][]byte
+
+	// bctx is the build context selected with the -goos, -goarch and -tags
+	// flags. The default build context is used if nil.
+	bctx *build.Context
+
+	// protocol is the protocol version requested with the -protocol flag.
+	// Zero selects the current version.
+	protocol int
+
+	// cacheDir is the directory for files kept between runs. Nothing is
+	// kept if cacheDir is empty.
+	cacheDir string
+
+	// fset and importer are shared by the packages loaded after a call to
+	// shareImporter.
+	fset     *token.FileSet
+	importer *sourceImporter
 }
 
-var linePat = regexp.MustCompile(`(?m)^//line .*$`)
+// shareImporter makes the packages subsequently loaded with ctx share a file
+// set and an importer so that common dependencies are type checked once.
+// Modules are resolved relative to directory dir.
+func (ctx *Context) shareImporter(dir string) {
+	ctx.fset = token.NewFileSet()
+	ctx.importer = newSourceImporter(ctx.fset, ctx.buildContext(), dir)
+}
 
-func simpleImporter(imports map[string]*ast.Object, path string) (*ast.Object, error) {
-	pkg := imports[path]
-	if pkg != nil {
-		return pkg, nil
+// fileSet returns the file set for a new package.
+func (ctx *Context) fileSet() *token.FileSet {
+	if ctx.fset != nil {
+		return ctx.fset
 	}
+	return token.NewFileSet()
+}
 
-	n := guessNameFromPath(path)
-	if n == "" {
-		return nil, errors.New("package not found")
+// supports returns true if the protocol version requested by the plugin
+// includes the changes made in the given version.
+func (ctx *Context) supports(version int) bool {
+	return ctx.protocol == 0 || ctx.protocol >= version
+}
+
+// newBuildContext returns a copy of the default build context with the target
+// operating system, architecture and build tags set to the non-empty
+// arguments. The tags argument is a comma separated list.
+func newBuildContext(goos, goarch, tags string) *build.Context {
+	bctx := build.Default
+	if goos != "" {
+		bctx.GOOS = goos
+	}
+	if goarch != "" {
+		bctx.GOARCH = goarch
 	}
+	if tags != "" {
+		bctx.BuildTags = strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	if bctx.GOOS != build.Default.GOOS || bctx.GOARCH != build.Default.GOARCH {
+		// Cgo is not available when cross compiling.
+		bctx.CgoEnabled = false
+	}
+	return &bctx
+}
 
-	pkg = ast.NewObj(ast.Pkg, n)
-	pkg.Data = ast.NewScope(nil)
-	imports[path] = pkg
-	return pkg, nil
+// buildContext returns the build context for loading packages. Module import
+// paths are resolved relative to the current directory instead of the
+// process working directory.
+func (ctx *Context) buildContext() *build.Context {
+	bctx := build.Default
+	if ctx.bctx != nil {
+		bctx = *ctx.bctx
+	}
+	if filepath.IsAbs(ctx.cwd) {
+		bctx.Dir = ctx.cwd
+	}
+	return &bctx
+}
+
+// addOverlayFiles adds the overlay files in the directory of bpkg that do not
+// exist on disk to the files of bpkg. The build context is not given ReadDir
+// and OpenFile functions for the overlay because go/build does not resolve
+// module import paths with a custom file system.
+func (ctx *Context) addOverlayFiles(bpkg *build.Package, err error) error {
+	if _, ok := err.(*build.NoGoError); err != nil && !ok {
+		return err
+	}
+	var names []string
+	for fname := range ctx.overlay {
+		if filepath.Dir(fname) != bpkg.Dir || !strings.HasSuffix(fname, ".go") {
+			continue
+		}
+		if _, err := os.Stat(fname); !os.IsNotExist(err) {
+			continue
+		}
+		names = append(names, filepath.Base(fname))
+	}
+	sort.Strings(names)
+	bctx := ctx.buildContext()
+	bctx.OpenFile = func(fname string) (io.ReadCloser, error) {
+		if p, ok := ctx.overlay[fname]; ok {
+			return ioutil.NopCloser(bytes.NewReader(p)), nil
+		}
+		return os.Open(fname)
+	}
+	for _, name := range names {
+		if ok, _ := bctx.MatchFile(bpkg.Dir, name); !ok {
+			continue
+		}
+		file, perr := parser.ParseFile(token.NewFileSet(), name, ctx.overlay[filepath.Join(bpkg.Dir, name)], parser.PackageClauseOnly)
+		if perr != nil {
+			bpkg.InvalidGoFiles = append(bpkg.InvalidGoFiles, name)
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, "_test.go") && strings.HasSuffix(file.Name.Name, "_test"):
+			bpkg.XTestGoFiles = append(bpkg.XTestGoFiles, name)
+		case strings.HasSuffix(name, "_test.go"):
+			bpkg.TestGoFiles = append(bpkg.TestGoFiles, name)
+		default:
+			bpkg.GoFiles = append(bpkg.GoFiles, name)
+			if bpkg.Name == "" {
+				bpkg.Name = file.Name.Name
+			}
+		}
+		err = nil
+	}
+	return err
+}
+
+// isDefaultBuildContext returns true if the build context selects the same
+// files as the default build context.
+func isDefaultBuildContext(bctx *build.Context) bool {
+	return bctx.GOOS == build.Default.GOOS && bctx.GOARCH == build.Default.GOARCH &&
+		strings.Join(bctx.BuildTags, ",") == strings.Join(build.Default.BuildTags, ",")
+}
+
+var linePat = regexp.MustCompile(`(?m)^//line .*$`)
+
+// newSimpleImporter returns an ast.Importer that creates package objects
+// with the names found by namer.
+func newSimpleImporter(namer *packageNamer) ast.Importer {
+	return func(imports map[string]*ast.Object, path string) (*ast.Object, error) {
+		pkg := imports[path]
+		if pkg != nil {
+			return pkg, nil
+		}
+
+		n := namer.name(path)
+		if n == "" {
+			return nil, errors.New("package not found")
+		}
+
+		pkg = ast.NewObj(ast.Pkg, n)
+		pkg.Data = ast.NewScope(nil)
+		imports[path] = pkg
+		return pkg, nil
+	}
 }
 
 type Package struct {
@@ -51,12 +198,45 @@ type Package struct {
 	dpkg     *doc.Package
 	examples []*doc.Example
 	errors   []error
+
+	// Package files in the order of bpkg.GoFiles, bpkg.CgoFiles and, if
+	// loaded with loadTests, bpkg.TestGoFiles.
+	files []*ast.File
+
+	// Type information, set when loaded with loadTypes.
+	tpkg *types.Package
+	info *types.Info
+
+	// Documentation for the package's test files, set when loaded with
+	// loadTestDoc. There is an entry for the package's own test files and
+	// for the external test package.
+	tests []*doc.Package
+
+	// Names of the packages in the directory when the directory contains
+	// more than one package.
+	packages []string
+
+	overlay map[string][]byte
+	bctx    *build.Context
+
+	// importer is the importer shared with other packages, nil if the
+	// package has its own importer.
+	importer *sourceImporter
 }
 
 func (pkg *Package) parseFile(name string) (*ast.File, error) {
-	p, err := ioutil.ReadFile(filepath.Join(pkg.bpkg.Dir, name))
-	if err != nil {
-		return nil, err
+	fname := filepath.Join(pkg.bpkg.Dir, name)
+	p, ok := pkg.overlay[fname]
+	if ok {
+		// Copy the overlay because the //line comments are overwritten
+		// below.
+		p = append([]byte(nil), p...)
+	} else {
+		var err error
+		p, err = ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
 	}
 	// overwrite //line comments
 	for _, m := range linePat.FindAllIndex(p, -1) {
@@ -71,33 +251,156 @@ const (
 	loadDoc = 1 << iota
 	loadExamples
 	loadUnexported
+	loadTypes
+	loadTests   // include the package's _test.go files in files and types
+	loadTestDoc // set tests to the documentation of the _test.go files
 )
 
 func (ctx *Context) loadPackage(importPath string, flags int) (*Package, error) {
-	bpkg, err := build.Import(importPath, ctx.cwd, 0)
-	if _, ok := err.(*build.NoGoError); ok {
-		return &Package{bpkg: bpkg}, nil
+	bpkg, err := ctx.buildContext().Import(importPath, ctx.cwd, 0)
+	return ctx.loadBuildPackage(bpkg, err, flags)
+}
+
+// loadNamedPackage is like loadPackage, but if the directory of the package
+// contains more than one package, then the files of the package with the
+// given name are loaded. An empty name loads the directory as loadPackage
+// does. The names of the packages in the directory are recorded in
+// pkg.packages.
+func (ctx *Context) loadNamedPackage(importPath string, name string, flags int) (*Package, error) {
+	bpkg, err := ctx.buildContext().Import(importPath, ctx.cwd, 0)
+	var packages []string
+	if _, ok := err.(*build.MultiplePackageError); ok {
+		packages = dirPackageNames(bpkg)
+		if name != "" {
+			err = selectPackage(bpkg, name)
+		}
+	} else if err == nil && name != "" && name != bpkg.Name {
+		err = fmt.Errorf("no package %s in %s", name, bpkg.Dir)
+	}
+	pkg, err := ctx.loadBuildPackage(bpkg, err, flags)
+	if pkg != nil {
+		pkg.packages = packages
+	}
+	return pkg, err
+}
+
+// dirPackageNames returns the sorted names of the packages declared in the
+// non-test files of bpkg.
+func dirPackageNames(bpkg *build.Package) []string {
+	var names []string
+	fset := token.NewFileSet()
+	for _, name := range append(append([]string(nil), bpkg.GoFiles...), bpkg.CgoFiles...) {
+		file, err := parser.ParseFile(fset, filepath.Join(bpkg.Dir, name), nil, parser.PackageClauseOnly)
+		if err == nil && !contains(names, file.Name.Name) {
+			names = append(names, file.Name.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectPackage restricts bpkg, loaded from a directory containing more than
+// one package, to the files and imports of the package with the given name.
+func selectPackage(bpkg *build.Package, name string) error {
+	fset := token.NewFileSet()
+	filter := func(fnames []string, pkgName string, imports *[]string) []string {
+		var result []string
+		for _, fname := range fnames {
+			file, err := parser.ParseFile(fset, filepath.Join(bpkg.Dir, fname), nil, parser.ImportsOnly)
+			if err != nil || file.Name.Name != pkgName {
+				continue
+			}
+			result = append(result, fname)
+			for _, spec := range file.Imports {
+				if path, err := strconv.Unquote(spec.Path.Value); err == nil && !contains(*imports, path) {
+					*imports = append(*imports, path)
+				}
+			}
+		}
+		return result
+	}
+	var imports, testImports, xtestImports []string
+	bpkg.GoFiles = filter(bpkg.GoFiles, name, &imports)
+	bpkg.CgoFiles = filter(bpkg.CgoFiles, name, &imports)
+	if len(bpkg.GoFiles) == 0 && len(bpkg.CgoFiles) == 0 {
+		return fmt.Errorf("no package %s in %s", name, bpkg.Dir)
+	}
+	bpkg.TestGoFiles = filter(bpkg.TestGoFiles, name, &testImports)
+	bpkg.XTestGoFiles = filter(bpkg.XTestGoFiles, name+"_test", &xtestImports)
+	sort.Strings(imports)
+	sort.Strings(testImports)
+	sort.Strings(xtestImports)
+	bpkg.Name = name
+	bpkg.Imports, bpkg.TestImports, bpkg.XTestImports = imports, testImports, xtestImports
+
+	var invalid []string
+	for _, fname := range bpkg.InvalidGoFiles {
+		if !contains(bpkg.GoFiles, fname) && !contains(bpkg.CgoFiles, fname) &&
+			!contains(bpkg.TestGoFiles, fname) && !contains(bpkg.XTestGoFiles, fname) {
+			invalid = append(invalid, fname)
+		}
+	}
+	bpkg.InvalidGoFiles = invalid
+	return nil
+}
+
+// loadPackageDir is like loadPackage, but loads the package in directory dir.
+func (ctx *Context) loadPackageDir(dir string, flags int) (*Package, error) {
+	bpkg, err := ctx.buildContext().ImportDir(dir, 0)
+	return ctx.loadBuildPackage(bpkg, err, flags)
+}
+
+func (ctx *Context) loadBuildPackage(bpkg *build.Package, err error, flags int) (*Package, error) {
+	if len(ctx.overlay) > 0 && bpkg != nil && bpkg.Dir != "" {
+		err = ctx.addOverlayFiles(bpkg, err)
+	}
+	switch err := err.(type) {
+	case *build.NoGoError:
+		return &Package{bpkg: bpkg, bctx: ctx.buildContext()}, nil
+	case *build.MultiplePackageError:
+		return &Package{bpkg: bpkg, bctx: ctx.buildContext(), errors: []error{err}}, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	if bpkg.ImportPath == "." {
+		// The go/build package does not know the import path of local
+		// packages outside of GOPATH.
+		if p := moduleImportPath(bpkg.Dir); p != "" {
+			bpkg.ImportPath = p
+		}
+	}
 
 	pkg := &Package{
-		fset: token.NewFileSet(),
-		bpkg: bpkg,
+		fset:     ctx.fileSet(),
+		bpkg:     bpkg,
+		overlay:  ctx.overlay,
+		bctx:     ctx.buildContext(),
+		importer: ctx.importer,
 	}
 
+	names := append(pkg.bpkg.GoFiles, pkg.bpkg.CgoFiles...)
+	if flags&loadTests != 0 {
+		names = append(names, pkg.bpkg.TestGoFiles...)
+	}
 	files := make(map[string]*ast.File)
-	for _, name := range append(pkg.bpkg.GoFiles, pkg.bpkg.CgoFiles...) {
+	for _, name := range names {
 		file, err := pkg.parseFile(name)
 		if err != nil {
 			pkg.errors = append(pkg.errors, err)
 			continue
 		}
 		files[name] = file
+		pkg.files = append(pkg.files, file)
+	}
+
+	if flags&loadTypes != 0 {
+		pkg.check(bpkg.ImportPath)
 	}
 
-	pkg.apkg, _ = ast.NewPackage(pkg.fset, files, simpleImporter, nil)
+	namer := newPackageNamer(pkg.bctx, bpkg.Dir, ctx.cacheDir)
+	pkg.apkg, _ = ast.NewPackage(pkg.fset, files, newSimpleImporter(namer), nil)
+	namer.close()
 
 	if flags&loadDoc != 0 {
 		mode := doc.Mode(0)
@@ -114,16 +417,152 @@ func (ctx *Context) loadPackage(importPath string, flags int) (*Package, error)
 		}
 	}
 
-	if flags&loadExamples != 0 {
-		for _, name := range append(pkg.bpkg.TestGoFiles, pkg.bpkg.XTestGoFiles...) {
-			file, err := pkg.parseFile(name)
-			if err != nil {
-				pkg.errors = append(pkg.errors, err)
-				continue
-			}
+	if flags&(loadExamples|loadTestDoc) != 0 {
+		ctx.loadTestFiles(pkg, flags)
+	}
+
+	return pkg, nil
+}
+
+// loadTestFiles parses the test files of pkg for the examples and test
+// documentation selected by flags. The files are parsed after the package is
+// loaded so that callers can skip them when the examples and tests are not
+// used.
+func (ctx *Context) loadTestFiles(pkg *Package, flags int) {
+	// Test files by package name.
+	testFiles := make(map[string]map[string]*ast.File)
+	var testNames []string
+	for _, name := range append(pkg.bpkg.TestGoFiles, pkg.bpkg.XTestGoFiles...) {
+		file, err := pkg.parseFile(name)
+		if err != nil {
+			pkg.errors = append(pkg.errors, err)
+			continue
+		}
+		if flags&loadExamples != 0 {
 			pkg.examples = append(pkg.examples, doc.Examples(file)...)
 		}
+		if testFiles[file.Name.Name] == nil {
+			testFiles[file.Name.Name] = make(map[string]*ast.File)
+			testNames = append(testNames, file.Name.Name)
+		}
+		testFiles[file.Name.Name][name] = file
 	}
+	if flags&loadTestDoc != 0 {
+		sort.Strings(testNames)
+		namer := newPackageNamer(pkg.bctx, pkg.bpkg.Dir, ctx.cacheDir)
+		for _, name := range testNames {
+			apkg, _ := ast.NewPackage(pkg.fset, testFiles[name], newSimpleImporter(namer), nil)
+			pkg.tests = append(pkg.tests, doc.New(apkg, pkg.bpkg.ImportPath, 0))
+		}
+		namer.close()
+	}
+}
 
-	return pkg, nil
+// loadXTestPackage loads and type checks the external test package of bpkg.
+// Nil is returned if there are no external test files.
+func (ctx *Context) loadXTestPackage(bpkg *build.Package) *Package {
+	if len(bpkg.XTestGoFiles) == 0 {
+		return nil
+	}
+	pkg := &Package{
+		fset:     ctx.fileSet(),
+		bpkg:     bpkg,
+		overlay:  ctx.overlay,
+		bctx:     ctx.buildContext(),
+		importer: ctx.importer,
+	}
+	for _, name := range bpkg.XTestGoFiles {
+		file, err := pkg.parseFile(name)
+		if err != nil {
+			pkg.errors = append(pkg.errors, err)
+			continue
+		}
+		pkg.files = append(pkg.files, file)
+	}
+	pkg.check(bpkg.ImportPath + "_test")
+	return pkg
+}
+
+// loadFile loads and type checks the package containing the file fname with
+// the package's test files. The external test package is loaded if the file
+// is an external test file.
+func (ctx *Context) loadFile(fname string) (*Package, *ast.File, error) {
+	pkg, err := ctx.loadPackageDir(filepath.Dir(fname), loadTypes|loadTests)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range pkg.files {
+		if filepath.Join(pkg.bpkg.Dir, pkg.fset.Position(f.Pos()).Filename) == fname {
+			return pkg, f, nil
+		}
+	}
+	if xpkg := ctx.loadXTestPackage(pkg.bpkg); xpkg != nil {
+		for _, f := range xpkg.files {
+			if filepath.Join(xpkg.bpkg.Dir, xpkg.fset.Position(f.Pos()).Filename) == fname {
+				return xpkg, f, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%s is not a file in package %s", fname, pkg.bpkg.ImportPath)
 }
+
+// check type checks the package files. Errors are added to pkg.errors.
+func (pkg *Package) check(path string) {
+	pkg.info = &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
+	}
+	var imp types.Importer = newSourceImporter(pkg.fset, pkg.bctx, pkg.bpkg.Dir)
+	if pkg.importer != nil {
+		imp = dirImporter{imp: pkg.importer, dir: pkg.bpkg.Dir}
+	}
+	conf := types.Config{
+		Importer:    imp,
+		Sizes:       types.SizesFor("gc", pkg.bctx.GOARCH),
+		FakeImportC: true,
+		Error:       func(err error) { pkg.errors = append(pkg.errors, err) },
+	}
+	pkg.tpkg, _ = conf.Check(path, pkg.fset, pkg.files, pkg.info)
+}
+
+// moduleImportPath returns the import path of the package in directory dir
+// using the module path in the go.mod file found by walking up from dir. The
+// empty string is returned if there is no go.mod file.
+func moduleImportPath(dir string) string {
+	root, modulePath := moduleRoot(dir)
+	if root == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return ""
+	}
+	return path.Join(modulePath, filepath.ToSlash(rel))
+}
+
+// moduleRoot returns the directory containing the go.mod file found by
+// walking up from dir and the module path declared in the file.
+func moduleRoot(dir string) (root string, modulePath string) {
+	for {
+		p, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			m := modulePat.FindSubmatch(p)
+			if m == nil {
+				return "", ""
+			}
+			return dir, string(m[1])
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+var modulePat = regexp.MustCompile(`(?m)^module\s+"?([^"\s]+)"?\s*$`)
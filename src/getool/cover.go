@@ -0,0 +1,281 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	var fs flag.FlagSet
+	commands["cover"] = &Command{
+		fs: &fs,
+		do: doCover,
+	}
+}
+
+// doCover implements the command
+//
+//	cover [package]
+//
+// The command runs the tests of the package with a coverage profile and
+// prints the covered and uncovered ranges of each file in the package. The
+// package is an import path or a directory relative to the current directory
+// and defaults to the current directory.
+//
+// The output is a line "F file" for each file followed by the lines "C start
+// end" for covered ranges and "U start end" for uncovered ranges. Positions
+// are encoded as line*10000+column. The last line is "S summary" with the
+// total coverage. The profile is kept in the cache directory for the doc
+// command.
+func doCover(ctx *Context) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	importPath := "."
+	switch len(ctx.args) {
+	case 0:
+	case 1:
+		importPath = ctx.args[0]
+	default:
+		fmt.Fprint(w, "cover: at most one package argument allowed\n")
+		return 1
+	}
+
+	pkg, err := ctx.loadPackage(importPath, 0)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	profile := coverProfilePath(ctx.cacheDir, pkg.bctx, pkg.bpkg.ImportPath)
+	if profile == "" {
+		// Without a cache directory, the profile is only used for the
+		// output of this command.
+		dir, err := ioutil.TempDir("", "getool-cover")
+		if err != nil {
+			fmt.Fprintf(w, "ERR\n%s", err)
+			return 0
+		}
+		defer os.RemoveAll(dir)
+		profile = filepath.Join(dir, "cover.out")
+	}
+	if err := os.MkdirAll(filepath.Dir(profile), 0777); err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	os.Remove(profile)
+
+	args := []string{"test", "-coverprofile=" + profile}
+	if tags := pkg.bctx.BuildTags; len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+	cmd := goCommand(pkg.bctx, pkg.bpkg.Dir, append(args, ".")...)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+
+	blocks, err := readCoverProfile(profile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = errors.New(strings.TrimSpace(string(out)))
+		}
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+
+	var covered, total int
+	fname := ""
+	for _, b := range blocks {
+		if f := coverFile(pkg.bpkg, b.fname); f != fname {
+			fname = f
+			fmt.Fprintf(w, "F %s\n", fname)
+		}
+		kind := 'U'
+		if b.count > 0 {
+			kind = 'C'
+			covered += b.stmts
+		}
+		total += b.stmts
+		fmt.Fprintf(w, "%c %d %d\n", kind, b.startLine*10000+b.startCol, b.endLine*10000+b.endCol)
+	}
+	fmt.Fprintf(w, "S coverage: %s of statements\n", coverPercent(covered, total))
+	return 0
+}
+
+// coverProfilePath returns the path in cacheDir of the coverage profile for
+// the package with the given import path built with bctx. The empty string is
+// returned if cacheDir is empty.
+func coverProfilePath(cacheDir string, bctx *build.Context, importPath string) string {
+	if cacheDir == "" {
+		return ""
+	}
+	key := bctx.GOOS + "_" + bctx.GOARCH
+	if len(bctx.BuildTags) > 0 {
+		tags := append([]string(nil), bctx.BuildTags...)
+		sort.Strings(tags)
+		key += "_" + strings.Join(tags, ",")
+	}
+	return filepath.Join(cacheDir, "cover", key, filepath.FromSlash(importPath)+".out")
+}
+
+// coverFile returns the absolute path of a file name in a coverage profile.
+func coverFile(bpkg *build.Package, fname string) string {
+	return filepath.Join(bpkg.Dir, path.Base(fname))
+}
+
+func coverPercent(covered, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(covered)/float64(total))
+}
+
+// coverBlock is a block of statements in a coverage profile.
+type coverBlock struct {
+	fname               string
+	startLine, startCol int
+	endLine, endCol     int
+	stmts, count        int
+}
+
+// readCoverProfile reads the coverage profile in file fname. The blocks are
+// sorted by file and position. The counts of duplicate blocks are summed.
+func readCoverProfile(fname string) ([]*coverBlock, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCoverProfile(f)
+}
+
+func parseCoverProfile(r io.Reader) ([]*coverBlock, error) {
+	blocks := make(map[coverBlock]*coverBlock)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "mode: ") {
+			continue
+		}
+		// name.go:line.column,line.column numberOfStatements count
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("bad coverage profile line %q", line)
+		}
+		b := coverBlock{fname: line[:i]}
+		if _, err := fmt.Sscanf(line[i+1:], "%d.%d,%d.%d %d %d",
+			&b.startLine, &b.startCol, &b.endLine, &b.endCol, &b.stmts, &b.count); err != nil {
+			return nil, fmt.Errorf("bad coverage profile line %q", line)
+		}
+		key := b
+		key.count = 0
+		if p, ok := blocks[key]; ok {
+			p.count += b.count
+		} else {
+			blocks[key] = &b
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]*coverBlock, 0, len(blocks))
+	for _, b := range blocks {
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.fname != b.fname {
+			return a.fname < b.fname
+		}
+		if a.startLine != b.startLine {
+			return a.startLine < b.startLine
+		}
+		return a.startCol < b.startCol
+	})
+	return result, nil
+}
+
+// funcCoverage returns the percentage of covered statements for the
+// functions and methods in bpkg with a coverage profile in cacheDir for build
+// context bctx. The keys are the function name or the method name qualified
+// by the receiver type name. Nil is returned if there is no profile or if a
+// file in the package was modified after the profile was written.
+func funcCoverage(cacheDir string, bctx *build.Context, bpkg *build.Package) map[string]string {
+	profile := coverProfilePath(cacheDir, bctx, bpkg.ImportPath)
+	if profile == "" {
+		return nil
+	}
+	pfi, err := os.Stat(profile)
+	if err != nil {
+		return nil
+	}
+	for _, names := range [][]string{bpkg.GoFiles, bpkg.CgoFiles, bpkg.TestGoFiles, bpkg.XTestGoFiles} {
+		for _, name := range names {
+			if fi, err := os.Stat(filepath.Join(bpkg.Dir, name)); err != nil || fi.ModTime().After(pfi.ModTime()) {
+				return nil
+			}
+		}
+	}
+	blocks, err := readCoverProfile(profile)
+	if err != nil {
+		return nil
+	}
+	byFile := make(map[string][]*coverBlock)
+	for _, b := range blocks {
+		fname := coverFile(bpkg, b.fname)
+		byFile[fname] = append(byFile[fname], b)
+	}
+
+	result := make(map[string]string)
+	fset := token.NewFileSet()
+	for _, name := range append(bpkg.GoFiles, bpkg.CgoFiles...) {
+		fname := filepath.Join(bpkg.Dir, name)
+		file, err := parser.ParseFile(fset, fname, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			d, ok := decl.(*ast.FuncDecl)
+			if !ok || d.Body == nil {
+				continue
+			}
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) == 1 {
+				name = recvTypeName(d.Recv.List[0].Type) + "." + name
+			}
+			start, end := fset.Position(d.Pos()), fset.Position(d.End())
+			var covered, total int
+			for _, b := range byFile[fname] {
+				if b.startLine*10000+b.startCol < start.Line*10000+start.Column ||
+					b.endLine*10000+b.endCol > end.Line*10000+end.Column {
+					continue
+				}
+				total += b.stmts
+				if b.count > 0 {
+					covered += b.stmts
+				}
+			}
+			result[name] = coverPercent(covered, total)
+		}
+	}
+	return result
+}
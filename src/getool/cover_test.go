@@ -0,0 +1,124 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCoverProfile(t *testing.T) {
+	profile := `mode: count
+example.com/ws/a/b.go:3.10,5.2 2 0
+example.com/ws/a/a.go:7.20,9.3 1 2
+example.com/ws/a/a.go:3.24,3.38 1 0
+example.com/ws/a/a.go:3.24,3.38 1 3
+`
+	blocks, err := parseCoverProfile(strings.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []coverBlock
+	for _, b := range blocks {
+		got = append(got, *b)
+	}
+	want := []coverBlock{
+		{"example.com/ws/a/a.go", 3, 24, 3, 38, 1, 3},
+		{"example.com/ws/a/a.go", 7, 20, 9, 3, 1, 2},
+		{"example.com/ws/a/b.go", 3, 10, 5, 2, 2, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+var coverTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+func Abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func Sign(x int) int {
+	return x / Abs(x)
+}
+`,
+	"a/a_test.go": `package a
+
+import "testing"
+
+func TestAbs(t *testing.T) {
+	if Abs(2) != 2 {
+		t.Fatal("bad")
+	}
+}
+`,
+}
+
+func TestCover(t *testing.T) {
+	dir := writeTestFiles(t, coverTestFiles)
+	cacheDir := t.TempDir()
+
+	want := "F " + filepath.Join(dir, "a", "a.go") + `
+C 40002 40011
+U 50003 60001
+C 70002 70010
+U 110002 120001
+S coverage: 50.0% of statements
+`
+	var out bytes.Buffer
+	// Without a cache directory, the profile is not kept.
+	doCover(&Context{out: &out, cwd: filepath.Join(dir, "a"), args: []string{"."}})
+	if out.String() != want {
+		t.Errorf("cover without cache directory got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	doCover(&Context{out: &out, cwd: filepath.Join(dir, "a"), args: []string{"."}, cacheDir: cacheDir})
+	if out.String() != want {
+		t.Errorf("cover got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	doDoc(&Context{out: &out, cwd: dir, args: []string{"example.com/ws/a"}, cacheDir: cacheDir}, false, "")
+	for _, s := range []string{
+		`\nfunc Abs\(x int\) int  +66\.7% covered\n`,
+		`\nfunc Sign\(x int\) int  +0\.0% covered\n`,
+	} {
+		if !regexp.MustCompile(s).MatchString(out.String()) {
+			t.Errorf("doc output does not match %q:\n%s", s, out.String())
+		}
+	}
+
+	// The profile is not used for other build contexts or after a file in
+	// the package is modified.
+	out.Reset()
+	bctx := build.Default
+	bctx.BuildTags = []string{"x"}
+	doDoc(&Context{out: &out, cwd: dir, bctx: &bctx, args: []string{"example.com/ws/a"}, cacheDir: cacheDir}, false, "")
+	if strings.Contains(out.String(), "covered") {
+		t.Errorf("doc -tags=x output has coverage:\n%s", out.String())
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "a", "a.go"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	doDoc(&Context{out: &out, cwd: dir, args: []string{"example.com/ws/a"}, cacheDir: cacheDir}, false, "")
+	if strings.Contains(out.String(), "covered") {
+		t.Errorf("doc output has coverage after modification:\n%s", out.String())
+	}
+}
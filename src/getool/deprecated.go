@@ -0,0 +1,197 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// deprecatedMarker is appended to completions of deprecated identifiers.
+const deprecatedMarker = "(deprecated)"
+
+var deprecatedRx = regexp.MustCompile(`(?:^|\n\n)Deprecated: `)
+
+// deprecationNotice returns the text of the paragraph starting with
+// "Deprecated: " in the documentation text or "" if there is no such
+// paragraph.
+func deprecationNotice(text string) string {
+	m := deprecatedRx.FindStringIndex(text)
+	if m == nil {
+		return ""
+	}
+	text = text[m[1]:]
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		text = text[:i]
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// isDeprecated returns true if the documentation text has a deprecation
+// notice.
+func isDeprecated(text string) bool {
+	return deprecatedRx.MatchString(text)
+}
+
+// splitDeprecated splits items into the items that are not deprecated and
+// the items that are deprecated.
+func splitDeprecated[T any](items []T, docOf func(T) string) (current, deprecated []T) {
+	for _, item := range items {
+		if isDeprecated(docOf(item)) {
+			deprecated = append(deprecated, item)
+		} else {
+			current = append(current, item)
+		}
+	}
+	return current, deprecated
+}
+
+// deprecatedUses returns diagnostics for the uses of deprecated identifiers
+// and packages declared outside of pkg. If fname is not "", then only uses
+// in file fname are reported. The synopsis cache is kept in cacheDir.
+func deprecatedUses(pkg *Package, fname string, cacheDir string) []*diagnostic {
+	c := &deprecationChecker{
+		fset:  token.NewFileSet(),
+		files: make(map[string]*ast.File),
+	}
+	inFile := func(pos token.Pos) bool {
+		if fname == "" {
+			return true
+		}
+		name := pkg.fset.Position(pos).Filename
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(pkg.bpkg.Dir, name)
+		}
+		return name == fname
+	}
+
+	var diags []*diagnostic
+	cache := openSynopsisCache(cacheDir)
+	defer cache.save()
+	for _, file := range pkg.files {
+		if !inFile(file.Pos()) {
+			continue
+		}
+		for _, s := range file.Imports {
+			path, _ := strconv.Unquote(s.Path.Value)
+			bpkg, err := pkg.bctx.Import(path, pkg.bpkg.Dir, build.FindOnly)
+			if err != nil || bpkg.Dir == "" {
+				continue
+			}
+			if notice := cache.dir(pkg.bctx, bpkg.Dir).deprecated; notice != "" {
+				diags = append(diags, &diagnostic{pkg.fset.Position(s.Path.Pos()), "deprecated",
+					"package " + path + " is deprecated: " + notice})
+			}
+		}
+	}
+
+	for id, obj := range pkg.info.Uses {
+		if obj.Pkg() == nil || obj.Pkg() == pkg.tpkg || !inFile(id.Pos()) {
+			continue
+		}
+		switch o := obj.(type) {
+		case *types.Func:
+			obj = o.Origin()
+		case *types.Var:
+			obj = o.Origin()
+		case *types.PkgName:
+			continue
+		}
+		if notice := c.notice(pkg.fset.Position(obj.Pos())); notice != "" {
+			diags = append(diags, &diagnostic{pkg.fset.Position(id.Pos()), "deprecated",
+				obj.Pkg().Name() + "." + qualifiedName(obj) + " is deprecated: " + notice})
+		}
+	}
+	return diags
+}
+
+// qualifiedName returns the name of obj qualified by the receiver base type
+// name for methods.
+func qualifiedName(obj types.Object) string {
+	recv := func(t types.Type) string {
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		if n, ok := types.Unalias(t).(*types.Named); ok {
+			return n.Obj().Name() + "."
+		}
+		return ""
+	}
+	switch obj := obj.(type) {
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return recv(sig.Recv().Type()) + obj.Name()
+		}
+	}
+	return obj.Name()
+}
+
+// deprecationChecker finds the deprecation notices of declarations. Source
+// files are parsed with comments on demand.
+type deprecationChecker struct {
+	fset  *token.FileSet
+	files map[string]*ast.File
+}
+
+// notice returns the deprecation notice for the declaration at position pos.
+func (c *deprecationChecker) notice(pos token.Position) string {
+	if pos.Filename == "" {
+		return ""
+	}
+	file, ok := c.files[pos.Filename]
+	if !ok {
+		src, err := ioutil.ReadFile(pos.Filename)
+		if err == nil {
+			file, _ = parser.ParseFile(c.fset, pos.Filename, src, parser.ParseComments|parser.SkipObjectResolution)
+		}
+		c.files[pos.Filename] = file
+	}
+	if file == nil {
+		return ""
+	}
+	tf := c.fset.File(file.Pos())
+	if pos.Offset >= tf.Size() {
+		return ""
+	}
+	p := tf.Pos(pos.Offset)
+	path, _ := astutil.PathEnclosingInterval(file, p, p)
+	for i, n := range path {
+		var groups []*ast.CommentGroup
+		switch n := n.(type) {
+		case *ast.Field:
+			groups = []*ast.CommentGroup{n.Doc, n.Comment}
+		case *ast.ValueSpec:
+			groups = []*ast.CommentGroup{n.Doc, n.Comment}
+		case *ast.TypeSpec:
+			groups = []*ast.CommentGroup{n.Doc, n.Comment}
+		case *ast.FuncDecl:
+			groups = []*ast.CommentGroup{n.Doc}
+		default:
+			continue
+		}
+		if i+1 < len(path) {
+			if d, ok := path[i+1].(*ast.GenDecl); ok {
+				groups = append(groups, d.Doc)
+			}
+		}
+		for _, g := range groups {
+			if notice := deprecationNotice(g.Text()); notice != "" {
+				return notice
+			}
+		}
+		return ""
+	}
+	return ""
+}
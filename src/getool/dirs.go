@@ -0,0 +1,417 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dirSynopsis describes the contents of a directory.
+type dirSynopsis struct {
+	name       string // package name, "" if no Go files
+	synopsis   string // first sentence of the package documentation
+	deprecated string // deprecation notice in the package documentation
+	testdata   bool
+}
+
+// maxSynopsisEntries bounds the number of entries written to the synopsis
+// cache file. When the bound is exceeded, entries not used since the cache was
+// opened are evicted.
+const maxSynopsisEntries = 20000
+
+// synopsisCache caches the package clause and documentation synopsis of Go
+// files. Entries are validated using the file size and modification time.
+type synopsisCache struct {
+	fname   string
+	entries map[string]*synopsisEntry
+	used    map[string]bool
+	dirty   bool
+}
+
+type synopsisEntry struct {
+	Size       int64
+	ModTime    int64
+	Name       string
+	Synopsis   string
+	Deprecated string
+}
+
+// openSynopsisCache reads the cache from directory dir. An empty cache is
+// returned if the file cannot be read. The cache is not saved if dir is empty.
+func openSynopsisCache(dir string) *synopsisCache {
+	c := &synopsisCache{entries: make(map[string]*synopsisEntry), used: make(map[string]bool)}
+	if dir == "" {
+		return c
+	}
+	// The file name is changed when fields are added to synopsisEntry.
+	c.fname = filepath.Join(dir, "synopsis2.json")
+	if p, err := ioutil.ReadFile(c.fname); err == nil {
+		json.Unmarshal(p, &c.entries)
+	}
+	return c
+}
+
+// save writes the cache if the cache was modified.
+func (c *synopsisCache) save() {
+	if !c.dirty || c.fname == "" {
+		return
+	}
+	if len(c.entries) > maxSynopsisEntries {
+		for fname := range c.entries {
+			if !c.used[fname] {
+				delete(c.entries, fname)
+			}
+		}
+	}
+	p, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.fname), 0777); err != nil {
+		return
+	}
+	tmp := c.fname + ".tmp"
+	if err := ioutil.WriteFile(tmp, p, 0666); err != nil {
+		return
+	}
+	os.Rename(tmp, c.fname)
+}
+
+// file returns the package name and synopsis for the Go file fname. Only the
+// package clause and the comments before the clause are parsed.
+func (c *synopsisCache) file(fname string, fi os.FileInfo) *synopsisEntry {
+	c.used[fname] = true
+	if e := c.entries[fname]; e != nil && e.Size == fi.Size() && e.ModTime == fi.ModTime().UnixNano() {
+		return e
+	}
+	e := &synopsisEntry{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+	f, err := parser.ParseFile(token.NewFileSet(), fname, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err == nil {
+		e.Name = f.Name.Name
+		if f.Doc != nil {
+			e.Synopsis = doc.Synopsis(f.Doc.Text())
+			e.Deprecated = deprecationNotice(f.Doc.Text())
+		}
+	}
+	c.entries[fname] = e
+	c.dirty = true
+	return e
+}
+
+// dir returns the synopsis for directory dir. Files matching build context
+// bctx are preferred.
+func (c *synopsisCache) dir(bctx *build.Context, dir string) *dirSynopsis {
+	if filepath.Base(dir) == "testdata" {
+		return &dirSynopsis{testdata: true}
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return &dirSynopsis{}
+	}
+
+	var entries, matched []*synopsisEntry
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") ||
+			strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		e := c.file(filepath.Join(dir, name), fi)
+		if e.Name == "" {
+			continue
+		}
+		entries = append(entries, e)
+		if ok, _ := bctx.MatchFile(dir, name); ok {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) > 0 {
+		entries = matched
+	}
+
+	ds := &dirSynopsis{}
+	for _, e := range entries {
+		if ds.name == "" || ds.name == "documentation" {
+			ds.name = e.Name
+		}
+		if ds.synopsis == "" {
+			ds.synopsis = e.Synopsis
+		}
+		if ds.deprecated == "" {
+			ds.deprecated = e.Deprecated
+		}
+	}
+	return ds
+}
+
+// packageNamer finds the names of imported packages. The name is read from
+// the package clause of the package files when the package is found.
+// Otherwise, the name is guessed from the import path.
+type packageNamer struct {
+	bctx     *build.Context
+	srcDir   string
+	cacheDir string
+	cache    *synopsisCache
+	names    map[string]string
+	dirs     map[string]string
+
+	// requires maps the paths of the modules required by the module
+	// containing srcDir to the module directories. Nil until needed.
+	requires map[string]string
+}
+
+// newPackageNamer returns a namer for the imports of the package in srcDir.
+// The synopsis cache is kept in cacheDir.
+func newPackageNamer(bctx *build.Context, srcDir string, cacheDir string) *packageNamer {
+	return &packageNamer{
+		bctx:     bctx,
+		srcDir:   srcDir,
+		cacheDir: cacheDir,
+		names:    make(map[string]string),
+		dirs:     make(map[string]string),
+	}
+}
+
+// name returns the name of the package with the given import path.
+func (n *packageNamer) name(importPath string) string {
+	if name, ok := n.names[importPath]; ok {
+		return name
+	}
+	name := ""
+	if dir := n.dir(importPath); dir != "" {
+		if n.cache == nil {
+			n.cache = openSynopsisCache(n.cacheDir)
+		}
+		name = n.cache.dir(n.bctx, dir).name
+	}
+	if name == "" || name == "documentation" {
+		name = guessNameFromPath(importPath)
+	}
+	n.names[importPath] = name
+	return name
+}
+
+// dir returns the directory of the package with the given import path or ""
+// if the package is not found. The package is looked up in GOROOT, in the
+// module containing srcDir with its vendor directory and the requirements of
+// the module in the module cache, and in GOPATH. The go command is not run,
+// so a lookup never downloads modules.
+func (n *packageNamer) dir(importPath string) string {
+	if dir, ok := n.dirs[importPath]; ok {
+		return dir
+	}
+	dir := n.findDir(importPath)
+	n.dirs[importPath] = dir
+	return dir
+}
+
+func (n *packageNamer) findDir(importPath string) string {
+	isDir := func(dir string) bool {
+		fi, err := os.Stat(dir)
+		return err == nil && fi.IsDir()
+	}
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		if dir := filepath.Join(n.bctx.GOROOT, "src", filepath.FromSlash(importPath)); isDir(dir) {
+			return dir
+		}
+	}
+	if n.srcDir == "" {
+		return ""
+	}
+	root, modulePath := moduleRoot(n.srcDir)
+	if root == "" {
+		for _, gopath := range filepath.SplitList(n.bctx.GOPATH) {
+			if dir := filepath.Join(gopath, "src", filepath.FromSlash(importPath)); isDir(dir) {
+				return dir
+			}
+		}
+		return ""
+	}
+	if rel, ok := trimPathPrefix(importPath, modulePath); ok {
+		if dir := filepath.Join(root, filepath.FromSlash(rel)); isDir(dir) {
+			return dir
+		}
+		return ""
+	}
+	if dir := filepath.Join(root, "vendor", filepath.FromSlash(importPath)); isDir(dir) {
+		return dir
+	}
+	if n.requires == nil {
+		n.requires = moduleRequirements(root, moduleCacheDir(n.bctx))
+	}
+	// Prefer the longest module path when modules are nested.
+	best, bestLen := "", -1
+	for path, modDir := range n.requires {
+		if rel, ok := trimPathPrefix(importPath, path); ok && modDir != "" && len(path) > bestLen {
+			if dir := filepath.Join(modDir, filepath.FromSlash(rel)); isDir(dir) {
+				best, bestLen = dir, len(path)
+			}
+		}
+	}
+	return best
+}
+
+// trimPathPrefix returns the path below prefix if path is prefix or a path
+// below prefix.
+func trimPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return path[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// moduleCacheDir returns the module cache directory set by GOMODCACHE or the
+// default in the first GOPATH directory.
+func moduleCacheDir(bctx *build.Context) string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	if gopath := filepath.SplitList(bctx.GOPATH); len(gopath) > 0 {
+		return filepath.Join(gopath[0], "pkg", "mod")
+	}
+	return ""
+}
+
+// moduleRequirements returns the directories of the modules required by the
+// go.mod file in root. The keys are the module paths. Required modules are
+// found in the module cache directory cache and replacements with a file path
+// are relative to root.
+func moduleRequirements(root string, cache string) map[string]string {
+	result := make(map[string]string)
+	p, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return result
+	}
+	moduleDir := func(path, version string) string {
+		if cache == "" {
+			return ""
+		}
+		return filepath.Join(cache, filepath.FromSlash(escapeModulePath(path)+"@"+escapeModulePath(version)))
+	}
+
+	var replaces [][]string
+	block := ""
+	for _, line := range strings.Split(string(p), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		verb := block
+		switch {
+		case len(f) == 0:
+			continue
+		case block != "" && f[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(f) == 2 && f[1] == "(":
+			block = f[0]
+			continue
+		case block == "":
+			verb, f = f[0], f[1:]
+		}
+		switch verb {
+		case "require":
+			if len(f) >= 2 {
+				path := strings.Trim(f[0], `"`)
+				result[path] = moduleDir(path, f[1])
+			}
+		case "replace":
+			replaces = append(replaces, f)
+		}
+	}
+	for _, f := range replaces {
+		// path [version] => newpath [version]
+		i := 0
+		for i < len(f) && f[i] != "=>" {
+			i++
+		}
+		if i == 0 || i+1 >= len(f) {
+			continue
+		}
+		path, target := strings.Trim(f[0], `"`), f[i+1:]
+		switch {
+		case strings.HasPrefix(target[0], "./") || strings.HasPrefix(target[0], "../") || filepath.IsAbs(target[0]):
+			dir := target[0]
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(root, filepath.FromSlash(dir))
+			}
+			result[path] = dir
+		case len(target) == 2:
+			result[path] = moduleDir(target[0], target[1])
+		}
+	}
+	return result
+}
+
+// escapeModulePath escapes the upper case letters in a module path or
+// version as the module cache does.
+func escapeModulePath(s string) string {
+	var buf strings.Builder
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			buf.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// close saves the names read from package files.
+func (n *packageNamer) close() {
+	if n.cache != nil {
+		n.cache.save()
+	}
+}
+
+// subdirs returns the subdirectories of the directories in dirs. The result
+// maps the subdirectory name to the first directory containing the
+// subdirectory.
+func subdirs(dirs []string) (map[string]string, []string) {
+	m := make(map[string]string)
+	for _, dir := range dirs {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			if _, ok := m[fi.Name()]; !ok {
+				m[fi.Name()] = filepath.Join(dir, fi.Name())
+			}
+		}
+	}
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return m, names
+}
+
+// isInternalPath returns true if the import path contains an internal
+// element.
+func isInternalPath(importPath string) bool {
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem == "internal" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var dirSynopsisTestFiles = map[string]string{
+	"pkg/doc.go":         "// Package foo does things. More text.\npackage foo\n",
+	"pkg/foo.go":         "package foo\n",
+	"pkg/gen.go":         "//go:build ignore\n\n// Gen generates code.\npackage main\n",
+	"pkg/foo_test.go":    "// Tests.\npackage foo_test\n",
+	"cmd/main.go":        "// Cmd is a command.\npackage main\n",
+	"nodoc/a.go":         "package nodoc\n",
+	"empty/README":       "",
+	"pkg/testdata/x.go":  "package x\n",
+	"broken/broken.go":   "packag x\n",
+	"broken/ok.go":       "// Package ok.\npackage ok\n",
+	"documentation/x.go": "// Package documentation.\npackage documentation\n",
+	"documentation/y.go": "package y\n",
+	"old/old.go":         "// Package old is old.\n//\n// Deprecated: Use new\n// instead.\npackage old\n",
+}
+
+var dirSynopsisTests = []struct {
+	dir string
+	ds  dirSynopsis
+}{
+	{"pkg", dirSynopsis{name: "foo", synopsis: "Package foo does things."}},
+	{"cmd", dirSynopsis{name: "main", synopsis: "Cmd is a command."}},
+	{"nodoc", dirSynopsis{name: "nodoc"}},
+	{"empty", dirSynopsis{}},
+	{"pkg/testdata", dirSynopsis{testdata: true}},
+	{"broken", dirSynopsis{name: "ok", synopsis: "Package ok."}},
+	{"documentation", dirSynopsis{name: "y", synopsis: "Package documentation."}},
+	{"old", dirSynopsis{name: "old", synopsis: "Package old is old.", deprecated: "Use new instead."}},
+}
+
+func TestDirSynopsis(t *testing.T) {
+	dir := writeTestFiles(t, dirSynopsisTestFiles)
+	cacheDir := t.TempDir()
+
+	// The second pass reads the cache saved by the first pass.
+	for i := 0; i < 2; i++ {
+		c := openSynopsisCache(cacheDir)
+		for _, tt := range dirSynopsisTests {
+			ds := c.dir(&build.Default, filepath.Join(dir, filepath.FromSlash(tt.dir)))
+			if *ds != tt.ds {
+				t.Errorf("%d: dir(%q) = %+v, want %+v", i, tt.dir, *ds, tt.ds)
+			}
+		}
+		if c.dirty != (i == 0) {
+			t.Errorf("%d: dirty = %v, want %v", i, c.dirty, i == 0)
+		}
+		c.save()
+	}
+}
+
+func TestSynopsisCacheEviction(t *testing.T) {
+	dir := writeTestFiles(t, dirSynopsisTestFiles)
+	cacheDir := t.TempDir()
+
+	c := openSynopsisCache(cacheDir)
+	for i := 0; i < maxSynopsisEntries; i++ {
+		c.entries[fmt.Sprintf("/old/%d.go", i)] = &synopsisEntry{}
+	}
+	c.dir(&build.Default, filepath.Join(dir, "pkg"))
+	c.save()
+
+	c = openSynopsisCache(cacheDir)
+	if n := len(c.entries); n != 3 {
+		t.Errorf("len(entries) = %d, want 3", n)
+	}
+}
+
+func TestSynopsisCacheNoDir(t *testing.T) {
+	dir := writeTestFiles(t, dirSynopsisTestFiles)
+
+	c := openSynopsisCache("")
+	c.dir(&build.Default, filepath.Join(dir, "pkg"))
+	c.save()
+	if c.fname != "" {
+		t.Errorf("fname = %q, want no cache file", c.fname)
+	}
+}
+
+var packageNamerTestFiles = map[string]string{
+	"go.mod":                       "module example.com/ws\n\nrequire example.com/loc v1.0.0\n\nreplace example.com/loc => ./third_party/loc\n",
+	"go-foo/foo.go":                "package bar\n",
+	"v2/x/x.go":                    "package y\n",
+	"a/a.go":                       "package a\n",
+	"vendor/example.com/vend/v.go": "package vended\n",
+	"third_party/loc/sub/s.go":     "package replaced\n",
+}
+
+var packageNamerTests = []struct {
+	path, name string
+}{
+	{"example.com/ws/go-foo", "bar"},
+	{"example.com/ws/v2/x", "y"},
+	{"net/http", "http"},
+	{"github.com/user/repo/v2", "repo"},
+	{"github.com/user/go-repo/v3", "repo"},
+	{"gopkg.in/yaml.v3", "yaml"},
+	{"gopkg.in/user/pkg.v1", "pkg"},
+	{"github.com/user/repo-extra", "repo"},
+	{"github.com/x/repo/v10", "repo"},
+	{"example.com/vend", "vended"},
+	{"example.com/loc/sub", "replaced"},
+}
+
+var moduleRequirementsTestFiles = map[string]string{
+	"go.mod": `module example.com/ws
+
+require example.com/a v1.0.0 // indirect
+
+require (
+	example.com/Upper v1.2.0
+	example.com/old v0.1.0
+	example.com/local v0.1.0
+)
+
+replace example.com/old v0.1.0 => example.com/new v0.2.0
+
+replace (
+	example.com/local => ../local
+)
+`,
+}
+
+func TestModuleRequirements(t *testing.T) {
+	dir := writeTestFiles(t, moduleRequirementsTestFiles)
+
+	got := moduleRequirements(dir, "/cache")
+	want := map[string]string{
+		"example.com/a":     filepath.FromSlash("/cache/example.com/a@v1.0.0"),
+		"example.com/Upper": filepath.FromSlash("/cache/example.com/!upper@v1.2.0"),
+		"example.com/old":   filepath.FromSlash("/cache/example.com/new@v0.2.0"),
+		"example.com/local": filepath.Join(filepath.Dir(dir), "local"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("moduleRequirements() = %v, want %v", got, want)
+	}
+}
+
+func TestPackageNamer(t *testing.T) {
+	dir := writeTestFiles(t, packageNamerTestFiles)
+
+	namer := newPackageNamer(&build.Default, filepath.Join(dir, "a"), "")
+	defer namer.close()
+	for _, tt := range packageNamerTests {
+		if name := namer.name(tt.path); name != tt.name {
+			t.Errorf("name(%q) = %q, want %q", tt.path, name, tt.name)
+		}
+	}
+}
@@ -15,7 +15,7 @@ import (
 	"go/scanner"
 	"go/token"
 	"io"
-	"io/ioutil"
+	"net/url"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -32,13 +32,14 @@ const textWidth = 80 - len(textIndent)
 func init() {
 	var fs flag.FlagSet
 	all := fs.Bool("all", false, "show unexported identifiers")
+	maxGo := fs.String("maxgo", "", "hide standard library identifiers added after Go `version`")
 	commands["doc"] = &Command{
 		fs: &fs,
-		do: func(ctx *Context) int { return doDoc(ctx, *all) },
+		do: func(ctx *Context) int { return doDoc(ctx, *all, *maxGo) },
 	}
 }
 
-func doDoc(ctx *Context, all bool) int {
+func doDoc(ctx *Context, all bool, maxGo string) int {
 	if len(ctx.args) != 1 {
 		fmt.Fprint(ctx.out, "one command line argument expected")
 		return 1
@@ -47,46 +48,144 @@ func doDoc(ctx *Context, all bool) int {
 	importPath := filepath.ToSlash(ctx.args[0])
 	importPath = strings.TrimPrefix(importPath, "godoc://")
 
+	// The fragment selects a package in a directory containing more than
+	// one package.
+	pkgName := ""
+	if i := strings.IndexByte(importPath, '#'); i >= 0 {
+		importPath, pkgName = importPath[:i], importPath[i+1:]
+	}
+
+	query := ""
+	if i := strings.IndexByte(importPath, '?'); i >= 0 {
+		importPath, query = importPath[:i], importPath[i:]
+		bctx, err := parseBuildQuery(ctx.bctx, query[1:])
+		if err != nil {
+			fmt.Fprintf(ctx.out, "E\n%s", err)
+			return 0
+		}
+		ctx.bctx = bctx
+	}
+
 	p := docPrinter{
 		importPath: importPath,
+		query:      query,
+		bctx:       ctx.buildContext(),
+		cacheDir:   ctx.cacheDir,
+		maxGo:      -1,
 		lineNum:    1,
 		lineOffset: -1,
 		index:      make(map[string]int),
 	}
+	if maxGo != "" {
+		minor, err := parseGoVersion(maxGo)
+		if err != nil {
+			fmt.Fprintf(ctx.out, "E\n%s", err)
+			return 0
+		}
+		p.maxGo = minor
+	}
 
 	if importPath != "" {
-		flags := loadDoc | loadExamples
+		flags := loadDoc
 		if all {
 			flags |= loadUnexported
 		}
-		pkg, err := ctx.loadPackage(importPath, flags)
+		pkg, err := ctx.loadNamedPackage(importPath, pkgName, flags)
 		if err != nil {
 			fmt.Fprintf(ctx.out, "E\n%s", err)
 			return 0
 		}
+		if pkg.dpkg != nil && (pkg.dpkg.Name != "main" || all) {
+			// The page shows the declarations with their examples,
+			// versions and coverage, and the TESTS section.
+			ctx.loadTestFiles(pkg, loadExamples|loadTestDoc)
+			if pkg.bpkg.Goroot {
+				p.api = loadAPIVersions(p.bctx, pkg.bpkg.ImportPath, p.cacheDir)
+			}
+			p.coverage = funcCoverage(p.cacheDir, pkg.bctx, pkg.bpkg)
+		}
 		p.bpkg = pkg.bpkg
 		p.dpkg = pkg.dpkg
 		p.fset = pkg.fset
 		p.examples = pkg.examples
+		p.errors = pkg.errors
+		p.tests = pkg.tests
+		p.packages = pkg.packages
+		if pkg.dpkg != nil && pkg.dpkg.Name == "main" {
+			p.cmd = readCommandDoc(pkg)
+		}
 	}
 
 	p.execute(ctx.out, all)
 	return 0
 }
 
+// parseBuildQuery returns the build context specified by the goos, goarch and
+// tags parameters in the godoc:// URL query. Parameters not in the query are
+// taken from bctx.
+func parseBuildQuery(bctx *build.Context, query string) (*build.Context, error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	for k := range q {
+		if k != "goos" && k != "goarch" && k != "tags" {
+			return nil, fmt.Errorf("unknown query parameter %q", k)
+		}
+	}
+	if bctx == nil {
+		bctx = &build.Default
+	}
+	goos, goarch, tags := bctx.GOOS, bctx.GOARCH, strings.Join(bctx.BuildTags, ",")
+	if v, ok := q["goos"]; ok {
+		goos = v[0]
+	}
+	if v, ok := q["goarch"]; ok {
+		goarch = v[0]
+	}
+	if v, ok := q["tags"]; ok {
+		tags = v[0]
+	}
+	return newBuildContext(goos, goarch, tags), nil
+}
+
 type docPrinter struct {
 	importPath string
-	fset       *token.FileSet
-	bpkg       *build.Package
-	dpkg       *doc.Package
+	query      string // godoc:// URL query including the leading '?'
+	bctx       *build.Context
+	cacheDir   string
+
+	// Versions of standard library identifiers and the maximum Go minor
+	// version to show or -1 to show all versions.
+	api   *apiVersions
+	maxGo int
+	note  string // pending version or coverage note for the current output line
+
+	// Coverage of functions and methods from the profile written by the
+	// cover command.
+	coverage map[string]string
+
+	fset *token.FileSet
+	bpkg *build.Package
+	dpkg *doc.Package
 
 	examples []*doc.Example
+	errors   []error
+
+	// Documentation for the test files and the names of the packages in a
+	// directory with more than one package.
+	tests    []*doc.Package
+	packages []string
+
+	// Flags and subcommands of a command.
+	cmd *commandDoc
 
 	// Output buffers
 	buf     bytes.Buffer
 	metaBuf bytes.Buffer
 
-	index map[string]int
+	index   map[string]int
+	anchors map[string]bool
 
 	// Fields used by outputPosition
 	lineNum    int
@@ -97,6 +196,14 @@ type docPrinter struct {
 func (p *docPrinter) execute(out io.Writer, all bool) {
 	printDecls := false
 
+	if !isDefaultBuildContext(p.bctx) {
+		p.buf.WriteString("Build context: " + p.bctx.GOOS + "/" + p.bctx.GOARCH)
+		if len(p.bctx.BuildTags) > 0 {
+			p.buf.WriteString(", tags " + strings.Join(p.bctx.BuildTags, ","))
+		}
+		p.buf.WriteString("\n\n")
+	}
+
 	switch {
 	case p.importPath == "":
 		// root
@@ -104,57 +211,93 @@ func (p *docPrinter) execute(out io.Writer, all bool) {
 		p.buf.WriteString("Directory ")
 		p.printLink(path.Base(p.importPath), p.bpkg.Dir, p.stringAddress(""))
 		p.buf.WriteString("\n\n")
+		p.printPackages()
+		p.printErrors()
 	case p.dpkg.Name == "main":
 		p.buf.WriteString("Command ")
 		p.printLink(path.Base(p.importPath), p.bpkg.Dir, p.stringAddress(""))
+		p.printDeprecatedTag(p.dpkg.Doc)
 		p.buf.WriteString("\n\n")
 		p.printText(p.dpkg.Doc)
+		p.printCommandDoc()
+		p.printPackages()
+		p.printErrors()
 		printDecls = all
 	default:
 		p.buf.WriteString("package ")
 		p.printLink(p.dpkg.Name, p.bpkg.Dir, p.stringAddress(""))
+		p.printDeprecatedTag(p.dpkg.Doc)
+		if p.api != nil {
+			p.note = p.versionNote(p.api.pkg)
+			p.printNote()
+		}
 		p.buf.WriteString("\n\n" + textIndent + "import \"")
 		p.buf.WriteString(p.dpkg.ImportPath)
 		p.buf.WriteString("\"\n\n")
 		p.printText(p.dpkg.Doc)
 		p.printExamples("")
+		p.printPackages()
+		p.printErrors()
 		printDecls = true
 	}
 
+	if printDecls && p.maxGo >= 0 {
+		p.hideNewer()
+	}
+
 	if printDecls {
 		p.buf.WriteString("FILES\n")
 		p.printFiles(p.bpkg.GoFiles, p.bpkg.CgoFiles)
 		p.printFiles(p.bpkg.TestGoFiles, p.bpkg.XTestGoFiles)
 		p.buf.WriteString("\n")
 
+		// Deprecated declarations are printed in a separate group at the
+		// end of each section.
+		valueDoc := func(d *doc.Value) string { return d.Doc }
+		funcDoc := func(d *doc.Func) string { return d.Doc }
+		typeDoc := func(d *doc.Type) string { return d.Doc }
+
 		if len(p.dpkg.Consts) > 0 {
 			p.buf.WriteString("CONSTANTS\n\n")
-			p.printValues(p.dpkg.Consts)
+			consts, deprecated := splitDeprecated(p.dpkg.Consts, valueDoc)
+			p.printValues(consts)
+			if len(deprecated) > 0 {
+				p.buf.WriteString("Deprecated constants\n\n")
+				p.printValues(deprecated)
+			}
 		}
 
 		if len(p.dpkg.Vars) > 0 {
 			p.buf.WriteString("VARIABLES\n\n")
-			p.printValues(p.dpkg.Vars)
+			vars, deprecated := splitDeprecated(p.dpkg.Vars, valueDoc)
+			p.printValues(vars)
+			if len(deprecated) > 0 {
+				p.buf.WriteString("Deprecated variables\n\n")
+				p.printValues(deprecated)
+			}
 		}
 
 		if len(p.dpkg.Funcs) > 0 {
 			p.buf.WriteString("FUNCTIONS\n\n")
-			p.printFuncs(p.dpkg.Funcs, "")
+			funcs, deprecated := splitDeprecated(p.dpkg.Funcs, funcDoc)
+			p.printFuncs(funcs, "")
+			if len(deprecated) > 0 {
+				p.buf.WriteString("Deprecated functions\n\n")
+				p.printFuncs(deprecated, "")
+			}
 		}
 
 		if len(p.dpkg.Types) > 0 {
 			p.buf.WriteString("TYPES\n\n")
-			for _, d := range p.dpkg.Types {
-				p.printDecl(d.Decl)
-				p.printText(d.Doc)
-				p.printExamples(d.Name)
-				p.printValues(d.Consts)
-				p.printValues(d.Vars)
-				p.printFuncs(d.Funcs, "")
-				p.printFuncs(d.Methods, d.Name+"_")
+			types, deprecated := splitDeprecated(p.dpkg.Types, typeDoc)
+			p.printTypes(types)
+			if len(deprecated) > 0 {
+				p.buf.WriteString("Deprecated types\n\n")
+				p.printTypes(deprecated)
 			}
 		}
 
+		p.printTests()
 		p.printImports()
 	}
 
@@ -165,14 +308,14 @@ func (p *docPrinter) execute(out io.Writer, all bool) {
 		if up == "." {
 			up = ""
 		}
-		p.printLink("..", "godoc://"+up, p.stringAddress(""))
+		p.printLink("..", p.docURL(up), p.stringAddress(""))
 		p.buf.WriteString(" (up a directory)\n")
-		p.printDirs(append(filepath.SplitList(build.Default.GOPATH), build.Default.GOROOT))
+		p.printDirs(append(filepath.SplitList(p.bctx.GOPATH), p.bctx.GOROOT))
 	} else {
 		p.buf.WriteString("\n\nStandard Packages\n\n")
-		p.printDirs([]string{build.Default.GOROOT})
+		p.printDirs([]string{p.bctx.GOROOT})
 		p.buf.WriteString("\n\nThird Party Packages\n\n")
-		p.printDirs(filepath.SplitList(build.Default.GOPATH))
+		p.printDirs(filepath.SplitList(p.bctx.GOPATH))
 	}
 
 	p.metaBuf.WriteString("D\n")
@@ -195,7 +338,15 @@ type annotation struct {
 	pos  token.Pos
 }
 
-func (p *docPrinter) printDecl(decl ast.Decl) {
+// printDeprecatedTag prints a tag if the documentation text has a deprecation
+// notice.
+func (p *docPrinter) printDeprecatedTag(text string) {
+	if isDeprecated(text) {
+		p.buf.WriteString(" [deprecated]")
+	}
+}
+
+func (p *docPrinter) printDecl(decl ast.Decl, text string) {
 	v := &declVisitor{}
 	ast.Walk(v, decl)
 	var w bytes.Buffer
@@ -228,7 +379,7 @@ loop:
 				break loop
 			}
 			offset := int(pos) - base
-			p.buf.Write(buf[lastOffset:offset])
+			p.writeDecl(buf[lastOffset:offset])
 			lastOffset = offset + len(lit)
 			a := v.annotations[0]
 			v.annotations = v.annotations[1:]
@@ -242,14 +393,21 @@ loop:
 			case endLinkAnnotation:
 				file := ""
 				if a.data != "" {
-					file = "godoc://" + a.data
+					file = p.docURL(a.data)
 				}
 				p.buf.WriteString(lit)
 				p.addLink(startPos, file, p.stringAddress(lit))
 			case packageLinkAnnoation:
-				p.printLink(lit, "godoc://"+a.data, p.stringAddress(""))
+				p.printLink(lit, p.docURL(a.data), p.stringAddress(""))
 			case anchorAnnotation:
 				p.addAnchor(lit, a.data)
+				if p.note == "" {
+					name := lit
+					if a.data != "" {
+						name = a.data + "." + lit
+					}
+					p.note = p.declNote(name)
+				}
 				position := p.fset.Position(a.pos)
 				p.printLink(lit,
 					filepath.Join(p.bpkg.Dir, position.Filename),
@@ -259,10 +417,108 @@ loop:
 			}
 		}
 	}
-	p.buf.Write(buf[lastOffset:])
+	p.writeDecl(buf[lastOffset:])
+	p.printDeprecatedTag(text)
+	p.printNote()
 	p.buf.WriteString("\n\n")
 }
 
+// writeDecl writes declaration text to the output. A pending note is
+// printed at the end of the current line.
+func (p *docPrinter) writeDecl(b []byte) {
+	if p.note != "" {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			p.buf.Write(b[:i])
+			p.printNote()
+			b = b[i:]
+		}
+	}
+	p.buf.Write(b)
+}
+
+// versionNote returns the note for an identifier added in Go minor version
+// minor.
+func (p *docPrinter) versionNote(minor int) string {
+	switch {
+	case minor <= 0:
+		return ""
+	case p.maxGo >= 0 && minor > p.maxGo:
+		return fmt.Sprintf("requires Go 1.%d", minor)
+	default:
+		return fmt.Sprintf("since Go 1.%d", minor)
+	}
+}
+
+// declNote returns the version and coverage note for the declaration with
+// the given name.
+func (p *docPrinter) declNote(name string) string {
+	var notes []string
+	if p.api != nil {
+		if minor, ok := p.api.ids[name]; ok {
+			if note := p.versionNote(minor); note != "" {
+				notes = append(notes, note)
+			}
+		}
+	}
+	if percent, ok := p.coverage[name]; ok {
+		notes = append(notes, percent+" covered")
+	}
+	return strings.Join(notes, ", ")
+}
+
+// printNote prints the pending note right-aligned on the current
+// line.
+func (p *docPrinter) printNote() {
+	if p.note == "" {
+		return
+	}
+	b := p.buf.Bytes()
+	width := 0
+	for _, c := range string(b[bytes.LastIndexByte(b, '\n')+1:]) {
+		if c == '\t' {
+			width += 4 - width%4
+		} else {
+			width++
+		}
+	}
+	n := len(textIndent) + textWidth - width - len(p.note)
+	if n < 2 {
+		n = 2
+	}
+	p.buf.WriteString(strings.Repeat(" ", n))
+	p.buf.WriteString(p.note)
+	p.note = ""
+}
+
+// hideNewer removes the declarations added after the maximum Go version.
+// Values are removed if all names in the declaration are newer. Fields and
+// interface methods are marked instead of removed.
+func (p *docPrinter) hideNewer() {
+	if p.api == nil {
+		return
+	}
+	keep := func(names ...string) bool {
+		for _, name := range names {
+			if minor, ok := p.api.ids[name]; !ok || minor <= p.maxGo {
+				return true
+			}
+		}
+		return false
+	}
+	keepValue := func(d *doc.Value) bool { return keep(d.Names...) }
+	keepFunc := func(d *doc.Func) bool { return keep(d.Name) }
+	p.dpkg.Consts = filter(p.dpkg.Consts, keepValue)
+	p.dpkg.Vars = filter(p.dpkg.Vars, keepValue)
+	p.dpkg.Funcs = filter(p.dpkg.Funcs, keepFunc)
+	p.dpkg.Types = filter(p.dpkg.Types, func(d *doc.Type) bool { return keep(d.Name) })
+	for _, d := range p.dpkg.Types {
+		d.Consts = filter(d.Consts, keepValue)
+		d.Vars = filter(d.Vars, keepValue)
+		d.Funcs = filter(d.Funcs, keepFunc)
+		d.Methods = filter(d.Methods, func(m *doc.Func) bool { return keep(d.Name + "." + m.Name) })
+	}
+}
+
 func (p *docPrinter) printText(s string) {
 	s = strings.TrimRight(s, " \t\n")
 	if s != "" {
@@ -372,19 +628,146 @@ func (p *docPrinter) printFiles(sets ...[]string) {
 
 func (p *docPrinter) printValues(values []*doc.Value) {
 	for _, d := range values {
-		p.printDecl(d.Decl)
+		p.printDecl(d.Decl, d.Doc)
+		p.printText(d.Doc)
+	}
+}
+
+func (p *docPrinter) printTypes(types []*doc.Type) {
+	for _, d := range types {
+		p.printDecl(d.Decl, d.Doc)
 		p.printText(d.Doc)
+		p.printExamples(d.Name)
+		p.printValues(d.Consts)
+		p.printValues(d.Vars)
+		p.printFuncs(d.Funcs, "")
+		p.printFuncs(d.Methods, d.Name+"_")
 	}
 }
 
 func (p *docPrinter) printFuncs(funcs []*doc.Func, examplePrefix string) {
 	for _, d := range funcs {
-		p.printDecl(d.Decl)
+		p.printDecl(d.Decl, d.Doc)
 		p.printText(d.Doc)
 		p.printExamples(examplePrefix + d.Name)
 	}
 }
 
+// printPackages prints links to the pages for the packages in a directory
+// containing more than one package.
+func (p *docPrinter) printPackages() {
+	if len(p.packages) == 0 {
+		return
+	}
+	p.buf.WriteString("PACKAGES\n\n")
+	for _, name := range p.packages {
+		p.buf.WriteString(textIndent)
+		p.printLink(name, p.docURL(p.importPath)+"#"+name, p.stringAddress(""))
+		if p.dpkg != nil && p.dpkg.Name == name {
+			p.buf.WriteString(" (shown)")
+		}
+		p.buf.WriteByte('\n')
+	}
+	p.buf.WriteByte('\n')
+}
+
+// printTests prints the exported declarations and the test functions in the
+// test files of the package. Test functions are printed after the other
+// declarations of each test package.
+func (p *docPrinter) printTests() {
+	var tests []*doc.Package
+	for _, t := range p.tests {
+		if len(t.Consts)+len(t.Vars)+len(t.Funcs)+len(t.Types) > 0 {
+			tests = append(tests, t)
+		}
+	}
+	if len(tests) == 0 {
+		return
+	}
+	p.buf.WriteString("TESTS\n\n")
+	for _, t := range tests {
+		p.buf.WriteString("package " + t.Name + "\n\n")
+		var funcs, testFuncs []*doc.Func
+		for _, d := range t.Funcs {
+			if testFuncPrefix(d.Name) != "" {
+				testFuncs = append(testFuncs, d)
+			} else {
+				funcs = append(funcs, d)
+			}
+		}
+		p.printValues(t.Consts)
+		p.printValues(t.Vars)
+		p.printFuncs(funcs, "")
+		p.printTypes(t.Types)
+		p.printFuncs(testFuncs, "")
+	}
+}
+
+// printErrors prints the errors found when loading the package. Build
+// errors are explained.
+func (p *docPrinter) printErrors() {
+	var ignored []string
+	if p.dpkg == nil && len(p.errors) == 0 {
+		// Explain why a directory with Go files does not have a package.
+		ignored = append(p.bpkg.IgnoredGoFiles, p.bpkg.InvalidGoFiles...)
+	}
+	if len(p.errors) == 0 && len(ignored) == 0 {
+		return
+	}
+	p.buf.WriteString("ERRORS\n\n")
+
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		p.printText(fmt.Sprintf("No Go files match the build context %s/%s. The following files are excluded by build constraints or have errors.",
+			p.bctx.GOOS, p.bctx.GOARCH))
+		for _, name := range ignored {
+			p.buf.WriteString(textIndent + textIndent)
+			p.printLink(name, filepath.Join(p.bpkg.Dir, name), p.stringAddress(""))
+			if c := buildConstraint(filepath.Join(p.bpkg.Dir, name)); c != "" {
+				p.buf.WriteString("  " + c)
+			}
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteByte('\n')
+	}
+
+	for _, err := range p.errors {
+		switch err := err.(type) {
+		case scanner.ErrorList:
+			for _, e := range err {
+				p.printError(e.Pos, e.Msg)
+			}
+		case *build.MultiplePackageError:
+			p.printText("The directory contains files from more than one package. Use a build constraint such as //go:build ignore to exclude files that are not part of the package.")
+			for i, name := range err.Files {
+				p.buf.WriteString(textIndent + textIndent)
+				p.printLink(name, filepath.Join(err.Dir, name), p.stringAddress(""))
+				p.buf.WriteString("  package " + err.Packages[i] + "\n")
+			}
+			p.buf.WriteByte('\n')
+		default:
+			p.printError(token.Position{}, err.Error())
+		}
+	}
+	p.buf.WriteByte('\n')
+}
+
+// printError prints an error with a link to the error position.
+func (p *docPrinter) printError(pos token.Position, msg string) {
+	p.buf.WriteString(textIndent)
+	if pos.IsValid() {
+		fname := pos.Filename
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(p.bpkg.Dir, fname)
+		}
+		p.printLink(fmt.Sprintf("%s:%d:%d", filepath.Base(fname), pos.Line, pos.Column),
+			fname, -p.lineColumnAddress(pos.Line, pos.Column))
+		p.buf.WriteString(": ")
+	}
+	p.buf.WriteString(msg)
+	p.buf.WriteByte('\n')
+}
+
 func (p *docPrinter) printImports() {
 	if len(p.bpkg.Imports) == 0 {
 		return
@@ -394,43 +777,71 @@ func (p *docPrinter) printImports() {
 		p.buf.WriteString(textIndent)
 		startPos := p.outputPosition()
 		p.buf.WriteString(imp)
-		p.addLink(startPos, "godoc://"+imp, p.stringAddress(""))
+		p.addLink(startPos, p.docURL(imp), p.stringAddress(""))
 		p.buf.WriteByte('\n')
 	}
 	p.buf.WriteString("\n")
 }
 
 func (p *docPrinter) printDirs(roots []string) {
-	m := map[string]bool{}
+	var dirs []string
 	for _, root := range roots {
-		dir := filepath.Join(root, "src", filepath.FromSlash(p.importPath))
-		fis, err := ioutil.ReadDir(dir)
-		if err != nil {
-			continue
-		}
-		for _, fi := range fis {
-			if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
-				continue
-			}
-			m[fi.Name()] = true
-		}
+		dirs = append(dirs, filepath.Join(root, "src", filepath.FromSlash(p.importPath)))
+	}
+	if p.bpkg != nil && p.bpkg.Dir != "" {
+		// Package found in a module.
+		dirs = append(dirs, p.bpkg.Dir)
 	}
+	m, names := subdirs(dirs)
 
-	var names []string
-	for name := range m {
-		names = append(names, name)
+	width := 0
+	for _, name := range names {
+		if len(name) > width && len(name) < 24 {
+			width = len(name)
+		}
 	}
-	sort.Strings(names)
+
+	cache := openSynopsisCache(p.cacheDir)
+	defer cache.save()
 
 	for _, name := range names {
 		p.buf.WriteString(textIndent)
 		startPos := p.outputPosition()
 		p.buf.WriteString(name)
-		p.addLink(startPos, "godoc://"+path.Join(p.importPath, name), p.stringAddress(""))
+		importPath := path.Join(p.importPath, name)
+		p.addLink(startPos, p.docURL(importPath), p.stringAddress(""))
+		p.buf.WriteString(strings.Repeat(" ", width+2-min(width, len(name))))
+
+		ds := cache.dir(p.bctx, m[name])
+		switch {
+		case ds.testdata:
+			p.buf.WriteString("(testdata)")
+		case ds.name == "":
+			p.buf.WriteString("(no Go files)")
+		case ds.name == "main":
+			p.buf.WriteString("command")
+		default:
+			p.buf.WriteString("package " + ds.name)
+		}
+		if isInternalPath(importPath) {
+			p.buf.WriteString(" (internal)")
+		}
+		if ds.deprecated != "" {
+			p.buf.WriteString(" (deprecated)")
+		}
+		if ds.synopsis != "" {
+			p.buf.WriteString(" - " + ds.synopsis)
+		}
 		p.buf.WriteByte('\n')
 	}
 }
 
+// docURL returns the godoc:// URL for the package with the given import path
+// in the current build context.
+func (p *docPrinter) docURL(importPath string) string {
+	return "godoc://" + importPath + p.query
+}
+
 func (p *docPrinter) printLink(s string, file string, address int64) {
 	startPos := p.outputPosition()
 	p.buf.WriteString(s)
@@ -441,10 +852,20 @@ func (p *docPrinter) addLink(startPos int64, file string, address int64) {
 	fmt.Fprintf(&p.metaBuf, "L %d %d %d %d\n", startPos, p.outputPosition(), p.stringAddress(file), address)
 }
 
+// addAnchor adds an anchor at the current output position. The first anchor
+// with a name is kept so that declarations in the TESTS section do not
+// replace the declarations of the package.
 func (p *docPrinter) addAnchor(name, typeName string) {
 	if typeName != "" {
 		name = typeName + "." + name
 	}
+	if p.anchors[name] {
+		return
+	}
+	if p.anchors == nil {
+		p.anchors = make(map[string]bool)
+	}
+	p.anchors[name] = true
 	fmt.Fprintf(&p.metaBuf, "A %d %s\n", p.outputPosition(), name)
 }
 
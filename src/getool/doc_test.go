@@ -0,0 +1,254 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"strings"
+	"testing"
+)
+
+var buildQueryTests = []struct {
+	query  string
+	goos   string
+	goarch string
+	tags   string
+	err    bool
+}{
+	{"goos=windows", "windows", build.Default.GOARCH, "", false},
+	{"goos=plan9&goarch=386", "plan9", "386", "", false},
+	{"tags=foo,bar", build.Default.GOOS, build.Default.GOARCH, "foo,bar", false},
+	{"tags=foo+bar", build.Default.GOOS, build.Default.GOARCH, "foo,bar", false},
+	{"os=windows", "", "", "", true},
+}
+
+func TestBuildQuery(t *testing.T) {
+	for _, tt := range buildQueryTests {
+		bctx, err := parseBuildQuery(nil, tt.query)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseBuildQuery(%q) did not return error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBuildQuery(%q) returned error %v", tt.query, err)
+			continue
+		}
+		tags := strings.Join(bctx.BuildTags, ",")
+		if bctx.GOOS != tt.goos || bctx.GOARCH != tt.goarch || tags != tt.tags {
+			t.Errorf("parseBuildQuery(%q) = %s/%s %q, want %s/%s %q",
+				tt.query, bctx.GOOS, bctx.GOARCH, tags, tt.goos, tt.goarch, tt.tags)
+		}
+	}
+}
+
+var docBuildContextTestFiles = map[string]string{
+	"go.mod":           "module example.com/ws\n",
+	"a/a.go":           "package a\n",
+	"a/a_windows.go":   "package a\n\nfunc Windows() {}\n",
+	"a/a_plan9.go":     "package a\n\nfunc Plan9() {}\n",
+	"a/a_tag.go":       "//go:build foo\n\npackage a\n\nfunc Foo() {}\n",
+	"a/sub/windows.go": "// Package sub is for Windows.\npackage sub\n",
+}
+
+var docBuildContextTests = []struct {
+	path string
+	want []string
+	omit []string
+}{
+	{"godoc://example.com/ws/a?goos=windows", []string{"Build context: windows/", "func Windows()", "godoc://example.com/ws/a/sub?goos=windows"}, []string{"func Plan9()", "func Foo()"}},
+	{"godoc://example.com/ws/a?goos=plan9&tags=foo", []string{"Build context: plan9/", ", tags foo", "func Plan9()", "func Foo()"}, []string{"func Windows()"}},
+	{"godoc://example.com/ws/a?arch=arm", []string{"E\nunknown query parameter \"arch\""}, nil},
+}
+
+func TestDocBuildContext(t *testing.T) {
+	dir := writeTestFiles(t, docBuildContextTestFiles)
+
+	for _, tt := range docBuildContextTests {
+		var buf bytes.Buffer
+		doDoc(&Context{
+			out:  &buf,
+			cwd:  dir,
+			args: []string{tt.path},
+		}, false, "")
+		out := buf.String()
+		for _, s := range tt.want {
+			if !strings.Contains(out, s) {
+				t.Errorf("doc %s does not contain %q\n%s", tt.path, s, out)
+			}
+		}
+		for _, s := range tt.omit {
+			if strings.Contains(out, s) {
+				t.Errorf("doc %s contains %q", tt.path, s)
+			}
+		}
+	}
+}
+
+const docDeprecatedTestFile = `// Package b does things.
+//
+// Deprecated: Use c.
+package b
+
+// Deprecated: Use New.
+func Old() {}
+
+func New() {}
+
+type T struct {
+	// Deprecated: Use B.
+	A int
+	B int
+}
+`
+
+func TestDocDeprecated(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"go.mod": "module example.com/ws\n",
+		"b/b.go": docDeprecatedTestFile,
+	})
+
+	var buf bytes.Buffer
+	doDoc(&Context{
+		out:  &buf,
+		cwd:  dir,
+		args: []string{"example.com/ws/b"},
+	}, false, "")
+	out := buf.String()
+	out = out[strings.Index(out, "\nD\n")+3:]
+	want := "package b [deprecated]\n\n" +
+		"    import \"example.com/ws/b\"\n\n" +
+		"    Package b does things.\n\n" +
+		"    Deprecated: Use c.\n\n" +
+		"FILES\n\n    b.go\n\n" +
+		"FUNCTIONS\n\n" +
+		"func New()\n\n" +
+		"Deprecated functions\n\n" +
+		"func Old() [deprecated]\n\n" +
+		"    Deprecated: Use New.\n\n" +
+		"TYPES\n\n" +
+		"type T struct {\n\t// Deprecated: Use B.\n\tA\tint\n\tB\tint\n}\n\n"
+	if !strings.HasPrefix(out, want) {
+		t.Errorf("doc =\n%s\nwant prefix\n%s", out, want)
+	}
+}
+
+var docErrorsTestFiles = map[string]string{
+	"go.mod":       "module example.com/ws\n",
+	"a/a.go":       "package a\n\nfunc F() {}\n",
+	"a/b.go":       "package a\n\nfunc G( {}\n",
+	"m/a.go":       "package a\n",
+	"m/b.go":       "package b\n",
+	"w/w.go":       "//go:build windows\n\npackage w\n",
+	"w/w_plan9.go": "package w\n",
+}
+
+var docErrorsTests = []struct {
+	path string
+	want string
+}{
+	{
+		"example.com/ws/a",
+		"ERRORS\n\n    b.go:3:9: expected ')', found '{'\n",
+	},
+	{
+		"example.com/ws/m",
+		"ERRORS\n\n    The directory contains files from more than one package. Use a build\n    constraint such as //go:build ignore to exclude files that are not part of\n    the package.\n\n" +
+			"        a.go  package a\n        b.go  package b\n",
+	},
+	{
+		"example.com/ws/w?goos=linux&goarch=amd64",
+		"ERRORS\n\n    No Go files match the build context linux/amd64. The following files are\n    excluded by build constraints or have errors.\n\n" +
+			"        w.go  //go:build windows\n        w_plan9.go\n",
+	},
+}
+
+func TestDocErrors(t *testing.T) {
+	dir := writeTestFiles(t, docErrorsTestFiles)
+
+	for _, tt := range docErrorsTests {
+		var buf bytes.Buffer
+		doDoc(&Context{
+			out:  &buf,
+			cwd:  dir,
+			args: []string{tt.path},
+		}, false, "")
+		out := buf.String()
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("doc %s does not contain\n%s\ngot\n%s", tt.path, tt.want, out)
+		}
+	}
+}
+
+var docPackagesTestFiles = map[string]string{
+	"go.mod":       "module example.com/ws\n",
+	"m/a.go":       "package a\n\nimport \"fmt\"\n\nfunc A() { fmt.Println() }\n",
+	"m/a_test.go":  "package a\n\nfunc TestA() {}\n",
+	"m/b.go":       "package b\n\nimport \"strings\"\n\nfunc B() { strings.ToLower(\"\") }\n",
+	"t/t.go":       "package t\n\nfunc F() {}\n",
+	"t/t_test.go":  "package t\n\nimport \"testing\"\n\nfunc TestF(t *testing.T) {}\n\nfunc helper() {}\n",
+	"t/x_test.go":  "package t_test\n\nimport \"testing\"\n\n// Fixture is a test fixture.\ntype Fixture struct{}\n\nfunc F() {}\n\nfunc BenchmarkF(b *testing.B) {}\n\nfunc ExampleF() {}\n",
+	"t/sub/sub.go": "package sub\n",
+}
+
+var docPackagesTests = []struct {
+	path string
+	want []string
+	omit []string
+}{
+	{
+		"godoc://example.com/ws/m",
+		[]string{"Directory m\n\nPACKAGES\n\n    a\n    b\n\nERRORS\n"},
+		[]string{"func A()"},
+	},
+	{
+		"godoc://example.com/ws/m#a",
+		[]string{"package a\n", "PACKAGES\n\n    a (shown)\n    b\n\n", "func A()", "IMPORTS\n\n    fmt\n", "TESTS\n\npackage a\n\nfunc TestA()\n\n"},
+		[]string{"func B()", "ERRORS", "strings"},
+	},
+	{
+		"godoc://example.com/ws/m#b",
+		[]string{"package b\n", "    b (shown)\n", "func B()", "S godoc://example.com/ws/m#a\n"},
+		[]string{"func A()", "TESTS"},
+	},
+	{
+		"godoc://example.com/ws/m#c",
+		[]string{"E\nno package c in "},
+		nil,
+	},
+	{
+		"godoc://example.com/ws/t",
+		[]string{
+			"TESTS\n\npackage t\n\nfunc TestF(t *testing.T)\n\npackage t_test\n\nfunc F()\n\ntype Fixture struct{}\n\n    Fixture is a test fixture.\n\nfunc BenchmarkF(b *testing.B)\n\nfunc ExampleF()\n\nDIRECTORIES\n",
+		},
+		[]string{"helper", "PACKAGES"},
+	},
+}
+
+func TestDocPackages(t *testing.T) {
+	dir := writeTestFiles(t, docPackagesTestFiles)
+
+	for _, tt := range docPackagesTests {
+		var buf bytes.Buffer
+		doDoc(&Context{
+			out:  &buf,
+			cwd:  dir,
+			args: []string{tt.path},
+		}, false, "")
+		out := buf.String()
+		for _, s := range tt.want {
+			if !strings.Contains(out, s) {
+				t.Errorf("doc %s does not contain %q\n%s", tt.path, s, out)
+			}
+		}
+		for _, s := range tt.omit {
+			if strings.Contains(out, s) {
+				t.Errorf("doc %s contains %q\n%s", tt.path, s, out)
+			}
+		}
+	}
+}
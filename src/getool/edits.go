@@ -0,0 +1,97 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The edit set protocol describes changes to several files. For each file,
+// a FILE line is followed by REPL hunks. A hunk replaces lines start through
+// end with the n lines following the REPL line. Hunks are ordered from the end
+// of the file to the start of the file so that hunks can be applied in order.
+//
+//  FILE path
+//  REPL start end n
+//  line 1
+//  ...
+//  line n
+
+// textEdit replaces the bytes at offset through end - 1 with text.
+type textEdit struct {
+	offset int
+	end    int
+	text   string
+}
+
+// applyEdits returns src with edits applied. Duplicate edits are ignored.
+func applyEdits(src []byte, edits []textEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].offset < edits[j].offset })
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.offset < last {
+			continue
+		}
+		buf.Write(src[last:e.offset])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes()
+}
+
+type hunk struct {
+	start int
+	end   int
+	lines [][]byte
+}
+
+// hunks returns the changes from in to out as a list of hunks in reverse
+// order. If in and out have the same number of lines, then each run of
+// changed lines is a hunk. Otherwise, a single hunk covers the changes.
+func hunks(in, out []byte) []*hunk {
+	if bytes.Equal(in, out) {
+		return nil
+	}
+	linesIn := bytes.Split(in, []byte{'\n'})
+	linesOut := bytes.Split(out, []byte{'\n'})
+	if len(linesIn) != len(linesOut) {
+		start, end, lines := replacement(in, out)
+		return []*hunk{{start, end, lines}}
+	}
+	var result []*hunk
+	for i := len(linesIn) - 1; i >= 0; i-- {
+		if bytes.Equal(linesIn[i], linesOut[i]) {
+			continue
+		}
+		j := i
+		for j > 0 && !bytes.Equal(linesIn[j-1], linesOut[j-1]) {
+			j--
+		}
+		result = append(result, &hunk{j + 1, i + 1, linesOut[j : i+1]})
+		i = j
+	}
+	return result
+}
+
+// writeEditSet writes the changes from in to out for file fname using the
+// edit set protocol.
+func writeEditSet(w io.Writer, fname string, in, out []byte) {
+	hs := hunks(in, out)
+	if len(hs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "FILE %s\n", fname)
+	for _, h := range hs {
+		fmt.Fprintf(w, "REPL %d %d %d\n", h.start, h.end, len(h.lines))
+		for _, l := range h.lines {
+			fmt.Fprintf(w, "%s\n", l)
+		}
+	}
+}
@@ -0,0 +1,636 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+type extractOptions struct {
+	function bool
+	variable bool
+	lines    string
+	offset   string
+	name     string
+}
+
+func init() {
+	var fs flag.FlagSet
+	var opts extractOptions
+	fs.BoolVar(&opts.function, "func", false, "extract statements to a function")
+	fs.BoolVar(&opts.variable, "var", false, "extract an expression to a local variable")
+	fs.StringVar(&opts.lines, "lines", "", "`start,end` lines of the statements to extract")
+	fs.StringVar(&opts.offset, "offset", "", "`start,end` byte offsets of the expression to extract")
+	fs.StringVar(&opts.name, "name", "", "`name` of the function or variable")
+	commands["extract"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doExtract(ctx, &opts) },
+	}
+}
+
+// doExtract extracts statements to a function or an expression to a local
+// variable in the file named by the command argument. The contents of the
+// file are read from stdin. The result is written using the fmt command
+// protocol.
+func doExtract(ctx *Context, opts *extractOptions) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 1 {
+		fmt.Fprint(w, "extract: one argument required\n")
+		return 1
+	}
+	if opts.function == opts.variable {
+		fmt.Fprint(w, "extract: one of -func or -var required\n")
+		return 1
+	}
+
+	fname := ctx.args[0]
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(ctx.cwd, fname)
+	}
+	in, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	ctx.overlay = map[string][]byte{fname: in}
+
+	edits, err := extract(ctx, fname, in, opts)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	writeReplacement(w, in, applyEdits(in, edits))
+	return 0
+}
+
+func extract(ctx *Context, fname string, src []byte, opts *extractOptions) ([]textEdit, error) {
+	pkg, file, err := ctx.loadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err := packageError(pkg); err != nil {
+		return nil, err
+	}
+	x := &extractor{
+		pkg:  pkg,
+		file: file,
+		src:  src,
+		qf:   fileQualifier(file, pkg.tpkg),
+	}
+	var start, end int
+	if opts.function {
+		if _, err := fmt.Sscanf(opts.lines, "%d,%d", &start, &end); err != nil {
+			return nil, fmt.Errorf("bad -lines value %q", opts.lines)
+		}
+		name := opts.name
+		if name == "" {
+			name = "extracted"
+		}
+		return x.function(start, end, name)
+	}
+	if _, err := fmt.Sscanf(opts.offset, "%d,%d", &start, &end); err != nil {
+		return nil, fmt.Errorf("bad -offset value %q", opts.offset)
+	}
+	name := opts.name
+	if name == "" {
+		name = "x"
+	}
+	return x.variable(start, end, name)
+}
+
+type extractor struct {
+	pkg  *Package
+	file *ast.File
+	src  []byte
+	qf   types.Qualifier
+}
+
+func (x *extractor) offset(pos token.Pos) int {
+	return x.pkg.fset.Position(pos).Offset
+}
+
+// lineStart returns the offset of the start of the line containing pos.
+func (x *extractor) lineStart(pos token.Pos) int {
+	return bytes.LastIndexByte(x.src[:x.offset(pos)], '\n') + 1
+}
+
+// lineEnd returns the offset of the end of the line containing pos.
+func (x *extractor) lineEnd(pos token.Pos) int {
+	i := x.offset(pos)
+	if j := bytes.IndexByte(x.src[i:], '\n'); j >= 0 {
+		return i + j
+	}
+	return len(x.src)
+}
+
+// indent returns the white space at the start of the line containing pos.
+func (x *extractor) indent(pos token.Pos) string {
+	line := x.src[x.lineStart(pos):x.offset(pos)]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+func (x *extractor) text(n ast.Node) string {
+	return string(x.src[x.offset(n.Pos()):x.offset(n.End())])
+}
+
+// uniqueName returns name or name with a number appended so that the result
+// does not conflict with the names visible in the given scopes.
+func uniqueName(name string, taken map[string]bool, scopes ...*types.Scope) string {
+	for i := 0; ; i++ {
+		n := name
+		if i > 0 {
+			n = name + strconv.Itoa(i)
+		}
+		ok := !taken[n]
+		for _, s := range scopes {
+			if s != nil {
+				if _, obj := s.LookupParent(n, token.NoPos); obj != nil {
+					ok = false
+				}
+			}
+		}
+		if ok {
+			return n
+		}
+	}
+}
+
+// function extracts the statements on lines start through end to a function
+// declared after the enclosing function.
+func (x *extractor) function(start, end int, name string) ([]textEdit, error) {
+	fset, info := x.pkg.fset, x.pkg.info
+
+	stmts, _, _ := findStmtList(fset, x.file, start, end)
+	if len(stmts) == 0 {
+		return nil, errors.New("no statements selected")
+	}
+	first, last := stmts[0], stmts[len(stmts)-1]
+	if fset.Position(first.Pos()).Line < start || fset.Position(last.End()).Line > end {
+		return nil, errors.New("selection must contain whole statements")
+	}
+	for _, s := range stmts {
+		switch s.(type) {
+		case *ast.CaseClause, *ast.CommClause:
+			return nil, errors.New("selection must not contain case clauses")
+		}
+	}
+	pos, endPos := first.Pos(), last.End()
+	inside := func(p token.Pos) bool { return pos <= p && p < endPos }
+
+	path, _ := astutil.PathEnclosingInterval(x.file, pos, endPos)
+	var (
+		decl *ast.FuncDecl
+		sig  *types.Signature // innermost function
+	)
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if sig == nil {
+				sig, _ = info.TypeOf(n).(*types.Signature)
+			}
+		case *ast.FuncDecl:
+			decl = n
+			if sig == nil {
+				sig, _ = info.Defs[n.Name].Type().(*types.Signature)
+			}
+		}
+	}
+	if decl == nil || sig == nil {
+		return nil, errors.New("selection is not in a function")
+	}
+	if s := info.Defs[decl.Name].Type().(*types.Signature); s.TypeParams().Len() > 0 || s.RecvTypeParams().Len() > 0 {
+		return nil, errors.New("cannot extract from a generic function")
+	}
+
+	// Check for statements that cannot be moved and collect the return
+	// statements.
+	var returns []*ast.ReturnStmt
+	var err error
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.DeferStmt:
+				err = errors.New("cannot extract defer statement")
+			case *ast.ReturnStmt:
+				if len(n.Results) == 0 && sig.Results().Len() > 0 {
+					err = errors.New("cannot extract return statement without results")
+				}
+				returns = append(returns, n)
+			case *ast.BranchStmt:
+				if !x.branchInside(n, pos, endPos) {
+					err = fmt.Errorf("cannot extract %s to statement outside of selection", n.Tok)
+				}
+			}
+			return true
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the free variables used in the selection, the variables declared
+	// in the selection and the free variables assigned in the selection.
+	scope := x.pkg.tpkg.Scope()
+	isLocal := func(v *types.Var) bool {
+		return !v.IsField() && v.Parent() != nil && v.Parent() != scope && v.Parent() != types.Universe
+	}
+	var params, defined []*types.Var
+	seen := make(map[*types.Var]bool)
+	assigned := make(map[*types.Var]bool)
+	mark := func(e ast.Expr) {
+		if id, ok := astutil.Unparen(e).(*ast.Ident); ok {
+			if v, ok := info.Uses[id].(*types.Var); ok && isLocal(v) && !inside(v.Pos()) {
+				assigned[v] = true
+			}
+		}
+	}
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.Ident:
+				if v, ok := info.Uses[n].(*types.Var); ok && isLocal(v) && !inside(v.Pos()) && !seen[v] {
+					seen[v] = true
+					params = append(params, v)
+				}
+				if v, ok := info.Defs[n].(*types.Var); ok && isLocal(v) {
+					defined = append(defined, v)
+				}
+			case *ast.AssignStmt:
+				for _, e := range n.Lhs {
+					mark(e)
+				}
+			case *ast.IncDecStmt:
+				mark(n.X)
+			case *ast.RangeStmt:
+				if n.Tok == token.ASSIGN {
+					mark(n.Key)
+					mark(n.Value)
+				}
+			case *ast.UnaryExpr:
+				if n.Op == token.AND {
+					mark(n.X)
+				}
+			}
+			return true
+		})
+	}
+
+	// Variables declared or assigned in the selection and used outside of
+	// the selection are results of the function. Assigned named results of
+	// the enclosing function are used by a return without results.
+	usedOutside := make(map[*types.Var]bool)
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && !inside(id.Pos()) {
+			if v, ok := info.Uses[id].(*types.Var); ok {
+				usedOutside[v] = true
+			}
+		}
+		return true
+	})
+	for i := 0; i < sig.Results().Len(); i++ {
+		usedOutside[sig.Results().At(i)] = true
+	}
+	var results []*types.Var
+	for _, v := range defined {
+		if usedOutside[v] {
+			results = append(results, v)
+		}
+	}
+	for v := range assigned {
+		if usedOutside[v] {
+			results = append(results, v)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Pos() < results[j].Pos() })
+
+	_, tailReturn := last.(*ast.ReturnStmt)
+	if tailReturn {
+		// Code following the selection is not reachable.
+		results = nil
+	}
+
+	// Choose names.
+	taken := make(map[string]bool)
+	for _, v := range params {
+		taken[v.Name()] = true
+	}
+	for _, v := range defined {
+		taken[v.Name()] = true
+	}
+	fileScope := info.Scopes[x.file]
+	name = uniqueName(name, nil, fileScope)
+	local := scope.Innermost(pos)
+	var shouldReturn string
+	var retNames []string
+	if len(returns) > 0 && !tailReturn {
+		for _, r := range returns {
+			if len(r.Results) == 1 && sig.Results().Len() > 1 {
+				return nil, errors.New("cannot extract return of multiple value call")
+			}
+		}
+		shouldReturn = uniqueName("shouldReturn", taken, local)
+		taken[shouldReturn] = true
+		for i := 0; i < sig.Results().Len(); i++ {
+			n := uniqueName("ret"+strconv.Itoa(i), taken, local)
+			taken[n] = true
+			retNames = append(retNames, n)
+		}
+	}
+
+	// Signature of the new function.
+	var paramList, resultTypes, resultNames, resultZeros []string
+	for _, v := range params {
+		paramList = append(paramList, v.Name()+" "+types.TypeString(v.Type(), x.qf))
+	}
+	for _, v := range results {
+		resultTypes = append(resultTypes, types.TypeString(v.Type(), x.qf))
+		resultNames = append(resultNames, v.Name())
+		resultZeros = append(resultZeros, zeroValue(v.Type(), x.qf))
+	}
+	var funcZeros []string
+	for i := 0; i < sig.Results().Len(); i++ {
+		t := sig.Results().At(i).Type()
+		funcZeros = append(funcZeros, zeroValue(t, x.qf))
+		if tailReturn || shouldReturn != "" {
+			resultTypes = append(resultTypes, types.TypeString(t, x.qf))
+		}
+	}
+	if shouldReturn != "" {
+		// Insert bool result before the results of the enclosing function.
+		n := len(results)
+		resultTypes = append(resultTypes[:n], append([]string{"bool"}, resultTypes[n:]...)...)
+	}
+
+	// Body of the new function.
+	bodyStart, bodyEnd := x.lineStart(pos), x.lineEnd(endPos)
+	var bodyEdits []textEdit
+	if shouldReturn != "" {
+		for _, r := range returns {
+			values := append(append([]string{}, resultZeros...), "true")
+			if len(r.Results) > 0 {
+				values = append(values, string(x.src[x.offset(r.Results[0].Pos()):x.offset(r.Results[len(r.Results)-1].End())]))
+			}
+			bodyEdits = append(bodyEdits, textEdit{
+				offset: x.offset(r.Pos()) - bodyStart,
+				end:    x.offset(r.End()) - bodyStart,
+				text:   "return " + strings.Join(values, ", "),
+			})
+		}
+	}
+	var fn bytes.Buffer
+	fmt.Fprintf(&fn, "func %s(%s)", name, strings.Join(paramList, ", "))
+	switch len(resultTypes) {
+	case 0:
+	case 1:
+		fmt.Fprintf(&fn, " %s", resultTypes[0])
+	default:
+		fmt.Fprintf(&fn, " (%s)", strings.Join(resultTypes, ", "))
+	}
+	fn.WriteString(" {\n")
+	fn.Write(applyEdits(x.src[bodyStart:bodyEnd], bodyEdits))
+	fn.WriteString("\n")
+	switch {
+	case tailReturn:
+	case shouldReturn != "":
+		values := append(append(resultNames, "false"), funcZeros...)
+		fmt.Fprintf(&fn, "return %s\n", strings.Join(values, ", "))
+	case len(results) > 0:
+		fmt.Fprintf(&fn, "return %s\n", strings.Join(resultNames, ", "))
+	}
+	fn.WriteString("}\n")
+	p, err := format.Source(fn.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// Call of the new function.
+	var args []string
+	for _, v := range params {
+		args = append(args, v.Name())
+	}
+	call := name + "(" + strings.Join(args, ", ") + ")"
+	var lines []string
+	switch {
+	case tailReturn && sig.Results().Len() > 0:
+		lines = append(lines, "return "+call)
+	case tailReturn:
+		lines = append(lines, call, "return")
+	default:
+		lhs := append(append(resultNames, shouldReturn), retNames...)
+		if shouldReturn == "" {
+			lhs = resultNames
+		}
+		if len(lhs) == 0 {
+			lines = append(lines, call)
+			break
+		}
+		op := ":="
+		for _, v := range results {
+			if assigned[v] {
+				op = "="
+			}
+		}
+		if op == "=" {
+			// Declare the new variables to avoid shadowing the assigned
+			// variables.
+			for _, v := range results {
+				if !assigned[v] {
+					lines = append(lines, "var "+v.Name()+" "+types.TypeString(v.Type(), x.qf))
+				}
+			}
+			if shouldReturn != "" {
+				lines = append(lines, "var "+shouldReturn+" bool")
+				for i, n := range retNames {
+					lines = append(lines, "var "+n+" "+types.TypeString(sig.Results().At(i).Type(), x.qf))
+				}
+			}
+		}
+		lines = append(lines, strings.Join(lhs, ", ")+" "+op+" "+call)
+		if shouldReturn != "" {
+			lines = append(lines, "if "+shouldReturn+" {", "\treturn "+strings.Join(retNames, ", "), "}")
+		}
+	}
+	indent := x.indent(pos)
+	for i := range lines {
+		lines[i] = indent + strings.TrimSuffix(lines[i], " ")
+	}
+
+	return []textEdit{
+		{offset: bodyStart, end: bodyEnd, text: strings.Join(lines, "\n")},
+		{offset: x.offset(decl.End()), end: x.offset(decl.End()), text: "\n\n" + strings.TrimSuffix(string(p), "\n")},
+	}, nil
+}
+
+// branchInside returns true if the target of branch statement b is inside
+// pos through end.
+func (x *extractor) branchInside(b *ast.BranchStmt, pos, end token.Pos) bool {
+	if b.Label != nil {
+		if obj := x.pkg.info.Uses[b.Label]; obj != nil {
+			return pos <= obj.Pos() && obj.Pos() < end
+		}
+		return false
+	}
+	path, _ := astutil.PathEnclosingInterval(x.file, b.Pos(), b.End())
+	for _, n := range path[1:] {
+		if n.Pos() < pos {
+			return false
+		}
+		switch n.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			if b.Tok == token.BREAK || b.Tok == token.CONTINUE {
+				return true
+			}
+		case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+			if b.Tok == token.BREAK {
+				return true
+			}
+		case *ast.CaseClause:
+			if b.Tok == token.FALLTHROUGH {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// variable extracts the expression at byte offsets start through end to a
+// local variable declared before the enclosing statement.
+func (x *extractor) variable(start, end int, name string) ([]textEdit, error) {
+	info := x.pkg.info
+	if start < 0 || end > len(x.src) || start > end {
+		return nil, fmt.Errorf("offsets %d,%d out of range", start, end)
+	}
+	for start < end && isSpace(x.src[start]) {
+		start++
+	}
+	for end > start && isSpace(x.src[end-1]) {
+		end--
+	}
+	tf := x.pkg.fset.File(x.file.Pos())
+	pos, endPos := tf.Pos(start), tf.Pos(end)
+
+	path, _ := astutil.PathEnclosingInterval(x.file, pos, endPos)
+	expr, ok := path[0].(ast.Expr)
+	if !ok || expr.Pos() != pos || expr.End() != endPos {
+		return nil, errors.New("selection is not an expression")
+	}
+	tv, ok := info.Types[expr]
+	if !ok || !tv.IsValue() {
+		return nil, errors.New("selection is not a value")
+	}
+	if _, ok := tv.Type.(*types.Tuple); ok {
+		return nil, errors.New("selection has multiple values")
+	}
+
+	var stmt ast.Stmt
+	for i, n := range path[1:] {
+		child := path[i]
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, e := range n.Lhs {
+				if e == child {
+					return nil, errors.New("cannot extract assigned expression")
+				}
+			}
+		case *ast.IncDecStmt:
+			return nil, errors.New("cannot extract assigned expression")
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				return nil, errors.New("cannot extract operand of & operator")
+			}
+		case *ast.ForStmt:
+			if child == n.Cond || child == n.Post {
+				return nil, errors.New("cannot extract from loop condition or post statement")
+			}
+		case *ast.IfStmt:
+			if i+2 < len(path) {
+				if p, ok := path[i+2].(*ast.IfStmt); ok && p.Else == n {
+					return nil, errors.New("cannot extract from else if statement")
+				}
+			}
+		case *ast.CaseClause, *ast.CommClause:
+			for _, s := range caseBody(n) {
+				if s == child {
+					stmt = s
+				}
+			}
+			if stmt == nil {
+				return nil, errors.New("cannot extract from case expression")
+			}
+		case *ast.BlockStmt:
+			for _, s := range n.List {
+				if s == child {
+					stmt = s
+				}
+			}
+		case *ast.FuncDecl, *ast.GenDecl:
+			return nil, errors.New("selection is not in a function")
+		}
+		if stmt != nil {
+			break
+		}
+	}
+	if stmt == nil {
+		return nil, errors.New("selection is not in a statement")
+	}
+
+	var err error
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && err == nil {
+			obj := info.Uses[id]
+			if obj != nil && stmt.Pos() <= obj.Pos() && obj.Pos() < stmt.End() && !(pos <= obj.Pos() && obj.Pos() < endPos) {
+				err = fmt.Errorf("expression refers to %s declared in the statement", obj.Name())
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scope := x.pkg.tpkg.Scope()
+	name = uniqueName(name, nil, scope.Innermost(stmt.Pos()), scope.Innermost(pos))
+
+	return []textEdit{
+		{offset: x.offset(stmt.Pos()), end: x.offset(stmt.Pos()), text: name + " := " + x.text(expr) + "\n" + x.indent(stmt.Pos())},
+		{offset: start, end: end, text: name},
+	}, nil
+}
+
+func caseBody(n ast.Node) []ast.Stmt {
+	switch n := n.(type) {
+	case *ast.CaseClause:
+		return n.Body
+	case *ast.CommClause:
+		return n.Body
+	}
+	return nil
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
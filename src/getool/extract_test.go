@@ -0,0 +1,96 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const extractTestSource = `package a
+
+import "strconv"
+
+func F(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	m := n * 2
+	return m + len(s), nil
+}
+
+func G(xs []int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+`
+
+var extractTests = []struct {
+	opts extractOptions
+	expr string // text of expression for -var
+	out  string
+}{
+	{
+		opts: extractOptions{function: true, lines: "6,9"},
+		out: "REPL 6 11\n\tn, shouldReturn, ret0, ret1 := extracted(s)\n\tif shouldReturn {\n\t\treturn ret0, ret1\n\t}\n\tm := n * 2\n\treturn m + len(s), nil\n}\n\n" +
+			"func extracted(s string) (int, bool, int, error) {\n\tn, err := strconv.Atoi(s)\n\tif err != nil {\n\t\treturn 0, true, 0, err\n\t}\n\treturn n, false, 0, nil",
+	},
+	{
+		opts: extractOptions{function: true, lines: "16,18"},
+		out:  "REPL 16 15\n\ttotal = extracted(xs, total)\n\treturn total\n}\n\nfunc extracted(xs []int, total int) int {",
+	},
+	{
+		opts: extractOptions{function: true, lines: "10,11"},
+		out:  "REPL 10 9\n\treturn extracted(n, s)\n}\n\nfunc extracted(n int, s string) (int, error) {",
+	},
+	{
+		opts: extractOptions{function: true, lines: "17,17"},
+		out:  "REPL 17 18\n\t\ttotal = extracted(total, x)\n\t}\n\treturn total\n}\n\nfunc extracted(total int, x int) int {\n\ttotal += x",
+	},
+	{
+		opts: extractOptions{variable: true},
+		expr: "n * 2",
+		out:  "REPL 10 10\n\tx := n * 2\n\tm := x",
+	},
+	{
+		opts: extractOptions{variable: true, name: "total"},
+		expr: "m + len(s)",
+		out:  "REPL 11 11\n\ttotal := m + len(s)\n\treturn total, nil",
+	},
+	{
+		opts: extractOptions{variable: true},
+		expr: "total += x",
+		out:  "ERR\nselection is not an expression",
+	},
+}
+
+func TestExtract(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"go.mod": "module example.com/ws\n", "a.go": extractTestSource})
+
+	for _, tt := range extractTests {
+		opts := tt.opts
+		if tt.expr != "" {
+			i := strings.Index(extractTestSource, tt.expr)
+			opts.offset = strconv.Itoa(i) + "," + strconv.Itoa(i+len(tt.expr))
+		}
+		var buf bytes.Buffer
+		doExtract(&Context{
+			out:  &buf,
+			in:   strings.NewReader(extractTestSource),
+			cwd:  dir,
+			args: []string{"a.go"},
+		}, &opts)
+		out := strings.Replace(buf.String(), dir, "$", -1)
+		if out != tt.out {
+			t.Errorf("extract %+v = %q, want %q", opts, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+func init() {
+	var fs flag.FlagSet
+	offset := fs.Int("offset", -1, "byte `offset` of the composite literal")
+	commands["fillstruct"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doFillStruct(ctx, *offset) },
+	}
+}
+
+// doFillStruct adds the missing fields to the struct literal at offset in the
+// file named by the command argument. The contents of the file are read from
+// stdin. The result is written using the fmt command protocol.
+func doFillStruct(ctx *Context, offset int) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 1 {
+		fmt.Fprint(w, "fillstruct: one argument required\n")
+		return 1
+	}
+
+	fname := ctx.args[0]
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(ctx.cwd, fname)
+	}
+	in, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	ctx.overlay = map[string][]byte{fname: in}
+
+	out, err := fillStruct(ctx, fname, in, offset)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	writeReplacement(w, in, out)
+	return 0
+}
+
+func fillStruct(ctx *Context, fname string, src []byte, offset int) ([]byte, error) {
+	pkg, file, err := ctx.loadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err := packageError(pkg); err != nil {
+		return nil, err
+	}
+
+	tf := pkg.fset.File(file.Pos())
+	if offset < 0 || offset > tf.Size() {
+		return nil, fmt.Errorf("offset %d out of range", offset)
+	}
+	pos := tf.Pos(offset)
+	path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+
+	var (
+		lit *ast.CompositeLit
+		st  *types.Struct
+	)
+	for _, n := range path {
+		if n, ok := n.(*ast.CompositeLit); ok {
+			if t, ok := pkg.info.TypeOf(n).Underlying().(*types.Struct); ok {
+				lit, st = n, t
+				break
+			}
+		}
+	}
+	if lit == nil {
+		return nil, errors.New("no struct literal at offset")
+	}
+	for _, e := range lit.Elts {
+		if _, ok := e.(*ast.KeyValueExpr); !ok {
+			return nil, errors.New("struct literal has unkeyed fields")
+		}
+	}
+	for _, c := range file.Comments {
+		if lit.Lbrace < c.Pos() && c.Pos() < lit.Rbrace {
+			return nil, errors.New("struct literal contains comments")
+		}
+	}
+
+	text := func(n ast.Node) string {
+		return string(src[tf.Offset(n.Pos()):tf.Offset(n.End())])
+	}
+	values := make(map[string]string)
+	for _, e := range lit.Elts {
+		kv := e.(*ast.KeyValueExpr)
+		if id, ok := kv.Key.(*ast.Ident); ok {
+			values[id.Name] = text(kv.Value)
+		}
+	}
+
+	// Record the packages referenced by the placeholders so that missing
+	// imports can be added.
+	var imports []*types.Package
+	fqf := fileQualifier(file, pkg.tpkg)
+	qf := func(p *types.Package) string {
+		imports = append(imports, p)
+		return fqf(p)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fillStructPrefix)
+	if lit.Type != nil {
+		buf.WriteString(text(lit.Type))
+	}
+	buf.WriteString("{\n")
+	n := 0
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if !f.Exported() && f.Pkg() != pkg.tpkg {
+			continue
+		}
+		v, ok := values[f.Name()]
+		if !ok {
+			v, ok = placeholder(f.Type(), pkg.tpkg, qf)
+			if !ok {
+				continue
+			}
+		}
+		fmt.Fprintf(&buf, "%s: %s,\n", f.Name(), v)
+		n++
+	}
+	if n == len(lit.Elts) {
+		// All fields are set.
+		return src, nil
+	}
+	buf.WriteString("}\n")
+
+	p, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	p = bytes.TrimSuffix(bytes.TrimPrefix(p, []byte(fillStructPrefix)), []byte{'\n'})
+
+	// Indent the literal to match the line containing the literal.
+	lineStart := bytes.LastIndexByte(src[:tf.Offset(lit.Pos())], '\n') + 1
+	line := src[lineStart:]
+	indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+	p = bytes.Replace(p, []byte{'\n'}, append([]byte{'\n'}, indent...), -1)
+
+	out := applyEdits(src, []textEdit{{
+		offset: tf.Offset(lit.Pos()),
+		end:    tf.Offset(lit.End()),
+		text:   string(p),
+	}})
+
+	imported := make(map[string]bool)
+	for _, s := range file.Imports {
+		path, _ := strconv.Unquote(s.Path.Value)
+		imported[path] = true
+	}
+	for _, p := range imports {
+		if p != pkg.tpkg && !imported[p.Path()] {
+			imported[p.Path()] = true
+			out, err = addImport(out, p.Path(), "")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+const fillStructPrefix = "package p\n\nvar _ = "
+
+// placeholder returns an expression for a value of type t. False is returned
+// if the type cannot be named in package pkg.
+func placeholder(t types.Type, pkg *types.Package, qf types.Qualifier) (string, bool) {
+	if !canName(t, pkg, nil) {
+		switch t.Underlying().(type) {
+		case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+			return "nil", true
+		}
+		return "", false
+	}
+	switch u := t.(type) {
+	case *types.Pointer:
+		switch u.Elem().Underlying().(type) {
+		case *types.Struct, *types.Array, *types.Slice, *types.Map:
+			return "&" + types.TypeString(u.Elem(), qf) + "{}", true
+		}
+	case *types.Slice, *types.Map:
+		return types.TypeString(t, qf) + "{}", true
+	case *types.Chan:
+		return "make(" + types.TypeString(t, qf) + ")", true
+	}
+	return zeroValue(t, qf), true
+}
+
+// canName returns true if type t can be named in package pkg.
+func canName(t types.Type, pkg *types.Package, seen map[types.Type]bool) bool {
+	if seen[t] {
+		return true
+	}
+	if seen == nil {
+		seen = make(map[types.Type]bool)
+	}
+	seen[t] = true
+	switch t := t.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != nil && obj.Pkg() != pkg && !obj.Exported() {
+			return false
+		}
+		if obj.Pkg() != nil && obj.Parent() != obj.Pkg().Scope() {
+			// Local type.
+			return obj.Pkg() == pkg
+		}
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if !canName(t.TypeArgs().At(i), pkg, seen) {
+				return false
+			}
+		}
+	case *types.Alias:
+		return canName(types.Unalias(t), pkg, seen)
+	case *types.Pointer:
+		return canName(t.Elem(), pkg, seen)
+	case *types.Slice:
+		return canName(t.Elem(), pkg, seen)
+	case *types.Array:
+		return canName(t.Elem(), pkg, seen)
+	case *types.Chan:
+		return canName(t.Elem(), pkg, seen)
+	case *types.Map:
+		return canName(t.Key(), pkg, seen) && canName(t.Elem(), pkg, seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if !canName(t.Field(i).Type(), pkg, seen) {
+				return false
+			}
+		}
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				if !canName(tuple.At(i).Type(), pkg, seen) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
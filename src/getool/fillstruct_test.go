@@ -0,0 +1,105 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var fillStructTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+import "example.com/ws/b"
+
+type point struct {
+	x, y int
+}
+
+var p = point{y: 1}
+
+var s = &b.Server{Name: "s"}
+
+var j = b.Job{}
+
+var d = b.Server{Timeout: 0, Handler: nil, Name: "", Options: nil, Peers: nil, Limits: nil, Done: nil, Config: b.Config{}}
+`,
+	"b/b.go": `package b
+
+import "time"
+
+type Config struct{ N int }
+
+type Job struct {
+	Start time.Time
+	Opts  *Config
+}
+
+type Server struct {
+	Name    string
+	Timeout time.Duration
+	Handler func(string) error
+	Options *Config
+	Peers   []string
+	Limits  map[string]int
+	Done    chan bool
+	Config
+	state   int
+}
+`,
+}
+
+var fillStructTests = []struct {
+	lit string // literal at the start of this text is filled
+	out string
+}{
+	{
+		"point{",
+		"REPL 9 9\nvar p = point{\n\tx: 0,\n\ty: 1,\n}",
+	},
+	{
+		"b.Server{",
+		"REPL 11 11\nvar s = &b.Server{\n\tName:    \"s\",\n\tTimeout: 0,\n\tHandler: nil,\n\tOptions: &b.Config{},\n" +
+			"\tPeers:   []string{},\n\tLimits:  map[string]int{},\n\tDone:    make(chan bool),\n\tConfig:  b.Config{},\n}",
+	},
+	{
+		"b.Server{Timeout",
+		"OK",
+	},
+	{
+		"b.Config{}",
+		"REPL 15 15\nvar d = b.Server{Timeout: 0, Handler: nil, Name: \"\", Options: nil, Peers: nil, Limits: nil, Done: nil, Config: b.Config{\n\tN: 0,\n}}",
+	},
+	{
+		"b.Job{",
+		"REPL 3 13\nimport (\n\t\"time\"\n\n\t\"example.com/ws/b\"\n)\n\ntype point struct {\n\tx, y int\n}\n\nvar p = point{y: 1}\n\n" +
+			"var s = &b.Server{Name: \"s\"}\n\nvar j = b.Job{\n\tStart: time.Time{},\n\tOpts:  &b.Config{},\n}",
+	},
+	{
+		"var p",
+		"ERR\nno struct literal at offset",
+	},
+}
+
+func TestFillStruct(t *testing.T) {
+	dir := writeTestFiles(t, fillStructTestFiles)
+
+	src := fillStructTestFiles["a/a.go"]
+	for _, tt := range fillStructTests {
+		var buf bytes.Buffer
+		doFillStruct(&Context{
+			out:  &buf,
+			in:   strings.NewReader(src),
+			cwd:  dir,
+			args: []string{"a/a.go"},
+		}, strings.Index(src, tt.lit))
+		out := buf.String()
+		if out != tt.out {
+			t.Errorf("fillstruct %s = %q, want %q", tt.lit, out, tt.out)
+		}
+	}
+}
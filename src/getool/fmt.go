@@ -12,21 +12,56 @@ import (
 	"go/format"
 	"go/parser"
 	"go/token"
+	"io"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 
 	"golang.org/x/tools/imports"
 )
 
 func init() {
 	var fs flag.FlagSet
-	goimport := fs.Bool("goimport", false, "use goimport instead of gofmt")
+	var opts formatOptions
+	fs.BoolVar(&opts.goimport, "goimport", false, "use goimport instead of gofmt")
+	fs.BoolVar(&opts.simplify, "simplify", false, "simplify code as gofmt -s does")
+	fs.Var(&opts.rewrites, "rewrite", "apply gofmt -r style `rule` (may be repeated)")
+	fs.StringVar(&opts.local, "local", "", "comma separated `prefixes` of local import paths")
+	fs.StringVar(&opts.group, "group", "", "import grouping `mode`: keep, std or local")
+	fs.StringVar(&opts.lines, "lines", "", "format the statements or declarations covering lines `start,end`")
 	commands["fmt"] = &Command{
 		fs: &fs,
-		do: func(ctx *Context) int { return doFormat(ctx, *goimport) },
+		do: func(ctx *Context) int { return doFormat(ctx, &opts) },
 	}
 }
 
-func doFormat(ctx *Context, goimport bool) int {
+type formatOptions struct {
+	goimport bool
+	simplify bool
+	rewrites stringsFlag
+
+	// Import grouping options. If not set on the command line, the options
+	// are read from the local and group settings in the configuration file.
+	local string
+	group string
+
+	// If set, format the range of lines start,end instead of the whole
+	// file.
+	lines string
+}
+
+// stringsFlag is a flag.Value that accumulates the values of a repeated
+// flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ", ") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func doFormat(ctx *Context, opts *formatOptions) int {
 	w := bufio.NewWriter(ctx.out)
 	defer w.Flush()
 
@@ -41,41 +76,64 @@ func doFormat(ctx *Context, goimport bool) int {
 		return 0
 	}
 
-	var out []byte
-
-	if goimport {
-		var err error
-		out, err = imports.Process(fname, in, nil)
-		if err != nil {
-			fmt.Fprintf(w, "ERR\n%s", err)
-			return 0
+	o := *opts
+	if o.goimport && (o.local == "" || o.group == "") {
+		dir := ctx.cwd
+		if fname != "" {
+			dir = filepath.Dir(filepath.Join(ctx.cwd, fname))
 		}
-	} else {
-		fset := token.NewFileSet()
-		file, err := parser.ParseFile(fset, fname, in, parser.ParseComments)
-		if err != nil {
-			fmt.Fprintf(w, "ERR\n%s", err)
-			return 0
+		config := readConfig(dir)
+		if o.local == "" {
+			o.local = config["local"]
 		}
-		var buf bytes.Buffer
-		if err := format.Node(&buf, fset, file); err != nil {
-			fmt.Fprintf(w, "ERR\n%s", err)
-			return 0
+		if o.group == "" {
+			o.group = config["group"]
 		}
-		out = buf.Bytes()
+	}
+
+	var out []byte
+	if o.lines != "" {
+		var start, end int
+		if _, err = fmt.Sscanf(o.lines, "%d,%d", &start, &end); err != nil {
+			err = fmt.Errorf("bad -lines value %q", o.lines)
+		} else {
+			out, err = formatRange(fname, in, start, end, &o)
+		}
+	} else {
+		out, err = formatSource(fname, in, &o)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
 	}
 
 	// Input does not contain trailing newline, trim trailing newline from
-	// output to match.
-	if len(out) > 0 && out[len(out)-1] == '\n' {
+	// output to match. Range formatting preserves the end of the input.
+	if o.lines == "" && len(out) > 0 && out[len(out)-1] == '\n' {
 		out = out[:len(out)-1]
 	}
 
+	writeReplacement(w, in, out)
+	return 0
+}
+
+// writeReplacement writes the difference between in and out as an OK line or
+// as a REPL line followed by the replacement lines.
+func writeReplacement(w io.Writer, in, out []byte) {
 	if bytes.Equal(in, out) {
 		fmt.Fprintf(w, "OK")
-		return 0
+		return
 	}
+	start, end, lines := replacement(in, out)
+	fmt.Fprintf(w, "REPL %d %d", start, end)
+	for _, l := range lines {
+		fmt.Fprintf(w, "\n%s", l)
+	}
+}
 
+// replacement returns the smallest range of lines start through end in in
+// that must be replaced with lines to get out.
+func replacement(in, out []byte) (start, end int, lines [][]byte) {
 	linesIn := bytes.Split(in, []byte{'\n'})
 	linesOut := bytes.Split(out, []byte{'\n'})
 
@@ -100,9 +158,59 @@ func doFormat(ctx *Context, goimport bool) int {
 		}
 	}
 
-	fmt.Fprintf(w, "REPL %d %d", head+1, len(linesIn)-tail)
-	for _, l := range linesOut[head : len(linesOut)-tail] {
-		fmt.Fprintf(w, "\n%s", l)
+	return head + 1, len(linesIn) - tail, linesOut[head : len(linesOut)-tail]
+}
+
+// formatSource formats the Go source in using the rewrite rules, simplify
+// and goimport options.
+func formatSource(fname string, in []byte, opts *formatOptions) ([]byte, error) {
+	if opts.goimport && !opts.simplify && len(opts.rewrites) == 0 {
+		return processImports(fname, in, opts)
 	}
-	return 0
+
+	var rules []*rewriteRule
+	for _, s := range opts.rewrites {
+		r, err := parseRewriteRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fname, in, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		file = r.rewriteFile(fset, file)
+	}
+	if opts.simplify {
+		simplify(file)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	if opts.goimport {
+		return processImports(fname, buf.Bytes(), opts)
+	}
+	return buf.Bytes(), nil
+}
+
+// processImports adds missing imports, removes unused imports and groups
+// the imports in src.
+func processImports(fname string, src []byte, opts *formatOptions) ([]byte, error) {
+	var local []string
+	for _, prefix := range strings.Split(opts.local, ",") {
+		if prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/"); prefix != "" {
+			local = append(local, prefix)
+		}
+	}
+	out, err := imports.Process(fname, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	return groupImports(fname, out, opts.group, local)
 }
@@ -14,6 +14,11 @@ var formatTests = []struct {
 	in        string
 	out       string
 	goimports bool
+	simplify  bool
+	rewrites  []string
+	local     string
+	group     string
+	lines     string
 }{
 	{
 		in:        "package main",
@@ -68,6 +73,97 @@ var formatTests = []struct {
 		out:       "REPL 1 3\npackage main\n\nvar i int",
 		goimports: true,
 	},
+	{
+		// composite literal element types
+		in:       "package main\n\nvar x = []T{T{1}, T{2}}",
+		out:      "REPL 3 3\nvar x = []T{{1}, {2}}",
+		simplify: true,
+	},
+	{
+		// range with blank value
+		in:       "package main\n\nfunc f(s []int) {\n\tfor i, _ := range s {\n\t\t_ = i\n\t}\n}",
+		out:      "REPL 4 4\n\tfor i := range s {",
+		simplify: true,
+	},
+	{
+		in:       "package main\n\nvar y = x[1:len(x)]",
+		out:      "REPL 3 3\nvar y = x[1:]",
+		simplify: true,
+	},
+	{
+		in:       "package main\n\nvar y = x[1:len(x)]",
+		out:      "REPL 3 3\nvar y = x[1:]",
+		rewrites: []string{"a[b:len(a)] -> a[b:]"},
+	},
+	{
+		// rules are applied in order
+		in:       "package main\n\nvar y = f(a)",
+		out:      "REPL 3 3\nvar y = h(a)",
+		rewrites: []string{"f(x) -> g(x)", "g(x) -> h(x)"},
+	},
+	{
+		in:       "package main",
+		out:      "ERR\nrewrite rule \"f(x)\" must be of the form 'pattern -> replacement'",
+		rewrites: []string{"f(x)"},
+	},
+	{
+		// merge, group, sort and remove duplicate imports
+		in:        "package main\n\nimport \"github.com/ourorg/b\"\n\nimport (\n\t\"github.com/other/c\"\n\t\"fmt\"\n\t\"github.com/ourorg/a\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint(a.X, b.X, c.X)",
+		out:       "REPL 3 9\nimport (\n\t\"fmt\"\n\n\t\"github.com/other/c\"\n\n\t\"github.com/ourorg/a\"\n\t\"github.com/ourorg/b\"",
+		goimports: true,
+		local:     "github.com/ourorg",
+		group:     "local",
+	},
+	{
+		// local prefixes match whole path elements
+		in:        "package main\n\nimport (\n\t\"github.com/ourorg/a\"\n\t\"github.com/ourorgother/b\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint(a.X, b.X)",
+		out:       "REPL 4 6\n\t\"fmt\"\n\n\t\"github.com/ourorgother/b\"\n\n\t\"github.com/ourorg/a\"",
+		goimports: true,
+		local:     "github.com/ourorg/",
+		group:     "local",
+	},
+	{
+		in:        "package main\n\nimport (\n\t\"github.com/ourorg/a\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint(a.X)",
+		out:       "REPL 4 5\n\t\"fmt\"\n\n\t\"github.com/ourorg/a\"",
+		goimports: true,
+		group:     "std",
+	},
+	{
+		// unused imports are removed
+		in:        "package main\n\nimport (\n\t\"os\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint()",
+		out:       "REPL 4 4",
+		goimports: true,
+		group:     "std",
+	},
+	{
+		// statement in function
+		in:    "package main\n\nfunc f() {\nx:=1\ny:=2\n}\n\nvar  z int",
+		out:   "REPL 4 4\n\tx := 1",
+		lines: "4,4",
+	},
+	{
+		// nested statements
+		in:    "package main\n\nfunc f() {\n\tfor {\n\t\tif  x {\n  y()\n\t\t}\n\t}\n}",
+		out:   "REPL 6 6\n\t\t\ty()",
+		lines: "6,6",
+	},
+	{
+		// case clause
+		in:    "package main\n\nfunc f() {\n\tswitch {\ncase  x:\n\t\ty()\n\t}\n}",
+		out:   "REPL 5 5\n\tcase x:",
+		lines: "5,5",
+	},
+	{
+		// declaration in file with syntax error
+		in:    "package main\n\nfunc f() {\n\tx :=\n}\n\n// z is z.\nvar  z  int\n\nfunc g(",
+		out:   "REPL 8 8\nvar z int",
+		lines: "8,8",
+	},
+	{
+		in:    "package main\n\nfunc f() {\n\tx :=\n}",
+		out:   "ERR\ntest.go:5: expected operand, found '}'",
+		lines: "4,4",
+	},
 }
 
 func TestFormat(t *testing.T) {
@@ -77,7 +173,14 @@ func TestFormat(t *testing.T) {
 			out:  &buf,
 			in:   strings.NewReader(tt.in),
 			args: []string{"test.go"},
-		}, tt.goimports)
+		}, &formatOptions{
+			goimport: tt.goimports,
+			simplify: tt.simplify,
+			rewrites: tt.rewrites,
+			local:    tt.local,
+			group:    tt.group,
+			lines:    tt.lines,
+		})
 		out := buf.String()
 		if out != tt.out {
 			t.Errorf("%q: got %q, want %q", tt.in, out, tt.out)
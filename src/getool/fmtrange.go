@@ -0,0 +1,278 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// formatRange formats the smallest complete statements or declarations in
+// src covering lines start through end. The rest of src is returned
+// unchanged. Other parts of the file are not required to parse.
+func formatRange(fname string, src []byte, start, end int, opts *formatOptions) ([]byte, error) {
+	lines := bytes.Split(src, []byte{'\n'})
+	if start < 1 {
+		start = 1
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return src, nil
+	}
+	if fname == "" {
+		fname = "buffer.go"
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fname, src, parser.ParseComments)
+	if err != nil {
+		// Parse the top level declarations around the range. A line
+		// directive maps the positions back to the lines in the buffer. The
+		// blank line following the directive keeps the directive out of the
+		// doc comment of the first declaration.
+		s, e, ok := declLines(lines, start, end)
+		if !ok || s < 2 {
+			return nil, err
+		}
+		unit := fmt.Sprintf("package p\n//line %s:%d\n\n%s", fname, s-1, bytes.Join(lines[s-1:e], []byte{'\n'}))
+		file, err = parser.ParseFile(fset, fname, unit, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o := *opts
+	o.goimport = false
+
+	var head, tail []string
+	s, e := 0, 0
+	if list, depth, keyword := findStmtList(fset, file, start, end); list != nil {
+		s, e = nodeLines(fset, list[0], list[len(list)-1])
+		if isWholeLines(fset, lines, list[0].Pos(), list[len(list)-1].End()) {
+			head = []string{"package p", "", "func _() {"}
+			for i := 1; i < depth; i++ {
+				head = append(head, "{")
+			}
+			if keyword != "" {
+				head = append(head, keyword+" {")
+			}
+			for range head[2:] {
+				tail = append(tail, "}")
+			}
+		} else {
+			s = 0
+		}
+	}
+	if s == 0 {
+		var decls []ast.Decl
+		for _, d := range file.Decls {
+			ds, de := nodeLines(fset, d, d)
+			if ds <= end && de >= start {
+				decls = append(decls, d)
+			}
+		}
+		if len(decls) == 0 {
+			return src, nil
+		}
+		s, e = nodeLines(fset, decls[0], decls[len(decls)-1])
+		if !isWholeLines(fset, lines, declPos(decls[0]), decls[len(decls)-1].End()) {
+			return nil, fmt.Errorf("%s:%d: cannot format part of a line", fname, s)
+		}
+		head = []string{"package p", ""}
+	}
+
+	var buf bytes.Buffer
+	for _, l := range head {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+	buf.Write(bytes.Join(lines[s-1:e], []byte{'\n'}))
+	buf.WriteByte('\n')
+	for _, l := range tail {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+
+	out, err := formatSource(fname, buf.Bytes(), &o)
+	if err != nil {
+		return nil, err
+	}
+	outLines := bytes.Split(bytes.TrimSuffix(out, []byte{'\n'}), []byte{'\n'})
+	if len(outLines) <= len(head)+len(tail) {
+		return nil, fmt.Errorf("%s:%d: unexpected format result", fname, s)
+	}
+	outLines = outLines[len(head) : len(outLines)-len(tail)]
+
+	result := make([][]byte, 0, len(lines)-(e-s+1)+len(outLines))
+	result = append(result, lines[:s-1]...)
+	result = append(result, outLines...)
+	result = append(result, lines[e:]...)
+	return bytes.Join(result, []byte{'\n'}), nil
+}
+
+var declStartPat = regexp.MustCompile(`^(?:func|type|var|const|import)\b`)
+
+// declLines returns the lines of the top level declarations covering lines
+// start through end. Declarations are found by looking for keywords at the
+// start of a line.
+func declLines(lines [][]byte, start, end int) (int, int, bool) {
+	s := start
+	for s > 1 && !declStartPat.Match(lines[s-1]) {
+		s--
+	}
+	if !declStartPat.Match(lines[s-1]) {
+		return 0, 0, false
+	}
+	// Include the doc comment.
+	for s > 1 && bytes.HasPrefix(lines[s-2], []byte("//")) {
+		s--
+	}
+	e := end
+	for e < len(lines) && !declStartPat.Match(lines[e]) {
+		e++
+	}
+	// Exclude blank lines and the doc comment of the next declaration.
+	for e > end && (len(bytes.TrimSpace(lines[e-1])) == 0 || bytes.HasPrefix(lines[e-1], []byte("//"))) {
+		e--
+	}
+	return s, e, true
+}
+
+// findStmtList returns the statements in the innermost statement list
+// containing lines start through end, the indentation depth of the list and
+// the keyword of the statement enclosing the list if the list is a list of
+// case clauses.
+func findStmtList(fset *token.FileSet, file *ast.File, start, end int) ([]ast.Stmt, int, string) {
+	line := func(pos token.Pos) int { return fset.Position(pos).Line }
+
+	var (
+		list    []ast.Stmt
+		depth   int
+		keyword string
+	)
+
+	var visit func(n ast.Node, d int, kw string) bool
+	visit = func(n ast.Node, d int, kw string) bool {
+		var stmts []ast.Stmt
+		inside := false
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			if kw == "" {
+				d++
+			}
+			stmts = n.List
+			inside = line(n.Lbrace) < start && end < line(n.Rbrace)
+		case *ast.CaseClause:
+			d++
+			stmts = n.Body
+			inside = line(n.Colon) < start && end <= line(n.End())
+		case *ast.CommClause:
+			d++
+			stmts = n.Body
+			inside = line(n.Colon) < start && end <= line(n.End())
+		}
+		if !inside {
+			return false
+		}
+
+		var selected []ast.Stmt
+		for _, s := range stmts {
+			if line(s.Pos()) <= end && line(s.End()) >= start {
+				selected = append(selected, s)
+			}
+		}
+		if len(selected) == 0 {
+			return false
+		}
+		if len(selected) == 1 {
+			switch s := selected[0].(type) {
+			case *ast.CaseClause, *ast.CommClause:
+				if visit(s, d, "") {
+					return true
+				}
+			default:
+				found := false
+				ast.Inspect(s, func(n ast.Node) bool {
+					if found {
+						return false
+					}
+					switch n := n.(type) {
+					case *ast.SwitchStmt:
+						found = visit(n.Body, d, "switch")
+					case *ast.TypeSwitchStmt:
+						found = visit(n.Body, d, "switch")
+					case *ast.SelectStmt:
+						found = visit(n.Body, d, "select")
+					case *ast.BlockStmt:
+						found = visit(n, d, "")
+					default:
+						return true
+					}
+					return false
+				})
+				if found {
+					return true
+				}
+			}
+		}
+		list, depth, keyword = selected, d, kw
+		return true
+	}
+
+	for _, d := range file.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Body != nil {
+			if visit(d.Body, 0, "") {
+				return list, depth, keyword
+			}
+		}
+	}
+	return nil, 0, ""
+}
+
+// nodeLines returns the first line of first and the last line of last.
+func nodeLines(fset *token.FileSet, first, last ast.Node) (int, int) {
+	pos := first.Pos()
+	if d, ok := first.(ast.Decl); ok {
+		pos = declPos(d)
+	}
+	return fset.Position(pos).Line, fset.Position(last.End()).Line
+}
+
+// declPos returns the position of a declaration including its doc comment.
+func declPos(d ast.Decl) token.Pos {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	}
+	return d.Pos()
+}
+
+// isWholeLines returns true if the text from pos to end starts and ends a
+// line, ignoring white space and trailing comments.
+func isWholeLines(fset *token.FileSet, lines [][]byte, pos, end token.Pos) bool {
+	// Use the line from the adjusted position and the column from the
+	// unadjusted position. Line directives in this file do not specify a
+	// column.
+	pline, pcol := fset.Position(pos).Line, fset.PositionFor(pos, false).Column
+	eline, ecol := fset.Position(end).Line, fset.PositionFor(end, false).Column
+	if len(bytes.TrimSpace(lines[pline-1][:pcol-1])) != 0 {
+		return false
+	}
+	rest := strings.TrimSpace(string(lines[eline-1][ecol-1:]))
+	return rest == "" || strings.HasPrefix(rest, "//")
+}
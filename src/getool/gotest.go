@@ -0,0 +1,386 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+func init() {
+	var fs flag.FlagSet
+	bench := fs.Bool("bench", false, "run benchmarks instead of tests")
+	offset := fs.Int("offset", -1, "byte `offset` of the symbol in the file")
+	commands["test"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doTest(ctx, *bench, *offset) },
+	}
+}
+
+// doTest implements the commands
+//
+//	test [-bench] -offset offset file
+//	test [-bench] importpath symbol
+//
+// The command runs the tests or benchmarks for the symbol at offset in file
+// or for the symbol in the package with the given import path. The symbol is
+// a function name, a type name or a method name in the form Type.Method. If
+// the symbol is a Test, Benchmark, Example or Fuzz function, then that
+// function is run. Otherwise, the functions that test the symbol by naming
+// convention are run. The contents of file are read from stdin.
+//
+// Failures are printed in the format file:line: message followed by a
+// summary of the test output. Benchmark results are printed as a table.
+func doTest(ctx *Context, bench bool, offset int) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	var (
+		pkg    *Package
+		symbol string
+		err    error
+	)
+	switch len(ctx.args) {
+	case 1:
+		fname := ctx.args[0]
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(ctx.cwd, fname)
+		}
+		var in []byte
+		in, err = ioutil.ReadAll(ctx.in)
+		if err != nil {
+			break
+		}
+		ctx.overlay = map[string][]byte{fname: in}
+		pkg, err = ctx.loadPackageDir(filepath.Dir(fname), loadTests)
+		if err == nil {
+			symbol, err = symbolAtOffset(fname, in, offset)
+		}
+	case 2:
+		pkg, err = ctx.loadPackage(ctx.args[0], 0)
+		symbol = ctx.args[1]
+	default:
+		fmt.Fprint(w, "test: file or import path and symbol arguments required\n")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+
+	names, err := testFuncs(pkg)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	funcs := matchTestFuncs(names, symbol, bench)
+	if len(funcs) == 0 {
+		kind := "tests"
+		if bench {
+			kind = "benchmarks"
+		}
+		fmt.Fprintf(w, "ERR\nno %s found for %s", kind, symbol)
+		return 0
+	}
+
+	pattern := "^(" + strings.Join(funcs, "|") + ")$"
+	args := []string{"test"}
+	if tags := pkg.bctx.BuildTags; len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+	if bench {
+		args = append(args, "-run", "^$", "-bench", pattern, "-benchmem")
+	} else {
+		args = append(args, "-run", pattern)
+	}
+	cmd := goCommand(pkg.bctx, pkg.bpkg.Dir, append(args, ".")...)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	writeTestOutput(w, pkg.bpkg.Dir, out)
+	return 0
+}
+
+// symbolAtOffset returns the name of the function, method or type declared
+// at offset in the file.
+func symbolAtOffset(fname string, src []byte, offset int) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fname, src, parser.SkipObjectResolution)
+	if file == nil {
+		return "", err
+	}
+	tf := fset.File(file.Pos())
+	if offset < 0 || offset > tf.Size() {
+		return "", fmt.Errorf("offset %d out of range", offset)
+	}
+	pos := tf.Pos(offset)
+	path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Recv != nil && len(n.Recv.List) == 1 {
+				if name := recvTypeName(n.Recv.List[0].Type); name != "" {
+					return name + "." + n.Name.Name, nil
+				}
+			}
+			return n.Name.Name, nil
+		case *ast.TypeSpec:
+			return n.Name.Name, nil
+		}
+	}
+	return "", errors.New("no function or type at offset")
+}
+
+// recvTypeName returns the name of the base type of a method receiver.
+func recvTypeName(x ast.Expr) string {
+	if star, ok := x.(*ast.StarExpr); ok {
+		x = star.X
+	}
+	switch t := x.(type) {
+	case *ast.IndexExpr:
+		x = t.X
+	case *ast.IndexListExpr:
+		x = t.X
+	}
+	if id, ok := x.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+var testFuncPrefixes = []string{"Test", "Benchmark", "Example", "Fuzz"}
+
+// testFuncs returns the names of the Test, Benchmark, Example and Fuzz
+// functions in the test files of pkg.
+func testFuncs(pkg *Package) ([]string, error) {
+	var names []string
+	fset := token.NewFileSet()
+	for _, name := range append(pkg.bpkg.TestGoFiles, pkg.bpkg.XTestGoFiles...) {
+		fname := filepath.Join(pkg.bpkg.Dir, name)
+		var src interface{}
+		if p, ok := pkg.overlay[fname]; ok {
+			src = p
+		}
+		file, err := parser.ParseFile(fset, fname, src, parser.SkipObjectResolution)
+		if file == nil {
+			return nil, err
+		}
+		for _, decl := range file.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && d.Recv == nil && testFuncPrefix(d.Name.Name) != "" {
+				names = append(names, d.Name.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// testFuncPrefix returns the prefix of a Test, Benchmark, Example or Fuzz
+// function name or "" if name is not the name of such a function.
+func testFuncPrefix(name string) string {
+	for _, prefix := range testFuncPrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		if r, _ := utf8.DecodeRuneInString(rest); rest == "" || !unicode.IsLower(r) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// matchTestFuncs returns the test functions in names for symbol. If bench is
+// true, then only benchmarks are returned. Otherwise, benchmarks are
+// excluded.
+func matchTestFuncs(names []string, symbol string, bench bool) []string {
+	keep := func(name string) bool {
+		return (testFuncPrefix(name) == "Benchmark") == bench
+	}
+	for _, name := range names {
+		if name == symbol {
+			if !keep(name) {
+				return nil
+			}
+			return []string{name}
+		}
+	}
+
+	// Match the test naming conventions TestF, TestT_M, TestTM and ExampleT_M
+	// with optional _suffix.
+	upper := func(s string) string {
+		r, n := utf8.DecodeRuneInString(s)
+		return string(unicode.ToUpper(r)) + s[n:]
+	}
+	var stems []string
+	if i := strings.Index(symbol, "."); i >= 0 {
+		t, m := upper(symbol[:i]), symbol[i+1:]
+		stems = []string{t + "_" + m, t + upper(m)}
+	} else {
+		stems = []string{upper(symbol)}
+	}
+	var result []string
+	for _, name := range names {
+		if !keep(name) {
+			continue
+		}
+		rest := name[len(testFuncPrefix(name)):]
+		for _, stem := range stems {
+			if rest == stem || strings.HasPrefix(rest, stem+"_") {
+				result = append(result, name)
+				break
+			}
+		}
+	}
+	return result
+}
+
+var (
+	testLogRx     = regexp.MustCompile(`^\s+([^\s:]+\.go):(\d+): (.*)$`)
+	testCompileRx = regexp.MustCompile(`^([^\s:]+\.go):(\d+):(\d+): (.*)$`)
+	testFrameRx   = regexp.MustCompile(`^\t(.+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+	testBenchRx   = regexp.MustCompile(`^(Benchmark\S+)\s+(\d+)\s+(.*)$`)
+	testSummaryRx = regexp.MustCompile(`^(--- (?:FAIL|PASS|SKIP)|FAIL|PASS|ok|panic:)`)
+)
+
+// goCommand returns a command to run the go tool in directory dir. The
+// target operating system and architecture of the command are taken from
+// bctx.
+func goCommand(bctx *build.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cgo := "0"
+	if bctx.CgoEnabled {
+		cgo = "1"
+	}
+	cmd.Env = append(os.Environ(), "GOOS="+bctx.GOOS, "GOARCH="+bctx.GOARCH, "CGO_ENABLED="+cgo)
+	return cmd
+}
+
+// writeTestOutput converts the output of go test run in directory dir to
+// quickfix entries followed by the summary lines and benchmark table.
+func writeTestOutput(w io.Writer, dir string, out []byte) {
+	var (
+		summary  []string
+		bench    [][]string
+		panicMsg string
+	)
+	abs := func(fname string) string {
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(dir, fname)
+		}
+		return filepath.Clean(fname)
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if m := testLogRx.FindStringSubmatch(line); m != nil {
+			fmt.Fprintf(w, "%s:%s: %s\n", abs(m[1]), m[2], m[3])
+			continue
+		}
+		if m := testCompileRx.FindStringSubmatch(line); m != nil {
+			fmt.Fprintf(w, "%s:%s:%s: %s\n", abs(m[1]), m[2], m[3], m[4])
+			continue
+		}
+		if strings.HasPrefix(line, "panic: ") {
+			panicMsg = line
+		}
+		if m := testFrameRx.FindStringSubmatch(line); m != nil && panicMsg != "" && filepath.Dir(m[1]) == dir {
+			// First frame in the package after a panic.
+			fmt.Fprintf(w, "%s:%s: %s\n", m[1], m[2], panicMsg)
+			panicMsg = ""
+			continue
+		}
+		if m := testBenchRx.FindStringSubmatch(line); m != nil {
+			bench = append(bench, append([]string{m[1], m[2]}, strings.Fields(m[3])...))
+			continue
+		}
+		if testSummaryRx.MatchString(line) {
+			summary = append(summary, line)
+		}
+	}
+	for _, line := range summary {
+		fmt.Fprintln(w, line)
+	}
+	writeBenchTable(w, bench)
+}
+
+// writeBenchTable writes benchmark results as a table. Each row is the
+// benchmark name, the number of iterations and value unit pairs.
+func writeBenchTable(w io.Writer, rows [][]string) {
+	if len(rows) == 0 {
+		return
+	}
+	header := []string{"name", "iterations"}
+	for _, row := range rows {
+		for i := 3; i < len(row); i += 2 {
+			if !contains(header, row[i]) {
+				header = append(header, row[i])
+			}
+		}
+	}
+	table := [][]string{header}
+	for _, row := range rows {
+		cells := make([]string, len(header))
+		cells[0], cells[1] = row[0], row[1]
+		for i := 3; i < len(row); i += 2 {
+			for j, unit := range header {
+				if unit == row[i] {
+					cells[j] = row[i-1]
+				}
+			}
+		}
+		table = append(table, cells)
+	}
+	widths := make([]int, len(header))
+	for _, cells := range table {
+		for i, cell := range cells {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, cells := range table {
+		var buf bytes.Buffer
+		for i, cell := range cells {
+			if i == 0 {
+				fmt.Fprintf(&buf, "%-*s", widths[i], cell)
+			} else {
+				fmt.Fprintf(&buf, "  %*s", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(buf.String(), " "))
+	}
+}
+
+func contains(a []string, s string) bool {
+	for _, e := range a {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var matchTestFuncsTests = []struct {
+	symbol string
+	bench  bool
+	funcs  []string
+}{
+	{"Add", false, []string{"ExampleAdd", "TestAdd", "TestAdd_overflow"}},
+	{"Add", true, []string{"BenchmarkAdd"}},
+	{"add", false, []string{"ExampleAdd", "TestAdd", "TestAdd_overflow"}},
+	{"Set.Len", false, []string{"ExampleSet_Len", "TestSetLen"}},
+	{"TestAdd", false, []string{"TestAdd"}},
+	{"TestAdd", true, nil},
+	{"BenchmarkAdd", true, []string{"BenchmarkAdd"}},
+	{"Sub", false, nil},
+}
+
+func TestMatchTestFuncs(t *testing.T) {
+	names := []string{"BenchmarkAdd", "ExampleAdd", "ExampleSet_Len", "FuzzAddition", "TestAdd", "TestAddition", "TestAdd_overflow", "TestSetLen"}
+	for _, tt := range matchTestFuncsTests {
+		funcs := matchTestFuncs(names, tt.symbol, tt.bench)
+		if !reflect.DeepEqual(funcs, tt.funcs) {
+			t.Errorf("matchTestFuncs(%q, %v) = %q, want %q", tt.symbol, tt.bench, funcs, tt.funcs)
+		}
+	}
+}
+
+func TestTestOutput(t *testing.T) {
+	out := `--- FAIL: TestAdd (0.00s)
+    a_test.go:12: got 3, want 4
+        second line
+--- FAIL: TestPanic (0.00s)
+panic: boom [recovered]
+	panic: boom
+
+goroutine 6 [running]:
+testing.tRunner.func1.2({0x4e1c20, 0x55a1b0})
+	/usr/local/go/src/testing/testing.go:1545 +0x238
+example.com/ws/a.TestPanic(0x0?)
+	/ws/a/a_test.go:20 +0x25
+FAIL
+FAIL	example.com/ws/a	0.003s
+BenchmarkAdd-8   	1000000000	         0.2500 ns/op	       0 B/op	       0 allocs/op
+BenchmarkAddLarge-8	    5000	    250000 ns/op
+`
+	want := `/ws/a/a_test.go:12: got 3, want 4
+/ws/a/a_test.go:20: panic: boom [recovered]
+--- FAIL: TestAdd (0.00s)
+--- FAIL: TestPanic (0.00s)
+panic: boom [recovered]
+FAIL
+FAIL	example.com/ws/a	0.003s
+name                 iterations   ns/op  B/op  allocs/op
+BenchmarkAdd-8       1000000000  0.2500     0          0
+BenchmarkAddLarge-8        5000  250000
+`
+	var buf bytes.Buffer
+	writeTestOutput(&buf, "/ws/a", []byte(out))
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+var testTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+func Add(a, b int) int { return a + b }
+
+func Sub(a, b int) int { return a - b }
+`,
+	"a/a_test.go": `package a
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 4 {
+		t.Errorf("bad sum")
+	}
+}
+
+func TestSub(t *testing.T) {}
+`,
+}
+
+func TestTest(t *testing.T) {
+	dir := writeTestFiles(t, testTestFiles)
+
+	fname := filepath.Join(dir, "a", "a.go")
+	src := testTestFiles["a/a.go"]
+	var out bytes.Buffer
+	doTest(&Context{out: &out, in: strings.NewReader(src), cwd: dir, args: []string{fname}}, false, strings.Index(src, "a + b"))
+	want := filepath.Join(dir, "a", "a_test.go") + ":7: bad sum\n--- FAIL: TestAdd"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("got\n%s\nwant prefix\n%s", out.String(), want)
+	}
+	if strings.Contains(out.String(), "TestSub") {
+		t.Errorf("TestSub run:\n%s", out.String())
+	}
+}
+
+func TestGoCommand(t *testing.T) {
+	bctx := newBuildContext("windows", "386", "")
+	out, err := goCommand(bctx, t.TempDir(), "env", "GOOS", "GOARCH", "CGO_ENABLED").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "windows\n386\n0\n"; got != want {
+		t.Errorf("go env = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	var fs flag.FlagSet
+	commands["impl"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doImpl(ctx) },
+	}
+}
+
+// doImpl implements the command
+//
+//	impl receiver interface file
+//
+// The command prints stubs for the methods of interface that are not declared
+// on receiver. The receiver is a method receiver such as 'r *myReader'. The
+// interface is an optionally qualified interface name with optional type
+// arguments such as io.Reader or example.com/pkg.Set[int]. The contents of
+// file are read from stdin.
+//
+// The output is an IMPORT line for each package that must be imported by
+// file followed by the stubs.
+func doImpl(ctx *Context) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 3 {
+		fmt.Fprint(w, "impl: receiver, interface and file arguments required\n")
+		return 1
+	}
+
+	fname := ctx.args[2]
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(ctx.cwd, fname)
+	}
+	in, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	ctx.overlay = map[string][]byte{fname: in}
+
+	imports, stubs, err := implStubs(ctx, fname, ctx.args[0], ctx.args[1])
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	for _, path := range imports {
+		fmt.Fprintf(w, "IMPORT %s\n", path)
+	}
+	w.Write(stubs)
+	return 0
+}
+
+// implReceiver is a parsed method receiver.
+type implReceiver struct {
+	text     string // receiver as written in the stub
+	name     string // receiver name or ""
+	typeName string // name of the receiver base type
+}
+
+func parseImplReceiver(s string) (*implReceiver, error) {
+	s = strings.TrimSpace(s)
+	x, err := parser.ParseExpr("func(" + s + ") {}")
+	if err != nil {
+		return nil, fmt.Errorf("bad receiver %q", s)
+	}
+	params := x.(*ast.FuncLit).Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return nil, fmt.Errorf("bad receiver %q", s)
+	}
+	r := &implReceiver{text: s}
+	if len(params[0].Names) == 1 {
+		r.name = params[0].Names[0].Name
+	}
+	t := params[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch tt := t.(type) {
+	case *ast.IndexExpr:
+		t = tt.X
+	case *ast.IndexListExpr:
+		t = tt.X
+	}
+	id, ok := t.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("bad receiver type in %q", s)
+	}
+	r.typeName = id.Name
+	return r, nil
+}
+
+// implStubs returns the imports to add to file fname and the method stubs for
+// interface spec iface on receiver recv.
+func implStubs(ctx *Context, fname string, recv string, iface string) ([]string, []byte, error) {
+	r, err := parseImplReceiver(recv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkg, file, err := ctx.loadFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pkg.tpkg == nil {
+		return nil, nil, packageError(pkg)
+	}
+
+	t, err := resolveInterface(ctx, pkg, file, iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	it, ok := t.Underlying().(*types.Interface)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not an interface", iface)
+	}
+
+	// Methods declared or promoted on the receiver type.
+	var mset *types.MethodSet
+	if obj, ok := pkg.tpkg.Scope().Lookup(r.typeName).(*types.TypeName); ok {
+		mset = types.NewMethodSet(types.NewPointer(obj.Type()))
+	}
+
+	imported := make(map[string]bool)
+	for _, s := range file.Imports {
+		path, _ := strconv.Unquote(s.Path.Value)
+		imported[path] = true
+	}
+	var imports []string
+	// An interface named by import path is type checked separately from the
+	// current package. Compare packages by path.
+	fqf := fileQualifier(file, pkg.tpkg)
+	qf := func(p *types.Package) string {
+		if p.Path() != pkg.tpkg.Path() && !imported[p.Path()] {
+			imported[p.Path()] = true
+			imports = append(imports, p.Path())
+		}
+		return fqf(p)
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < it.NumMethods(); i++ {
+		m := it.Method(i)
+		mpkg := m.Pkg()
+		if !m.Exported() {
+			if mpkg.Path() != pkg.tpkg.Path() {
+				return nil, nil, fmt.Errorf("%s has unexported method %s", iface, m.Name())
+			}
+			mpkg = pkg.tpkg
+		}
+		if mset != nil && mset.Lookup(mpkg, m.Name()) != nil {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "func (%s) %s", r.text, m.Name())
+		types.WriteSignature(&buf, renameParams(m.Type().(*types.Signature), r.name), qf)
+		buf.WriteString(" {\n\tpanic(\"not implemented\")\n}\n")
+	}
+	return imports, buf.Bytes(), nil
+}
+
+// renameParams returns sig with parameters and results named name renamed
+// to avoid a conflict with the receiver name.
+func renameParams(sig *types.Signature, name string) *types.Signature {
+	if name == "" || name == "_" {
+		return sig
+	}
+	conflict := false
+	names := make(map[string]bool)
+	for _, tuple := range []*types.Tuple{sig.Params(), sig.Results()} {
+		for i := 0; i < tuple.Len(); i++ {
+			names[tuple.At(i).Name()] = true
+			if tuple.At(i).Name() == name {
+				conflict = true
+			}
+		}
+	}
+	if !conflict {
+		return sig
+	}
+	newName := uniqueName(name, names)
+	rename := func(tuple *types.Tuple) *types.Tuple {
+		vars := make([]*types.Var, tuple.Len())
+		for i := range vars {
+			v := tuple.At(i)
+			n := v.Name()
+			if n == name {
+				n = newName
+			}
+			vars[i] = types.NewParam(v.Pos(), v.Pkg(), n, v.Type())
+		}
+		return types.NewTuple(vars...)
+	}
+	return types.NewSignatureType(nil, nil, nil, rename(sig.Params()), rename(sig.Results()), sig.Variadic())
+}
+
+// resolveInterface returns the type named by spec. The spec is a type name
+// optionally qualified by a package name imported in file or by an import
+// path, followed by optional type arguments. Type arguments are evaluated in
+// the scope of file.
+func resolveInterface(ctx *Context, pkg *Package, file *ast.File, spec string) (types.Type, error) {
+	spec = strings.TrimSpace(spec)
+	base, args := spec, ""
+	if i := strings.Index(spec, "["); i >= 0 && strings.HasSuffix(spec, "]") {
+		base, args = spec[:i], spec[i+1:len(spec)-1]
+	}
+
+	var obj types.Object
+	i := strings.LastIndex(base, ".")
+	if i < strings.LastIndex(base, "/") {
+		return nil, fmt.Errorf("bad interface %q", spec)
+	}
+	if i < 0 {
+		obj = pkg.tpkg.Scope().Lookup(base)
+	} else {
+		path, name := base[:i], base[i+1:]
+		if !strings.Contains(path, "/") {
+			if pn, ok := pkg.info.Scopes[file].Lookup(path).(*types.PkgName); ok {
+				path = pn.Imported().Path()
+			}
+		}
+		ipkg, err := ctx.loadPackage(path, loadTypes)
+		if err != nil {
+			return nil, err
+		}
+		if ipkg.tpkg == nil {
+			return nil, packageError(ipkg)
+		}
+		obj = ipkg.tpkg.Scope().Lookup(name)
+	}
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("type %s not found", base)
+	}
+	if args == "" {
+		return tn.Type(), nil
+	}
+
+	// Evaluate the type arguments in the scope of the file.
+	x, err := parser.ParseExpr("T[" + args + "]")
+	if err != nil {
+		return nil, fmt.Errorf("bad type arguments in %q", spec)
+	}
+	var exprs []ast.Expr
+	switch x := x.(type) {
+	case *ast.IndexExpr:
+		exprs = []ast.Expr{x.Index}
+	case *ast.IndexListExpr:
+		exprs = x.Indices
+	}
+	var targs []types.Type
+	for _, e := range exprs {
+		tv, err := types.Eval(pkg.fset, pkg.tpkg, file.Name.Pos(), types.ExprString(e))
+		if err != nil {
+			return nil, err
+		}
+		if !tv.IsType() {
+			return nil, fmt.Errorf("%s is not a type", types.ExprString(e))
+		}
+		targs = append(targs, tv.Type)
+	}
+	return types.Instantiate(nil, tn.Type(), targs, true)
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var implTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+import "example.com/ws/b"
+
+type myReader struct{}
+
+func (r *myReader) Close() error { return nil }
+
+var _ b.Set[int]
+
+type Node struct{}
+
+type Visitor interface {
+	Visit(n *Node)
+	done()
+}
+`,
+	"b/b.go": `package b
+
+type Set[T any] interface {
+	Add(v T) bool
+	Contains(s Set[T]) bool
+	Len() int
+}
+`,
+}
+
+var implTests = []struct {
+	recv, iface string
+	out         string
+}{
+	{
+		"r *myReader", "io.ReadCloser",
+		"func (r *myReader) Read(p []byte) (n int, err error) {\n\tpanic(\"not implemented\")\n}\n",
+	},
+	{
+		"h myHandler", "net/http.Handler",
+		"IMPORT net/http\nfunc (h myHandler) ServeHTTP(http.ResponseWriter, *http.Request) {\n\tpanic(\"not implemented\")\n}\n",
+	},
+	{
+		"s *intSet", "b.Set[int]",
+		"func (s *intSet) Add(v int) bool {\n\tpanic(\"not implemented\")\n}\n\n" +
+			"func (s *intSet) Contains(s1 b.Set[int]) bool {\n\tpanic(\"not implemented\")\n}\n\n" +
+			"func (s *intSet) Len() int {\n\tpanic(\"not implemented\")\n}\n",
+	},
+	{
+		"v *visitor", "example.com/ws/a.Visitor",
+		"func (v *visitor) Visit(n *Node) {\n\tpanic(\"not implemented\")\n}\n\n" +
+			"func (v *visitor) done() {\n\tpanic(\"not implemented\")\n}\n",
+	},
+	{
+		"r *myReader", "bufio.Writer",
+		"ERR\nbufio.Writer is not an interface",
+	},
+	{
+		"r *myReader", "io.EOF",
+		"ERR\ntype io.EOF not found",
+	},
+}
+
+func TestImpl(t *testing.T) {
+	dir := writeTestFiles(t, implTestFiles)
+
+	src := implTestFiles["a/a.go"]
+	for _, tt := range implTests {
+		var buf bytes.Buffer
+		doImpl(&Context{
+			out:  &buf,
+			in:   strings.NewReader(src),
+			cwd:  filepath.Join(dir, "a"),
+			args: []string{tt.recv, tt.iface, "a.go"},
+		})
+		out := buf.String()
+		if out != tt.out {
+			t.Errorf("impl %q %q = %q, want %q", tt.recv, tt.iface, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+func init() {
+	var fs flag.FlagSet
+	commands["import"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doImport(ctx) },
+	}
+}
+
+// doImport implements the commands
+//
+//	import add path [name]
+//	import drop path
+//
+// The commands edit the Go source read from stdin and write the result using
+// the fmt command protocol.
+func doImport(ctx *Context) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	args := ctx.args
+	if len(args) < 2 || (args[0] == "add" && len(args) > 3) || (args[0] == "drop" && len(args) > 2) {
+		fmt.Fprint(w, "import: add path [name] or drop path expected\n")
+		return 1
+	}
+
+	in, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+
+	var out []byte
+	switch args[0] {
+	case "add":
+		name := ""
+		if len(args) == 3 {
+			name = args[2]
+		}
+		out, err = addImport(in, args[1], name)
+	case "drop":
+		out, err = dropImport(in, args[1])
+	default:
+		fmt.Fprintf(w, "import: unknown subcommand %q\n", args[0])
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	writeReplacement(w, in, out)
+	return 0
+}
+
+// importLines describes the layout of an import declaration. All fields are
+// zero based line indexes.
+type importLines struct {
+	decl  *ast.GenDecl
+	first int // first line of declaration
+	last  int // last line of declaration
+
+	// Specs in the order they appear in a parenthesized declaration and the
+	// range of lines used by each spec including its comments.
+	specs []*ast.ImportSpec
+	start []int
+	end   []int
+}
+
+// parseImportLines parses the import declarations of src. The layout of the
+// first import declaration is returned. Nil is returned if the declaration
+// cannot be edited line by line.
+func parseImportLines(src []byte) (*token.FileSet, *ast.File, *importLines, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	line := func(pos token.Pos) int { return fset.Position(pos).Line - 1 }
+
+	for _, d := range file.Decls {
+		d, ok := d.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		if len(d.Specs) == 1 && d.Specs[0].(*ast.ImportSpec).Path.Value == `"C"` {
+			continue
+		}
+		il := &importLines{decl: d, first: line(d.Pos()), last: line(d.End())}
+		if d.Doc != nil {
+			il.first = line(d.Doc.Pos())
+		}
+		for _, s := range d.Specs {
+			s := s.(*ast.ImportSpec)
+			start, end := s.Pos(), s.End()
+			if s.Doc != nil {
+				start = s.Doc.Pos()
+			}
+			if s.Comment != nil {
+				end = s.Comment.End()
+			}
+			il.specs = append(il.specs, s)
+			il.start = append(il.start, line(start))
+			il.end = append(il.end, line(end))
+		}
+		if d.Lparen.IsValid() {
+			// Each spec must be on lines of its own.
+			prev := line(d.Lparen)
+			for i := range il.specs {
+				if il.start[i] <= prev {
+					return fset, file, nil, nil
+				}
+				prev = il.end[i]
+			}
+			if line(d.Rparen) <= prev {
+				return fset, file, nil, nil
+			}
+		}
+		return fset, file, il, nil
+	}
+	return fset, file, nil, nil
+}
+
+func isStandardImportPath(path string) bool {
+	return importGroup(path, groupStd, nil) == 0
+}
+
+func formatImportSpec(path, name string) string {
+	if name != "" {
+		return name + " " + strconv.Quote(path)
+	}
+	return strconv.Quote(path)
+}
+
+// addImport adds an import of path with optional name to the Go source src.
+// The import is added to the group of standard library or third party
+// imports, keeping the group sorted.
+func addImport(src []byte, path, name string) ([]byte, error) {
+	fset, file, il, err := parseImportLines(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range file.Imports {
+		p, _ := strconv.Unquote(s.Path.Value)
+		if p != path {
+			continue
+		}
+		if s.Name == nil && name == "" || s.Name != nil && s.Name.Name == name {
+			return src, nil
+		}
+	}
+
+	lines := strings.Split(string(src), "\n")
+	spec := formatImportSpec(path, name)
+	std := isStandardImportPath(path)
+
+	var hasImports bool
+	for _, d := range file.Decls {
+		if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			hasImports = true
+		}
+	}
+
+	switch {
+	case !hasImports:
+		// Add a declaration after the package clause. Separate the
+		// declaration from the following line with a blank line.
+		i := fset.Position(file.Name.End()).Line
+		decl := []string{"", "import " + spec}
+		if i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			decl = append(decl, "")
+		}
+		lines = insertLines(lines, i, decl...)
+	case il == nil:
+		return addImportAST(src, path, name)
+	case !il.decl.Lparen.IsValid():
+		// Convert single line declaration to a parenthesized declaration.
+		old := il.specs[0]
+		oldPath, _ := strconv.Unquote(old.Path.Value)
+		oldSpec := strings.TrimSpace(strings.Join(lines[il.start[0]:il.end[0]+1], "\n"))
+		oldSpec = strings.TrimPrefix(oldSpec, "import")
+		oldSpec = strings.TrimSpace(oldSpec)
+		block := []string{"\t" + oldSpec, "\t" + spec}
+		switch {
+		case isStandardImportPath(oldPath) != std:
+			if std {
+				block = []string{"\t" + spec, "", "\t" + oldSpec}
+			} else {
+				block = []string{"\t" + oldSpec, "", "\t" + spec}
+			}
+		case path < oldPath:
+			block = []string{"\t" + spec, "\t" + oldSpec}
+		}
+		decl := append([]string{"import ("}, block...)
+		decl = append(decl, ")")
+		if strings.Contains(oldSpec, "\n") {
+			// Comments spanning lines.
+			return addImportAST(src, path, name)
+		}
+		first := fset.Position(il.decl.Pos()).Line - 1
+		lines = append(lines[:first], append(decl, lines[il.last+1:]...)...)
+	default:
+		// Find the groups of specs separated by blank lines.
+		type group struct{ first, last int }
+		var groups []group
+		for i := range il.specs {
+			if i == 0 || il.start[i] > il.end[i-1]+1 {
+				groups = append(groups, group{i, i})
+			} else {
+				groups[len(groups)-1].last = i
+			}
+		}
+		specPath := func(i int) string {
+			p, _ := strconv.Unquote(il.specs[i].Path.Value)
+			return p
+		}
+
+		best, bestLen := -1, -1
+		for gi, g := range groups {
+			if isStandardImportPath(specPath(g.first)) != std {
+				continue
+			}
+			for i := g.first; i <= g.last; i++ {
+				if n := commonPrefixLen(path, specPath(i)); n >= bestLen {
+					best, bestLen = gi, n
+				}
+			}
+		}
+
+		switch {
+		case best >= 0:
+			g := groups[best]
+			at := il.end[g.last] + 1
+			for i := g.first; i <= g.last; i++ {
+				if path < specPath(i) {
+					at = il.start[i]
+					break
+				}
+			}
+			lines = insertLines(lines, at, "\t"+spec)
+		case std:
+			// New group before the first group.
+			lines = insertLines(lines, il.start[0], "\t"+spec, "")
+		case len(groups) > 0:
+			// New group after the last group.
+			lines = insertLines(lines, il.end[len(il.specs)-1]+1, "", "\t"+spec)
+		default:
+			lines = insertLines(lines, fset.Position(il.decl.Rparen).Line-1, "\t"+spec)
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// addImportAST adds an import using the AST. The whole file is formatted.
+func addImportAST(src []byte, path, name string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	astutil.AddNamedImport(fset, file, name, path)
+	return formatFile(fset, file, src)
+}
+
+// dropImport removes imports of path from the Go source src.
+func dropImport(src []byte, path string) ([]byte, error) {
+	_, file, il, err := parseImportLines(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var index []int
+	if il != nil {
+		for i, s := range il.specs {
+			if p, _ := strconv.Unquote(s.Path.Value); p == path {
+				index = append(index, i)
+			}
+		}
+	}
+	if len(index) == 0 {
+		found := false
+		for _, s := range file.Imports {
+			if p, _ := strconv.Unquote(s.Path.Value); p == path {
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("package " + strconv.Quote(path) + " is not imported")
+		}
+		return dropImportAST(src, path)
+	}
+
+	lines := strings.Split(string(src), "\n")
+	if len(index) == len(il.specs) {
+		// Remove the declaration and a blank line following the declaration.
+		first, last := il.first, il.last
+		if last+1 < len(lines) && strings.TrimSpace(lines[last+1]) == "" && first > 0 && strings.TrimSpace(lines[first-1]) == "" {
+			last++
+		}
+		return []byte(strings.Join(append(lines[:first], lines[last+1:]...), "\n")), nil
+	}
+
+	for j := len(index) - 1; j >= 0; j-- {
+		i := index[j]
+		first, last := il.start[i], il.end[i]
+		// Remove the blank line following the spec if the spec starts a group.
+		// This also removes the group separator when the spec is the only spec
+		// in the group.
+		if (first-1 == il.first || strings.TrimSpace(lines[first-1]) == "") && strings.TrimSpace(lines[last+1]) == "" {
+			last++
+		} else if strings.TrimSpace(lines[last+1]) == ")" && strings.TrimSpace(lines[first-1]) == "" {
+			first--
+		}
+		lines = append(lines[:first], lines[last+1:]...)
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// dropImportAST removes imports using the AST. The whole file is formatted.
+func dropImportAST(src []byte, path string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range file.Imports {
+		if p, _ := strconv.Unquote(s.Path.Value); p == path {
+			name := ""
+			if s.Name != nil {
+				name = s.Name.Name
+			}
+			astutil.DeleteNamedImport(fset, file, name, path)
+		}
+	}
+	return formatFile(fset, file, src)
+}
+
+// formatFile formats file. The trailing newline is removed if src does not
+// end with a newline.
+func formatFile(fset *token.FileSet, file *ast.File, src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if !bytes.HasSuffix(src, []byte{'\n'}) {
+		out = bytes.TrimSuffix(out, []byte{'\n'})
+	}
+	return out, nil
+}
+
+func insertLines(lines []string, at int, insert ...string) []string {
+	result := make([]string, 0, len(lines)+len(insert))
+	result = append(result, lines[:at]...)
+	result = append(result, insert...)
+	return append(result, lines[at:]...)
+}
+
+func commonPrefixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
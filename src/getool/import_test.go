@@ -0,0 +1,111 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var importTests = []struct {
+	in   string
+	args []string
+	out  string
+}{
+	{
+		// no imports
+		in:   "package main\n\nvar x int\n",
+		args: []string{"add", "fmt"},
+		out:  "REPL 3 2\nimport \"fmt\"\n",
+	},
+	{
+		// no imports, declaration follows package clause
+		in:   "package main\nvar x int\n",
+		args: []string{"add", "fmt"},
+		out:  "REPL 2 1\n\nimport \"fmt\"\n",
+	},
+	{
+		// already imported
+		in:   "package main\n\nimport \"fmt\"\n",
+		args: []string{"add", "fmt"},
+		out:  "OK",
+	},
+	{
+		// single line form
+		in:   "package main\n\nimport \"os\"\n",
+		args: []string{"add", "fmt"},
+		out:  "REPL 3 3\nimport (\n\t\"fmt\"\n\t\"os\"\n)",
+	},
+	{
+		// single line form, new group
+		in:   "package main\n\nimport \"os\"\n",
+		args: []string{"add", "example.com/x", "y"},
+		out:  "REPL 3 3\nimport (\n\t\"os\"\n\n\ty \"example.com/x\"\n)",
+	},
+	{
+		// sorted into standard library group
+		in:   "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\t\"example.com/x\"\n)\n",
+		args: []string{"add", "io"},
+		out:  "REPL 5 4\n\t\"io\"",
+	},
+	{
+		// sorted into third party group
+		in:   "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/x\"\n)\n",
+		args: []string{"add", "example.com/a"},
+		out:  "REPL 6 5\n\t\"example.com/a\"",
+	},
+	{
+		// new standard library group
+		in:   "package main\n\nimport (\n\t\"example.com/x\"\n)\n",
+		args: []string{"add", "fmt"},
+		out:  "REPL 4 3\n\t\"fmt\"\n",
+	},
+	{
+		// drop last import
+		in:   "package main\n\nimport \"fmt\"\n\nvar x int\n",
+		args: []string{"drop", "fmt"},
+		out:  "REPL 3 4",
+	},
+	{
+		// drop from group
+		in:   "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n",
+		args: []string{"drop", "os"},
+		out:  "REPL 5 5",
+	},
+	{
+		// drop only import in group
+		in:   "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/x\"\n)\n",
+		args: []string{"drop", "example.com/x"},
+		out:  "REPL 5 6",
+	},
+	{
+		// drop first import in group followed by another group
+		in:   "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/x\"\n)\n",
+		args: []string{"drop", "fmt"},
+		out:  "REPL 4 5",
+	},
+	{
+		// drop import not imported
+		in:   "package main\n",
+		args: []string{"drop", "fmt"},
+		out:  "ERR\npackage \"fmt\" is not imported",
+	},
+}
+
+func TestImport(t *testing.T) {
+	for _, tt := range importTests {
+		var buf bytes.Buffer
+		doImport(&Context{
+			out:  &buf,
+			in:   strings.NewReader(tt.in),
+			args: tt.args,
+		})
+		out := buf.String()
+		if out != tt.out {
+			t.Errorf("%q %v: got %q, want %q", tt.in, tt.args, out, tt.out)
+		}
+	}
+}
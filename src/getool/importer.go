@@ -0,0 +1,115 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// sourceImporter is a types.ImporterFrom that type checks imported packages
+// from source. Function bodies are ignored and errors are not reported.
+type sourceImporter struct {
+	fset     *token.FileSet
+	ctxt     build.Context
+	srcDir   string
+	packages map[string]*types.Package
+}
+
+// newSourceImporter returns an importer for a package in directory dir using
+// build context bctx.
+func newSourceImporter(fset *token.FileSet, bctx *build.Context, dir string) *sourceImporter {
+	ctxt := *bctx
+	// Resolve modules relative to the package.
+	ctxt.Dir = dir
+	// Select the pure Go implementations of packages.
+	ctxt.CgoEnabled = false
+	return &sourceImporter{
+		fset:     fset,
+		ctxt:     ctxt,
+		srcDir:   dir,
+		packages: make(map[string]*types.Package),
+	}
+}
+
+// importing marks a package that is being imported to detect import cycles.
+var importing types.Package
+
+func (imp *sourceImporter) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, imp.srcDir, 0)
+}
+
+func (imp *sourceImporter) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if !filepath.IsAbs(srcDir) {
+		srcDir = imp.srcDir
+	}
+	bpkg, err := imp.ctxt.Import(path, srcDir, 0)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); !ok {
+			return nil, err
+		}
+	}
+	key := bpkg.Dir
+	if key == "" {
+		key = bpkg.ImportPath
+	}
+	if pkg := imp.packages[key]; pkg != nil {
+		if pkg == &importing {
+			return nil, fmt.Errorf("import cycle through package %q", path)
+		}
+		return pkg, nil
+	}
+	imp.packages[key] = &importing
+
+	var files []*ast.File
+	for _, name := range bpkg.GoFiles {
+		file, err := parser.ParseFile(imp.fset, filepath.Join(bpkg.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil && file == nil {
+			delete(imp.packages, key)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	conf := types.Config{
+		Importer:         imp,
+		IgnoreFuncBodies: true,
+		FakeImportC:      true,
+		Error:            func(error) {},
+	}
+	pkg, _ := conf.Check(bpkg.ImportPath, imp.fset, files, nil)
+	if pkg == nil {
+		delete(imp.packages, key)
+		return nil, fmt.Errorf("could not type check package %q", path)
+	}
+	imp.packages[key] = pkg
+	return pkg, nil
+}
+
+// dirImporter imports packages for the files of a package in directory dir
+// using an importer shared with other packages.
+type dirImporter struct {
+	imp *sourceImporter
+	dir string
+}
+
+func (d dirImporter) Import(path string) (*types.Package, error) {
+	return d.imp.ImportFrom(path, d.dir, 0)
+}
+
+func (d dirImporter) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	if !filepath.IsAbs(srcDir) {
+		srcDir = d.dir
+	}
+	return d.imp.ImportFrom(path, srcDir, mode)
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Import grouping modes.
+const (
+	// Keep the groups created by goimports.
+	groupKeep = "keep"
+
+	// Standard library, everything else.
+	groupStd = "std"
+
+	// Standard library, third party, local prefixes.
+	groupLocal = "local"
+)
+
+// importGroup returns the group number of an import path. A local prefix
+// matches the path with the prefix and the paths below it.
+func importGroup(path string, mode string, local []string) int {
+	if mode == groupLocal {
+		for _, prefix := range local {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return 2
+			}
+		}
+	}
+	if i := strings.Index(path, "/"); i >= 0 {
+		path = path[:i]
+	}
+	if !strings.Contains(path, ".") {
+		return 0
+	}
+	return 1
+}
+
+type importSpec struct {
+	path  string
+	key   string
+	text  []byte
+	group int
+}
+
+// groupImports rewrites the import declarations in src to a single
+// declaration with the imports grouped according to mode and sorted within
+// each group. Duplicate imports are removed. The source is returned unchanged
+// if the imports contain comments that cannot be moved with an import spec.
+func groupImports(fname string, src []byte, mode string, local []string) ([]byte, error) {
+	switch mode {
+	case "", groupKeep:
+		return src, nil
+	case groupStd, groupLocal:
+	default:
+		return nil, fmt.Errorf("unknown import grouping mode %q", mode)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fname, src, parser.ParseComments|parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	var decls []*ast.GenDecl
+	for _, d := range file.Decls {
+		d, ok := d.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		if len(d.Specs) == 1 && d.Specs[0].(*ast.ImportSpec).Path.Value == `"C"` {
+			// The import of "C" is tied to its preamble.
+			continue
+		}
+		if len(decls) > 0 && d.Doc != nil {
+			return src, nil
+		}
+		decls = append(decls, d)
+	}
+	if len(decls) == 0 {
+		return src, nil
+	}
+
+	var specs []*importSpec
+	seen := map[string]bool{}
+	attached := map[*ast.CommentGroup]bool{}
+	for _, d := range decls {
+		for _, s := range d.Specs {
+			s := s.(*ast.ImportSpec)
+			path, err := strconv.Unquote(s.Path.Value)
+			if err != nil {
+				return src, nil
+			}
+			start, end := s.Pos(), s.End()
+			if s.Doc != nil {
+				start = s.Doc.Pos()
+				attached[s.Doc] = true
+			}
+			if s.Comment != nil {
+				end = s.Comment.End()
+				attached[s.Comment] = true
+			}
+			key := path
+			if s.Name != nil {
+				key = s.Name.Name + " " + path
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			specs = append(specs, &importSpec{
+				path:  path,
+				key:   key,
+				text:  src[offset(start):offset(end)],
+				group: importGroup(path, mode, local),
+			})
+		}
+	}
+
+	for _, c := range file.Comments {
+		if attached[c] {
+			continue
+		}
+		for _, d := range decls {
+			if d.Pos() <= c.Pos() && c.End() <= d.End() {
+				return src, nil
+			}
+		}
+	}
+
+	sort.SliceStable(specs, func(i, j int) bool {
+		if specs[i].group != specs[j].group {
+			return specs[i].group < specs[j].group
+		}
+		if specs[i].path != specs[j].path {
+			return specs[i].path < specs[j].path
+		}
+		return specs[i].key < specs[j].key
+	})
+
+	var block bytes.Buffer
+	block.WriteString("import (\n")
+	for i, s := range specs {
+		if i > 0 && s.group != specs[i-1].group {
+			block.WriteByte('\n')
+		}
+		block.WriteByte('\t')
+		block.Write(s.text)
+		block.WriteByte('\n')
+	}
+	block.WriteString(")")
+
+	var buf bytes.Buffer
+	last := 0
+	for i, d := range decls {
+		buf.Write(src[last:offset(d.Pos())])
+		if i == 0 {
+			buf.Write(block.Bytes())
+		}
+		last = offset(d.End())
+	}
+	buf.Write(src[last:])
+
+	return format.Source(buf.Bytes())
+}
@@ -25,6 +25,10 @@ func main() {
 	log.SetFlags(0)
 
 	cwd := flag.String("cwd", ".", "use `dir` to resolve relative paths")
+	goos := flag.String("goos", "", "target operating `system` for loading packages")
+	goarch := flag.String("goarch", "", "target `architecture` for loading packages")
+	tags := flag.String("tags", "", "comma separated `list` of build tags")
+	protocol := flag.Int("protocol", 0, "protocol `version` expected by the caller")
 
 	flag.Usage = printUsage
 	flag.Parse()
@@ -33,6 +37,10 @@ func main() {
 		*cwd = d
 	}
 
+	if *protocol != 0 && (*protocol < minProtocolVersion || *protocol > protocolVersion) {
+		log.Fatalf("getool: protocol version %d not supported, want version %d through %d", *protocol, minProtocolVersion, protocolVersion)
+	}
+
 	args := flag.Args()
 	if len(args) >= 1 {
 		if c, ok := commands[args[0]]; ok {
@@ -41,12 +49,20 @@ func main() {
 				os.Exit(1)
 			}
 			c.fs.Parse(args[1:])
-			os.Exit(c.do(&Context{
-				cwd:  *cwd,
-				in:   os.Stdin,
-				out:  os.Stdout,
-				args: c.fs.Args(),
-			}))
+			ctx := &Context{
+				cwd:      *cwd,
+				in:       os.Stdin,
+				out:      os.Stdout,
+				args:     c.fs.Args(),
+				protocol: *protocol,
+			}
+			if dir, err := os.UserCacheDir(); err == nil {
+				ctx.cacheDir = filepath.Join(dir, "getool")
+			}
+			if *goos != "" || *goarch != "" || *tags != "" {
+				ctx.bctx = newBuildContext(*goos, *goarch, *tags)
+			}
+			os.Exit(c.do(ctx))
 		}
 	}
 	log.Fatalf("getool: unknown command")
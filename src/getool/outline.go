@@ -0,0 +1,292 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	var fs flag.FlagSet
+	commands["outline"] = &Command{
+		fs: &fs,
+		do: doOutline,
+	}
+}
+
+// doOutline implements the command
+//
+//	outline file
+//
+// The command prints the declarations in file: types with their fields and
+// methods, functions, constants and variables. Methods declared in other
+// files of the package are listed with their type. The contents of file are
+// read from stdin. Syntax errors are ignored; the declarations parsed before
+// an error are printed.
+//
+// Each line of the output is the tab separated fields level, kind, position,
+// file, name and signature. The level is 0 for top-level declarations and 1
+// for fields and methods. The position is line*10000+column. The file is
+// empty for declarations in file.
+func doOutline(ctx *Context) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 1 {
+		fmt.Fprint(w, "outline: file argument required\n")
+		return 1
+	}
+	fname := ctx.args[0]
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(ctx.cwd, fname)
+	}
+	in, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, fname, in, parser.SkipObjectResolution)
+	if file == nil || file.Name == nil {
+		return 0
+	}
+
+	o := &outliner{fset: fset, fname: fname, types: make(map[string]*outlineEntry)}
+	o.addFile(file)
+	o.addPackageMethods(ctx.buildContext(), file.Name.Name)
+
+	sort.SliceStable(o.entries, func(i, j int) bool { return o.entries[i].sortPos < o.entries[j].sortPos })
+	for _, e := range o.entries {
+		e.write(w, 0)
+	}
+	return 0
+}
+
+// outlineEntry is a declaration in the outline.
+type outlineEntry struct {
+	kind      string
+	pos       token.Position
+	name      string
+	signature string
+	children  []*outlineEntry
+
+	// Offset in the file used to order the entries. Types declared in other
+	// files are placed at the first method in the file.
+	sortPos int
+}
+
+type outliner struct {
+	fset    *token.FileSet
+	fname   string
+	entries []*outlineEntry
+	types   map[string]*outlineEntry
+}
+
+func (e *outlineEntry) write(w *bufio.Writer, level int) {
+	fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\n", level, e.kind, e.pos.Line*10000+e.pos.Column, e.pos.Filename, e.name, e.signature)
+	for _, c := range e.children {
+		c.write(w, level+1)
+	}
+}
+
+func (o *outliner) entry(kind string, pos token.Pos, name, signature string) *outlineEntry {
+	p := o.fset.Position(pos)
+	if p.Filename == o.fname {
+		p.Filename = ""
+	}
+	return &outlineEntry{kind: kind, pos: p, name: name, signature: signature, sortPos: p.Offset}
+}
+
+// addFile adds the declarations in the file to the outline.
+func (o *outliner) addFile(file *ast.File) {
+	var methods []*ast.FuncDecl
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					e := o.typeEntry(s)
+					o.types[s.Name.Name] = e
+					o.entries = append(o.entries, e)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						sig := d.Tok.String() + " " + n.Name
+						if s.Type != nil {
+							sig += " " + types.ExprString(s.Type)
+						}
+						o.entries = append(o.entries, o.entry(d.Tok.String(), n.Pos(), n.Name, sig))
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if d.Recv != nil {
+				methods = append(methods, d)
+				continue
+			}
+			o.entries = append(o.entries, o.entry("func", d.Name.Pos(), d.Name.Name, funcSignature(d)))
+		}
+	}
+	for _, d := range methods {
+		o.addMethod(d, true)
+	}
+}
+
+// typeEntry returns the entry for a type with its fields and interface
+// methods.
+func (o *outliner) typeEntry(s *ast.TypeSpec) *outlineEntry {
+	sig := "type " + s.Name.Name
+	if s.TypeParams != nil {
+		var params []string
+		for _, f := range s.TypeParams.List {
+			var names []string
+			for _, n := range f.Names {
+				names = append(names, n.Name)
+			}
+			params = append(params, strings.Join(names, ", ")+" "+types.ExprString(f.Type))
+		}
+		sig += "[" + strings.Join(params, ", ") + "]"
+	}
+	e := o.entry("type", s.Name.Pos(), s.Name.Name, "")
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		sig += " struct"
+		for _, f := range t.Fields.List {
+			typ := types.ExprString(f.Type)
+			if len(f.Names) == 0 {
+				e.children = append(e.children, o.entry("field", f.Type.Pos(), embeddedName(f.Type), typ))
+			}
+			for _, n := range f.Names {
+				e.children = append(e.children, o.entry("field", n.Pos(), n.Name, n.Name+" "+typ))
+			}
+		}
+	case *ast.InterfaceType:
+		sig += " interface"
+		for _, f := range t.Methods.List {
+			if len(f.Names) == 0 {
+				e.children = append(e.children, o.entry("embedded", f.Type.Pos(), embeddedName(f.Type), types.ExprString(f.Type)))
+			}
+			for _, n := range f.Names {
+				e.children = append(e.children, o.entry("method", n.Pos(), n.Name,
+					n.Name+strings.TrimPrefix(types.ExprString(f.Type), "func")))
+			}
+		}
+	default:
+		if s.Assign.IsValid() {
+			sig += " ="
+		}
+		if s.Type != nil {
+			sig += " " + types.ExprString(s.Type)
+		}
+	}
+	e.signature = sig
+	return e
+}
+
+// addMethod adds a method to the entry for its receiver type. If inFile is
+// true and the receiver type is not declared in the file, then an entry for
+// the type is created.
+func (o *outliner) addMethod(d *ast.FuncDecl, inFile bool) {
+	if len(d.Recv.List) != 1 {
+		return
+	}
+	name := recvTypeName(d.Recv.List[0].Type)
+	t := o.types[name]
+	if t == nil {
+		if !inFile {
+			return
+		}
+		t = o.entry("type", token.NoPos, name, "type "+name)
+		t.sortPos = o.fset.Position(d.Pos()).Offset
+		o.types[name] = t
+		o.entries = append(o.entries, t)
+	}
+	t.children = append(t.children, o.entry("method", d.Name.Pos(), d.Name.Name, funcSignature(d)))
+}
+
+// addPackageMethods adds the methods declared in the other files of the
+// package to the types in the outline. Types declared in other files with
+// methods in the file are updated with the position of the declaration.
+func (o *outliner) addPackageMethods(bctx *build.Context, pkgName string) {
+	bpkg, _ := bctx.ImportDir(filepath.Dir(o.fname), 0)
+	if bpkg == nil {
+		return
+	}
+	for _, name := range append(append(bpkg.GoFiles, bpkg.CgoFiles...), bpkg.TestGoFiles...) {
+		fname := filepath.Join(bpkg.Dir, name)
+		if fname == o.fname {
+			continue
+		}
+		file, err := parser.ParseFile(o.fset, fname, nil, parser.SkipObjectResolution)
+		if err != nil || file.Name.Name != pkgName {
+			continue
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if s, ok := spec.(*ast.TypeSpec); ok {
+						if t := o.types[s.Name.Name]; t != nil && t.pos.Line == 0 {
+							e := o.typeEntry(s)
+							e.sortPos = t.sortPos
+							e.children = append(e.children, t.children...)
+							*t = *e
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv != nil {
+					o.addMethod(d, false)
+				}
+			}
+		}
+	}
+}
+
+// funcSignature returns the signature of a function or method declaration.
+func funcSignature(d *ast.FuncDecl) string {
+	sig := "func "
+	if d.Recv != nil && len(d.Recv.List) == 1 {
+		r := d.Recv.List[0]
+		sig += "("
+		if len(r.Names) > 0 {
+			sig += r.Names[0].Name + " "
+		}
+		sig += types.ExprString(r.Type) + ") "
+	}
+	return sig + d.Name.Name + strings.TrimPrefix(types.ExprString(d.Type), "func")
+}
+
+// embeddedName returns the field name of an embedded field type.
+func embeddedName(x ast.Expr) string {
+	if star, ok := x.(*ast.StarExpr); ok {
+		x = star.X
+	}
+	switch t := x.(type) {
+	case *ast.IndexExpr:
+		x = t.X
+	case *ast.IndexListExpr:
+		x = t.X
+	}
+	switch t := x.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+	return types.ExprString(x)
+}
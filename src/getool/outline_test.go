@@ -0,0 +1,77 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var outlineTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+import "io"
+
+const (
+	A = 1
+	B string = "b"
+)
+
+type T struct {
+	io.Reader
+	X, Y int
+}
+
+func (t *T) Get() int { return t.X }
+
+type Set[K comparable] interface {
+	Add(k K) bool
+}
+
+func New() *T { return nil }
+
+func (o other) Name() string { return "" }
+
+var v = func(
+`,
+	"a/b.go": `package a
+
+type other struct{}
+
+func (t T) String() string { return "" }
+
+func (o *other) Close() error { return nil }
+`,
+}
+
+func TestOutline(t *testing.T) {
+	dir := writeTestFiles(t, outlineTestFiles)
+
+	b := filepath.Join(dir, "a", "b.go")
+	want := strings.Replace(`0	const	60002		A	const A
+0	const	70002		B	const B string
+0	type	100006		T	type T struct
+1	field	110002		Reader	io.Reader
+1	field	120002		X	X int
+1	field	120005		Y	Y int
+1	method	150013		Get	func (t *T) Get() int
+1	method	50012	$B	String	func (t T) String() string
+0	type	170006		Set	type Set[K comparable] interface
+1	method	180002		Add	Add(k K) bool
+0	func	210006		New	func New() *T
+0	type	30006	$B	other	type other struct
+1	method	230016		Name	func (o other) Name() string
+1	method	70017	$B	Close	func (o *other) Close() error
+0	var	250005		v	var v
+`, "$B", b, -1)
+	var out bytes.Buffer
+	doOutline(&Context{out: &out, in: strings.NewReader(outlineTestFiles["a/a.go"]), cwd: dir, args: []string{"a/a.go"}})
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,673 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/types/objectpath"
+)
+
+func init() {
+	var fs flag.FlagSet
+	offset := fs.Int("offset", -1, "byte `offset` of the identifier to rename")
+	to := fs.String("to", "", "new `name` of the identifier")
+	commands["rename"] = &Command{
+		fs: &fs,
+		do: func(ctx *Context) int { return doRename(ctx, *offset, *to) },
+	}
+}
+
+// doRename renames the identifier at offset in the file named by the command
+// argument. The contents of the file are read from stdin. The result is
+// written using the edit set protocol or as ERR followed by the conflicts
+// that prevent the rename.
+func doRename(ctx *Context, offset int, to string) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 1 {
+		fmt.Fprint(w, "rename: one argument required\n")
+		return 1
+	}
+
+	fname := ctx.args[0]
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(ctx.cwd, fname)
+	}
+	in, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	ctx.overlay = map[string][]byte{fname: in}
+
+	r := &renamer{ctx: ctx, to: to}
+	if err := r.load(fname, offset); err != nil {
+		fmt.Fprintf(w, "ERR\n%s", err)
+		return 0
+	}
+	r.checkConflicts()
+	if len(r.conflicts) > 0 {
+		fmt.Fprintf(w, "ERR\n%s", strings.Join(r.conflicts, "\n"))
+		return 0
+	}
+
+	edits := r.edits()
+	var fnames []string
+	for fname := range edits {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	for _, fname := range fnames {
+		src, ok := ctx.overlay[fname]
+		if !ok {
+			src, err = ioutil.ReadFile(fname)
+			if err != nil {
+				fmt.Fprintf(w, "ERR\n%s", err)
+				return 0
+			}
+		}
+		writeEditSet(w, fname, src, applyEdits(src, edits[fname]))
+	}
+	return 0
+}
+
+// renamer renames a declared object and all references to the object.
+type renamer struct {
+	ctx *Context
+	to  string
+
+	target  types.Object
+	from    string
+	pkgPath string
+	objPath objectpath.Path // empty for objects local to a function
+
+	// The declaring package with its test files followed by the external
+	// test package and the packages that import the declaring package.
+	pkgs []*Package
+
+	conflicts []string
+}
+
+// load finds the object to rename and loads the packages that might refer to
+// the object.
+func (r *renamer) load(fname string, offset int) error {
+	if !token.IsIdentifier(r.to) || r.to == "_" {
+		return fmt.Errorf("%q is not a valid identifier", r.to)
+	}
+
+	pkg, file, err := r.ctx.loadFile(fname)
+	if err != nil {
+		return err
+	}
+	if err := packageError(pkg); err != nil {
+		return err
+	}
+
+	tf := pkg.fset.File(file.Pos())
+	if offset < 0 || offset > tf.Size() {
+		return fmt.Errorf("offset %d out of range", offset)
+	}
+	pos := tf.Pos(offset)
+	path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+	id, ok := path[0].(*ast.Ident)
+	if !ok {
+		return errors.New("no identifier at offset")
+	}
+
+	obj := pkg.info.Defs[id]
+	if v, ok := obj.(*types.Var); ok && v.Embedded() {
+		// Rename the type of the embedded field.
+		obj = pkg.info.Uses[id]
+	}
+	if obj == nil {
+		obj = pkg.info.Uses[id]
+	}
+	switch obj := obj.(type) {
+	case nil:
+		return fmt.Errorf("no object for identifier %s", id.Name)
+	case *types.PkgName:
+		return errors.New("cannot rename package names")
+	case *types.Label:
+		return errors.New("cannot rename labels")
+	default:
+		if obj.Pkg() == nil {
+			return fmt.Errorf("cannot rename predeclared identifier %s", obj.Name())
+		}
+	}
+	obj = origin(obj)
+	r.from = obj.Name()
+	if r.from == r.to {
+		return fmt.Errorf("%s is already named %s", r.from, r.to)
+	}
+	r.pkgPath = obj.Pkg().Path()
+	if isPackageLevel(obj) || isFieldOrMethod(obj) {
+		r.objPath, err = objectpath.For(obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.pkgPath != pkg.tpkg.Path() {
+		// The object is declared in another package. Load the declaring
+		// package and find the object there.
+		if r.objPath == "" {
+			return fmt.Errorf("cannot find declaration of %s", r.from)
+		}
+		bpkg, err := r.ctx.buildContext().Import(r.pkgPath, pkg.bpkg.Dir, build.FindOnly)
+		if err != nil {
+			return err
+		}
+		pkg, err = r.ctx.loadPackageDir(bpkg.Dir, loadTypes|loadTests)
+		if err != nil {
+			return err
+		}
+		if err := packageError(pkg); err != nil {
+			return err
+		}
+		obj, err = objectpath.Object(pkg.tpkg, r.objPath)
+		if err != nil {
+			return err
+		}
+	}
+	r.target = obj
+	r.pkgs = []*Package{pkg}
+
+	if r.objPath == "" || !ast.IsExported(r.from) || pkg.tpkg.Path() != pkg.bpkg.ImportPath {
+		// Only the declaring package refers to the object.
+		return nil
+	}
+
+	if xpkg := r.ctx.loadXTestPackage(pkg.bpkg); xpkg != nil {
+		r.pkgs = append(r.pkgs, xpkg)
+	}
+	for _, dir := range importingDirs(r.ctx.buildContext(), workspaceRoot(pkg.bpkg), r.pkgPath) {
+		if dir == pkg.bpkg.Dir {
+			continue
+		}
+		ipkg, err := r.ctx.loadPackageDir(dir, loadTypes|loadTests)
+		if err != nil {
+			continue
+		}
+		if err := packageError(ipkg); err != nil {
+			return err
+		}
+		r.pkgs = append(r.pkgs, ipkg)
+		if xpkg := r.ctx.loadXTestPackage(ipkg.bpkg); xpkg != nil {
+			r.pkgs = append(r.pkgs, xpkg)
+		}
+	}
+	return nil
+}
+
+// packageError returns an error if pkg has parse or type errors.
+func packageError(pkg *Package) error {
+	if pkg.tpkg == nil && len(pkg.errors) == 0 {
+		return fmt.Errorf("no Go files in %s", pkg.bpkg.Dir)
+	}
+	if len(pkg.errors) == 0 {
+		return nil
+	}
+	var msgs []string
+	for _, err := range pkg.errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("package %s has errors:\n%s", pkg.bpkg.ImportPath, strings.Join(msgs, "\n"))
+}
+
+// matches returns true if obj is the target object or an embedded field of
+// the target type.
+func (r *renamer) matches(obj types.Object) bool {
+	if obj == nil || obj.Pkg() == nil || obj.Name() != r.from {
+		return false
+	}
+	if v, ok := obj.(*types.Var); ok && v.Embedded() {
+		if n, ok := deref(v.Type()).(*types.Named); ok {
+			obj = n.Obj()
+		}
+	}
+	obj = origin(obj)
+	if obj == r.target {
+		return true
+	}
+	if r.objPath == "" || obj.Pkg().Path() != r.pkgPath {
+		return false
+	}
+	p, err := objectpath.For(obj)
+	return err == nil && p == r.objPath
+}
+
+// refs returns the identifiers that refer to the target in pkg.
+func (r *renamer) refs(pkg *Package) []*ast.Ident {
+	var ids []*ast.Ident
+	seen := map[*ast.Ident]bool{}
+	for _, m := range []map[*ast.Ident]types.Object{pkg.info.Defs, pkg.info.Uses} {
+		for id, obj := range m {
+			if !seen[id] && r.matches(obj) {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos() < ids[j].Pos() })
+	return ids
+}
+
+// edits returns the edits for the rename by file name.
+func (r *renamer) edits() map[string][]textEdit {
+	edits := map[string][]textEdit{}
+	for _, pkg := range r.pkgs {
+		for _, id := range r.refs(pkg) {
+			p := pkg.fset.Position(id.Pos())
+			fname := filepath.Join(pkg.bpkg.Dir, p.Filename)
+			edits[fname] = append(edits[fname], textEdit{p.Offset, p.Offset + len(id.Name), r.to})
+		}
+	}
+	return edits
+}
+
+// conflict records a conflict at position pos in pkg. A conflict reported
+// more than once is recorded once.
+func (r *renamer) conflict(pkg *Package, pos token.Pos, format string, args ...interface{}) {
+	p := pkg.fset.Position(pos)
+	msg := fmt.Sprintf("%s:%d:%d: renaming %s to %s %s",
+		filepath.Join(pkg.bpkg.Dir, p.Filename), p.Line, p.Column, r.from, r.to, fmt.Sprintf(format, args...))
+	for _, c := range r.conflicts {
+		if c == msg {
+			return
+		}
+	}
+	r.conflicts = append(r.conflicts, msg)
+}
+
+// targetConflict records a conflict at the declaration of the target. The
+// position of the target is resolved in the declaring package because each
+// package has its own file set.
+func (r *renamer) targetConflict(format string, args ...interface{}) {
+	r.conflict(r.pkgs[0], r.target.Pos(), format, args...)
+}
+
+func (r *renamer) checkConflicts() {
+	pkg := r.pkgs[0]
+	switch {
+	case isPackageLevel(r.target):
+		if obj := r.target.Parent().Lookup(r.to); obj != nil {
+			r.targetConflict("conflicts with %s declared at %s", obj.Name(), pkg.fset.Position(obj.Pos()))
+		}
+		for _, f := range pkg.files {
+			if obj := pkg.info.Scopes[f].Lookup(r.to); obj != nil {
+				r.conflict(pkg, obj.Pos(), "conflicts with imported package name")
+			}
+		}
+		if !ast.IsExported(r.to) {
+			for _, p := range r.pkgs[1:] {
+				if ids := r.refs(p); len(ids) > 0 {
+					r.conflict(p, ids[0].Pos(), "makes it inaccessible from package %s", p.tpkg.Path())
+				}
+			}
+		}
+		r.checkLexical(pkg)
+		for _, p := range r.pkgs[1:] {
+			r.checkDotImports(p)
+		}
+	case isFieldOrMethod(r.target):
+		r.checkFieldOrMethod()
+		if !ast.IsExported(r.to) {
+			for _, p := range r.pkgs[1:] {
+				if ids := r.refs(p); len(ids) > 0 {
+					r.conflict(p, ids[0].Pos(), "makes it inaccessible from package %s", p.tpkg.Path())
+				}
+			}
+		}
+	default:
+		if obj := r.target.Parent().Lookup(r.to); obj != nil {
+			r.targetConflict("conflicts with %s declared at %s", obj.Name(), pkg.fset.Position(obj.Pos()))
+		}
+		r.checkLexical(pkg)
+	}
+}
+
+// checkLexical checks that references to the target are not shadowed by
+// other declarations of the new name and that the renamed object does not
+// capture references to other objects with the new name.
+func (r *renamer) checkLexical(pkg *Package) {
+	scope := r.target.Parent()
+	selected := selectorIdents(pkg)
+
+	for _, id := range r.refs(pkg) {
+		if selected[id] {
+			continue
+		}
+		s := pkg.tpkg.Scope().Innermost(id.Pos())
+		if s == nil {
+			continue
+		}
+		_, obj := s.LookupParent(r.to, id.Pos())
+		if _, ok := obj.(*types.PkgName); ok {
+			// Reported as a conflict with the imported package name.
+			continue
+		}
+		if obj != nil && obj.Parent() != scope && isInner(obj.Parent(), scope) {
+			r.conflict(pkg, id.Pos(), "would shadow this reference with %s declared at %s", obj.Name(), pkg.fset.Position(obj.Pos()))
+		}
+	}
+
+	for id, obj := range pkg.info.Uses {
+		if id.Name != r.to || selected[id] || obj.Parent() == nil {
+			continue
+		}
+		if obj.Parent() == scope || !isInner(scope, obj.Parent()) {
+			continue
+		}
+		// The reference to obj is in the scope of the renamed object.
+		if scope == pkg.tpkg.Scope() || (scope.Contains(id.Pos()) && id.Pos() > r.target.Pos()) {
+			r.conflict(pkg, id.Pos(), "would capture this reference to %s", obj.Name())
+		}
+	}
+}
+
+// checkDotImports checks the files of pkg that dot import the declaring
+// package. The new name must not conflict with the declarations of pkg or the
+// names imported into the file, and references to the target must not be
+// shadowed by local declarations of the new name.
+func (r *renamer) checkDotImports(pkg *Package) {
+	selected := selectorIdents(pkg)
+	refs := r.refs(pkg)
+	for _, f := range pkg.files {
+		var dot *ast.ImportSpec
+		for _, spec := range f.Imports {
+			if spec.Name == nil || spec.Name.Name != "." {
+				continue
+			}
+			if pn, ok := pkg.info.Defs[spec.Name].(*types.PkgName); ok && pn.Imported().Path() == r.pkgPath {
+				dot = spec
+			}
+		}
+		if dot == nil {
+			continue
+		}
+		if obj := pkg.tpkg.Scope().Lookup(r.to); obj != nil {
+			r.conflict(pkg, dot.Pos(), "conflicts with %s declared at %s", obj.Name(), pkg.fset.Position(obj.Pos()))
+		}
+		fileScope := pkg.info.Scopes[f]
+		if obj := fileScope.Lookup(r.to); obj != nil {
+			r.conflict(pkg, dot.Pos(), "conflicts with %s imported into the file", obj.Name())
+		}
+		for _, id := range refs {
+			if id.Pos() < f.Pos() || id.Pos() >= f.End() || selected[id] {
+				continue
+			}
+			s := pkg.tpkg.Scope().Innermost(id.Pos())
+			if s == nil {
+				continue
+			}
+			if _, obj := s.LookupParent(r.to, id.Pos()); obj != nil && obj.Parent() != fileScope && obj.Parent() != pkg.tpkg.Scope() {
+				r.conflict(pkg, id.Pos(), "would shadow this reference with %s declared at %s", obj.Name(), pkg.fset.Position(obj.Pos()))
+			}
+		}
+	}
+}
+
+// checkFieldOrMethod checks for duplicate fields and methods and for types
+// that would no longer implement an interface.
+func (r *renamer) checkFieldOrMethod() {
+	pkg := r.pkgs[0]
+
+	if f, ok := r.target.(*types.Func); ok {
+		recv := f.Type().(*types.Signature).Recv().Type()
+		named, _ := deref(recv).(*types.Named)
+		if named == nil {
+			return
+		}
+		if obj, _, _ := types.LookupFieldOrMethod(named, true, pkg.tpkg, r.to); obj != nil {
+			r.targetConflict("conflicts with %s.%s declared at %s", named.Obj().Name(), r.to, pkg.fset.Position(obj.Pos()))
+		}
+		tnPath, err := objectpath.For(named.Obj())
+		if err != nil {
+			return
+		}
+		if types.IsInterface(named) {
+			r.checkImplementations(named, tnPath)
+		} else {
+			r.checkInterfaces(tnPath)
+		}
+		return
+	}
+
+	// Find the struct type containing the field.
+	for id, obj := range pkg.info.Defs {
+		tn, ok := obj.(*types.TypeName)
+		if !ok {
+			continue
+		}
+		st, ok := tn.Type().Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			if st.Field(i) != r.target {
+				continue
+			}
+			if obj, _, _ := types.LookupFieldOrMethod(tn.Type(), true, pkg.tpkg, r.to); obj != nil {
+				r.conflict(pkg, id.Pos(), "conflicts with %s.%s declared at %s", tn.Name(), r.to, pkg.fset.Position(obj.Pos()))
+			}
+		}
+	}
+}
+
+// checkImplementations checks for types that implement interface iface and
+// would no longer implement the interface after the rename.
+func (r *renamer) checkImplementations(named *types.Named, tnPath objectpath.Path) {
+	for _, pkg := range r.pkgs {
+		obj := r.lookup(pkg, tnPath)
+		if obj == nil {
+			continue
+		}
+		iface := obj.Type().Underlying().(*types.Interface)
+		scope := pkg.tpkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tn == obj || types.IsInterface(tn.Type()) {
+				continue
+			}
+			if types.Implements(tn.Type(), iface) || types.Implements(types.NewPointer(tn.Type()), iface) {
+				r.conflict(pkg, tn.Pos(), "would make %s no longer implement %s", tn.Name(), named.Obj().Name())
+			}
+		}
+	}
+}
+
+// checkInterfaces checks for interfaces implemented by the receiver type of
+// the renamed method that would no longer be implemented after the rename.
+func (r *renamer) checkInterfaces(tnPath objectpath.Path) {
+	for _, pkg := range r.pkgs {
+		obj := r.lookup(pkg, tnPath)
+		if obj == nil {
+			continue
+		}
+		T := obj.Type()
+		scopes := []*types.Scope{pkg.tpkg.Scope()}
+		for _, imp := range pkg.tpkg.Imports() {
+			scopes = append(scopes, imp.Scope())
+		}
+		seen := map[types.Object]bool{}
+		for _, scope := range scopes {
+			for _, name := range scope.Names() {
+				tn, ok := scope.Lookup(name).(*types.TypeName)
+				if !ok || seen[tn] || !tn.Exported() && tn.Pkg() != pkg.tpkg {
+					continue
+				}
+				seen[tn] = true
+				iface, ok := tn.Type().Underlying().(*types.Interface)
+				if !ok || iface.NumMethods() == 0 {
+					continue
+				}
+				hasMethod := false
+				for i := 0; i < iface.NumMethods(); i++ {
+					if iface.Method(i).Name() == r.from {
+						hasMethod = true
+					}
+				}
+				if hasMethod && (types.Implements(T, iface) || types.Implements(types.NewPointer(T), iface)) {
+					r.targetConflict("would make %s no longer implement %s.%s", obj.Name(), tn.Pkg().Name(), tn.Name())
+				}
+			}
+		}
+	}
+}
+
+// lookup returns the object with path p in the declaring package as seen
+// from pkg.
+func (r *renamer) lookup(pkg *Package, p objectpath.Path) types.Object {
+	if pkg.tpkg.Path() == r.pkgPath {
+		obj, _ := objectpath.Object(pkg.tpkg, p)
+		return obj
+	}
+	for _, imp := range pkg.tpkg.Imports() {
+		if imp.Path() == r.pkgPath {
+			obj, _ := objectpath.Object(imp, p)
+			return obj
+		}
+	}
+	return nil
+}
+
+// selectorIdents returns the identifiers in pkg that are selected by a
+// selector expression. These identifiers are not resolved lexically.
+func selectorIdents(pkg *Package) map[*ast.Ident]bool {
+	m := map[*ast.Ident]bool{}
+	for _, f := range pkg.files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				m[n.Sel] = true
+			case *ast.KeyValueExpr:
+				// Field names in composite literals.
+				if id, ok := n.Key.(*ast.Ident); ok {
+					if _, ok := pkg.info.Uses[id].(*types.Var); ok {
+						m[id] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	return m
+}
+
+// isInner returns true if scope inner is nested in scope outer.
+func isInner(inner, outer *types.Scope) bool {
+	for s := inner; s != nil; s = s.Parent() {
+		if s == outer {
+			return true
+		}
+	}
+	return false
+}
+
+func isPackageLevel(obj types.Object) bool {
+	return obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope()
+}
+
+func isFieldOrMethod(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Var:
+		return obj.IsField()
+	case *types.Func:
+		return obj.Type().(*types.Signature).Recv() != nil
+	}
+	return false
+}
+
+// origin returns the generic object for an instantiated field or method.
+func origin(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Var:
+		return o.Origin()
+	case *types.Func:
+		return o.Origin()
+	}
+	return obj
+}
+
+func deref(t types.Type) types.Type {
+	if p, ok := t.(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return t
+}
+
+// workspaceRoot returns the root of the source tree containing bpkg: the
+// directory containing the go.mod file or the GOPATH source directory.
+func workspaceRoot(bpkg *build.Package) string {
+	for dir := bpkg.Dir; ; {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if bpkg.SrcRoot != "" {
+		return bpkg.SrcRoot
+	}
+	return bpkg.Dir
+}
+
+// workspaceDirs returns the directories under root that might contain a
+// package.
+func workspaceDirs(root string) []string {
+	var dirs []string
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		name := fi.Name()
+		if path != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor") {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs
+}
+
+// importingDirs returns the directories under root containing a package or
+// test that imports importPath.
+func importingDirs(bctx *build.Context, root string, importPath string) []string {
+	var dirs []string
+	for _, dir := range workspaceDirs(root) {
+		bpkg, err := bctx.ImportDir(dir, 0)
+		if err != nil {
+			continue
+		}
+	imports:
+		for _, imports := range [][]string{bpkg.Imports, bpkg.TestImports, bpkg.XTestImports} {
+			for _, imp := range imports {
+				if imp == importPath {
+					dirs = append(dirs, dir)
+					break imports
+				}
+			}
+		}
+	}
+	return dirs
+}
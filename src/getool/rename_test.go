@@ -0,0 +1,160 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var renameTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+func Hello(x int) int {
+	y := x + 1
+	return y
+}
+
+func Other() int { return Hello(1) }
+`,
+	"b/b.go": `package b
+
+import "example.com/ws/a"
+
+func F() int { return a.Hello(2) }
+`,
+}
+
+var renameTests = []struct {
+	ident string // identifier at the start of this text is renamed
+	to    string
+	out   string
+}{
+	{
+		"Hello(x", "Greet",
+		"FILE $/a/a.go\nREPL 8 8 1\nfunc Other() int { return Greet(1) }\nREPL 3 3 1\nfunc Greet(x int) int {\n" +
+			"FILE $/b/b.go\nREPL 5 5 1\nfunc F() int { return a.Greet(2) }\n",
+	},
+	{
+		"Hello(x", "Other",
+		"ERR\n$/a/a.go:3:6: renaming Hello to Other conflicts with Other declared at a.go:8:6",
+	},
+	{
+		"Hello(x", "hello",
+		"ERR\n$/b/b.go:5:25: renaming Hello to hello makes it inaccessible from package example.com/ws/b",
+	},
+	{
+		"y := x", "x",
+		"ERR\n$/a/a.go:4:2: renaming y to x conflicts with x declared at a.go:3:12",
+	},
+	{
+		"y := x", "z",
+		"FILE $/a/a.go\nREPL 4 5 2\n\tz := x + 1\n\treturn z\n",
+	},
+}
+
+func TestRename(t *testing.T) {
+	dir := writeTestFiles(t, renameTestFiles)
+
+	src := renameTestFiles["a/a.go"]
+	for _, tt := range renameTests {
+		var buf bytes.Buffer
+		doRename(&Context{
+			out:  &buf,
+			in:   strings.NewReader(src),
+			cwd:  dir,
+			args: []string{"a/a.go"},
+		}, strings.Index(src, tt.ident), tt.to)
+		out := strings.Replace(buf.String(), dir, "$", -1)
+		if out != tt.out {
+			t.Errorf("rename %s to %s = %q, want %q", tt.ident, tt.to, out, tt.out)
+		}
+	}
+}
+
+var renameConflictTestFiles = map[string]string{
+	"go.mod": "module example.com/ws\n",
+	"a/a.go": `package a
+
+type T struct{}
+
+func (T) M() {}
+
+func Hello() int { return 1 }
+`,
+	"b/b.go": `package b
+
+import "example.com/ws/a"
+
+type I interface{ M() }
+
+var _ I = a.T{}
+`,
+	"b/b_test.go": `package b_test
+
+import (
+	"example.com/ws/a"
+	"example.com/ws/b"
+)
+
+var _ b.I = a.T{}
+`,
+	"c/c.go": `package c
+
+import . "example.com/ws/a"
+
+var Greeting = 1
+
+func F() int {
+	Salute := 2
+	return Hello() + Salute
+}
+`,
+}
+
+var renameConflictTests = []struct {
+	ident string // identifier at the start of this text is renamed
+	to    string
+	out   string
+}{
+	{
+		"M()", "N",
+		"ERR\n$/a/a.go:5:10: renaming M to N would make T no longer implement b.I",
+	},
+	{
+		"Hello()", "Greeting",
+		"ERR\n$/c/c.go:3:8: renaming Hello to Greeting conflicts with Greeting declared at c.go:5:5",
+	},
+	{
+		"Hello()", "Salute",
+		"ERR\n$/c/c.go:9:9: renaming Hello to Salute would shadow this reference with Salute declared at c.go:8:2",
+	},
+	{
+		"Hello()", "Greet",
+		"FILE $/a/a.go\nREPL 7 7 1\nfunc Greet() int { return 1 }\n" +
+			"FILE $/c/c.go\nREPL 9 9 1\n\treturn Greet() + Salute\n",
+	},
+}
+
+func TestRenameConflicts(t *testing.T) {
+	dir := writeTestFiles(t, renameConflictTestFiles)
+
+	src := renameConflictTestFiles["a/a.go"]
+	for _, tt := range renameConflictTests {
+		var buf bytes.Buffer
+		doRename(&Context{
+			out:  &buf,
+			in:   strings.NewReader(src),
+			cwd:  dir,
+			args: []string{"a/a.go"},
+		}, strings.Index(src, tt.ident), tt.to)
+		out := strings.Replace(buf.String(), dir, "$", -1)
+		if out != tt.out {
+			t.Errorf("rename %s to %s = %q, want %q", tt.ident, tt.to, out, tt.out)
+		}
+	}
+}
@@ -5,10 +5,27 @@
 package main
 
 import (
+	"go/ast"
 	"go/doc"
+	"go/types"
+	"io/ioutil"
 	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
+// filter returns the items for which keep returns true.
+func filter[T any](items []T, keep func(T) bool) []T {
+	var result []T
+	for _, item := range items {
+		if keep(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 func untangleDoc(dpkg *doc.Package) {
 	for _, t := range dpkg.Types {
 		dpkg.Consts = append(dpkg.Consts, t.Consts...)
@@ -40,13 +57,26 @@ var packageNamePats = []*regexp.Regexp{
 	regexp.MustCompile(`([^/]+)$`),
 }
 
-// guessNameFromPath guesses the package name from the package path.
+var majorVersionPat = regexp.MustCompile(`^(.+)/v(?:[2-9]|[1-9]\d+)$`)
+
+// guessNameFromPath guesses the package name from the package path. Use
+// packageNamer to get the name of packages that are available locally.
 func guessNameFromPath(path string) string {
-	// Guess the package name without importing it.
+	// Remove module major version suffix: github.com/user/repo/v2.
+	if m := majorVersionPat.FindStringSubmatch(path); m != nil {
+		path = m[1]
+	}
 	for _, pat := range packageNamePats {
 		m := pat.FindStringSubmatch(path)
 		if m != nil {
-			return m[1]
+			// Package names are identifiers.
+			name := m[1]
+			if i := strings.IndexFunc(name, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+			}); i > 0 {
+				name = name[:i]
+			}
+			return name
 		}
 	}
 	return ""
@@ -57,3 +87,67 @@ type byFuncName []*doc.Func
 func (s byFuncName) Len() int           { return len(s) }
 func (s byFuncName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s byFuncName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// fileQualifier returns a qualifier that names packages as they are imported
+// in file. The package name is used for packages not imported in file.
+func fileQualifier(file *ast.File, pkg *types.Package) types.Qualifier {
+	return func(p *types.Package) string {
+		if p.Path() == pkg.Path() {
+			return ""
+		}
+		for _, s := range file.Imports {
+			path, _ := strconv.Unquote(s.Path.Value)
+			if path != p.Path() || s.Name == nil || s.Name.Name == "_" {
+				continue
+			}
+			if s.Name.Name == "." {
+				return ""
+			}
+			return s.Name.Name
+		}
+		return p.Name()
+	}
+}
+
+// zeroValue returns an expression for the zero value of type t.
+func zeroValue(t types.Type, qf types.Qualifier) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		case u.Kind() == types.UnsafePointer:
+			return "nil"
+		}
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+		if _, ok := t.(*types.TypeParam); !ok {
+			return "nil"
+		}
+	case *types.Struct, *types.Array:
+		return types.TypeString(t, qf) + "{}"
+	}
+	return "*new(" + types.TypeString(t, qf) + ")"
+}
+
+// buildConstraint returns the //go:build line in the header of the file
+// fname or "" if there is no such line.
+func buildConstraint(fname string) string {
+	p, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(p), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "//go:build "):
+			return line
+		case line != "" && !strings.HasPrefix(line, "//"):
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The protocol version is incremented when a change to the output of a
+// command requires a change to the plugin. Version history:
+//
+//	1 - The initial protocol.
+//	2 - The resolve-package command prints the anchor of a symbol on a second
+//	    line. Pages for the callers and callees commands use the N record.
+const (
+	protocolVersion = 2
+
+	// minProtocolVersion is the oldest version supported with the -protocol
+	// flag.
+	minProtocolVersion = 1
+)
+
+// docRecords are the record types in the doc protocol.
+var docRecords = []string{"S", "L", "A", "D", "E", "N"}
+
+func init() {
+	var fs flag.FlagSet
+	commands["version"] = &Command{
+		fs: &fs,
+		do: doVersion,
+	}
+}
+
+// doVersion implements the command
+//
+//	version
+//
+// The command prints the protocol version, the oldest protocol version
+// supported with the -protocol flag and the capabilities of the program. The
+// output is the lines
+//
+//	protocol version
+//	compatible version
+//	doc record...
+//	flags flag...
+//	command name flag...
+//
+// with a command line for each command. The flags line lists the global
+// flags.
+func doVersion(ctx *Context) int {
+	w := bufio.NewWriter(ctx.out)
+	defer w.Flush()
+
+	if len(ctx.args) != 0 {
+		fmt.Fprint(w, "version: no arguments allowed\n")
+		return 1
+	}
+	fmt.Fprintf(w, "protocol %d\n", protocolVersion)
+	fmt.Fprintf(w, "compatible %d\n", minProtocolVersion)
+	fmt.Fprintf(w, "doc %s\n", strings.Join(docRecords, " "))
+	fmt.Fprintf(w, "flags%s\n", flagNames(flag.CommandLine))
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "command %s%s\n", name, flagNames(commands[name].fs))
+	}
+	return 0
+}
+
+// flagNames returns the names of the flags in fs, each preceded by " -".
+func flagNames(fs *flag.FlagSet) string {
+	var buf strings.Builder
+	fs.VisitAll(func(f *flag.Flag) {
+		buf.WriteString(" -" + f.Name)
+	})
+	return buf.String()
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 Gary Burd. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if code := doVersion(&Context{out: &buf}); code != 0 {
+		t.Fatalf("version returned %d", code)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	want := []string{
+		fmt.Sprintf("protocol %d", protocolVersion),
+		fmt.Sprintf("compatible %d", minProtocolVersion),
+		"doc S L A D E N",
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("line %d = %q, want %q", i, lines[i], line)
+		}
+	}
+	for _, line := range []string{"command version", "command test -bench -offset"} {
+		if !contains(lines, line) {
+			t.Errorf("output does not contain %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestResolveProtocol(t *testing.T) {
+	for _, tt := range []struct {
+		protocol int
+		out      string
+	}{
+		{0, "net/http\nClient"},
+		{1, "net/http"},
+		{2, "net/http\nClient"},
+	} {
+		var buf bytes.Buffer
+		doResolvePackage(&Context{out: &buf, in: strings.NewReader(""), protocol: tt.protocol, args: []string{"net/http.Client"}}, "")
+		if buf.String() != tt.out {
+			t.Errorf("protocol %d: resolve = %q, want %q", tt.protocol, buf.String(), tt.out)
+		}
+	}
+}
@@ -7,16 +7,28 @@ if exists('b:current_syntax')
 endif
 
 syntax case match
-syntax region godocSection start='^[^ \t)}]' end='^[^ \t)}]'me=e-1 fold contains=godocDecl,godocHead
+syntax region godocSection start='^[^ \t)}]' end='^[^ \t)}]'me=e-1 fold contains=godocDecl,godocHead,godocDirMark,godocDeprecatedTag,@godocNote
 
-syntax region godocDecl start='^\(package\|const\|var\|func\|type\) ' end='^$' contained contains=godocComment,godocParen,godocBrace
-syntax region godocParen start='(' end=')' contained contains=godocComment,godocParen,godocBrace
-syntax region godocBrace start='{' end='}' contained contains=godocComment,godocParen,godocBrace
-syntax region godocComment start='/\*' end='\*/'  contained
-syntax region godocComment start='//' end='$' contained
+" The TESTS section is a single fold.
+syntax region godocTests matchgroup=godocHead start='^TESTS$' end='^\u\+$'me=s-1 fold contains=godocSection
+
+" Deprecated declarations are grouped at the end of a section.
+syntax region godocDeprecated matchgroup=godocDeprecatedHead start='^Deprecated \(constants\|variables\|functions\|types\)$' end='^\u\+$'me=s-1 fold contains=godocSection
+
+syntax region godocDecl start='^\(package\|const\|var\|func\|type\) ' end='^$' contained contains=godocComment,godocParen,godocBrace,godocDeprecatedTag,@godocNote
+syntax region godocParen start='(' end=')' contained contains=godocComment,godocParen,godocBrace,@godocNote
+syntax region godocBrace start='{' end='}' contained contains=godocComment,godocParen,godocBrace,@godocNote
+syntax region godocComment start='/\*' end='\*/'  contained contains=godocDeprecatedTag
+syntax region godocComment start='//' end='$' contained contains=godocDeprecatedTag,@godocNote
 
 syntax match godocHead '\n\n\n    [^\t ].*$' contained
 syntax match godocHead '^[A-Z].*$' contained
+syntax match godocDirMark '(\(testdata\|no Go files\|internal\|deprecated\))' contained
+syntax match godocDeprecatedTag '\[deprecated\]\|\<Deprecated:' contained
+syntax match godocVersion '  \zssince Go 1\.\d\+\ze\(, .*\)\=$' contained
+syntax match godocNewVersion '  \zsrequires Go 1\.\d\+\ze\(, .*\)\=$' contained
+syntax match godocCoverage '\(  \|, \)\zs\d\+\.\d% covered$' contained
+syntax cluster godocNote contains=godocVersion,godocNewVersion,godocCoverage
 
 syntax sync fromstart
 
@@ -25,6 +37,12 @@ highlight link godocHead Constant
 highlight link godocDecl Type
 highlight link godocParen Type
 highlight link godocBrace Type
+highlight link godocDirMark Comment
+highlight link godocDeprecatedHead Comment
+highlight link godocDeprecatedTag WarningMsg
+highlight link godocVersion Comment
+highlight link godocNewVersion WarningMsg
+highlight link godocCoverage Comment
 
 let b:current_syntax = 'gedoc'
 